@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// AudioSampleEntry - The sample entry of audio tracks, e.g. ‘mp4a’
+// Box Type: ‘mp4a’, ‘enca’, ...
+// Container: Sample Description Box (‘stsd’)
+// Mandatory: Yes
+// Quantity: One or more
+type AudioSampleEntry struct {
+	*Box
+	DataReferenceIndex uint16
+	SoundVersion       uint16 // Version of the QuickTime sound sample description, 0 in ISO files.
+	ChannelCount       uint16
+	SampleSize         uint16
+	SampleRate         Fixed32 // 16.16, the integer part is the sampling rate in Hz.
+	Esds               *ESDescriptorBox
+	Btrt               *BitRateBox
+	Sinf               *ProtectionSchemeInfoBox // Protection of ‘enca’ entries.
+}
+
+// audioEntryHeaderSize returns the number of payload bytes preceding the
+// children of an audio sample entry. QuickTime sound sample descriptions of
+// version 1 and 2 extend the 28 bytes of the ISO layout by 16 and 36 bytes.
+func audioEntryHeaderSize(b *Box) int64 {
+	if !b.Reader.IsQuickTime() || b.PayloadSize() < 28 {
+		return 28
+	}
+	buf := b.Reader.ReadBytesAt(2, b.PayloadOffset()+8)
+	if len(buf) < 2 {
+		return 28
+	}
+	switch binary.BigEndian.Uint16(buf) {
+	case 1:
+		return 28 + 16
+	case 2:
+		return 28 + 36
+	}
+	return 28
+}
+
+func (b *AudioSampleEntry) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 28 {
+		return b.errorf("audio sample entry is too short")
+	}
+	// reserved [6]uint8 [0:6]
+	b.DataReferenceIndex = binary.BigEndian.Uint16(data[6:8])
+	// reserved [2]uint32 [8:16], version, revision level and vendor in QuickTime
+	b.ChannelCount = binary.BigEndian.Uint16(data[16:18])
+	b.SampleSize = binary.BigEndian.Uint16(data[18:20])
+	// pre_defined uint16, reserved uint16 [20:24]
+	b.SampleRate = fixed32(data[24:28])
+
+	skip := audioEntryHeaderSize(b.Box)
+	if int64(len(data)) < skip {
+		return b.errorf("audio sample entry is too short")
+	}
+	if skip > 28 {
+		b.SoundVersion = binary.BigEndian.Uint16(data[8:10])
+	}
+	if b.SoundVersion == 2 {
+		// The version 2 fields replace the ISO ones: sizeOfStructOnly [28:32],
+		// audioSampleRate float64 [32:40], numAudioChannels [40:44].
+		rate := math.Float64frombits(binary.BigEndian.Uint64(data[32:40]))
+		if rate > 0 && rate < 65536 {
+			b.SampleRate = Fixed32(uint32(rate * 65536))
+		}
+		b.ChannelCount = uint16(binary.BigEndian.Uint32(data[40:44]))
+	}
+
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset()+skip, b.PayloadSize()-skip)
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "esds":
+			b.Esds = &ESDescriptorBox{Box: box}
+			parseBox(b.Esds)
+		case "btrt":
+			b.Btrt = &BitRateBox{Box: box}
+			parseBox(b.Btrt)
+		case "sinf":
+			if b.Sinf == nil {
+				b.Sinf = &ProtectionSchemeInfoBox{Box: box}
+				if err := parseBox(b.Sinf); err != nil {
+					return b.wrapError(err)
+				}
+			}
+		case "wave":
+			// QuickTime nests the esds box in the sound decompression parameters.
+			children, err := box.children()
+			if err != nil {
+				return b.wrapError(err)
+			}
+			for _, child := range children {
+				if child.Name == "esds" && b.Esds == nil {
+					b.Esds = &ESDescriptorBox{Box: child}
+					parseBox(b.Esds)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Descriptor tags used inside the esds box (ISO/IEC 14496-1).
+const (
+	esDescrTag            = 0x03
+	decoderConfigDescrTag = 0x04
+	decSpecificInfoTag    = 0x05
+)
+
+// ESDescriptorBox - This box carries the MPEG-4 elementary stream descriptor of the track
+// Box Type: ‘esds’
+// Container: Audio Sample Entry (‘mp4a’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type ESDescriptorBox struct {
+	*Box
+	Version uint8
+	Flags   [3]byte
+	ESID    uint16
+
+	// DecoderConfigDescriptor
+	ObjectTypeIndication uint8 // 0x40 for MPEG-4 audio (AAC).
+	StreamType           uint8 // 0x05 for audio streams.
+	BufferSizeDB         uint32
+	MaxBitrate           uint32
+	AvgBitrate           uint32
+	DecoderSpecificInfo  []byte
+
+	// AudioSpecificConfig decoded from DecoderSpecificInfo.
+	AudioObjectType        uint8 // 2 for AAC LC.
+	SamplingFrequencyIndex uint8
+	SamplingFrequency      uint32
+	ChannelConfiguration   uint8
+}
+
+// aacSamplingFrequencies maps a samplingFrequencyIndex to a rate in Hz.
+var aacSamplingFrequencies = []uint32{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// readDescriptor reads a descriptor tag and its expandable size, returning the
+// descriptor payload and the bytes following it.
+func readDescriptor(data []byte) (tag uint8, payload []byte, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated descriptor")
+	}
+	tag = data[0]
+	size, i := 0, 1
+	for {
+		if i >= len(data) || i > 4 {
+			return 0, nil, nil, fmt.Errorf("invalid size of descriptor 0x%02x", tag)
+		}
+		c := data[i]
+		i++
+		size = size<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if size > len(data)-i {
+		return 0, nil, nil, fmt.Errorf("descriptor 0x%02x exceeds the box", tag)
+	}
+	return tag, data[i : i+size], data[i+size:], nil
+}
+
+func (b *ESDescriptorBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+
+	tag, es, _, err := readDescriptor(data[4:])
+	if err != nil {
+		return b.wrapError(err)
+	}
+	if tag != esDescrTag || len(es) < 3 {
+		return b.errorf("missing ES_Descriptor")
+	}
+	b.ESID = binary.BigEndian.Uint16(es[0:2])
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 { // streamDependenceFlag
+		if len(es) < 2 {
+			return b.errorf("truncated ES_Descriptor")
+		}
+		es = es[2:]
+	}
+	if flags&0x40 != 0 { // URL_Flag
+		if len(es) < 1 || len(es) < 1+int(es[0]) {
+			return b.errorf("truncated ES_Descriptor")
+		}
+		es = es[1+int(es[0]):]
+	}
+	if flags&0x20 != 0 { // OCRstreamFlag
+		if len(es) < 2 {
+			return b.errorf("truncated ES_Descriptor")
+		}
+		es = es[2:]
+	}
+
+	for len(es) > 0 {
+		tag, payload, rest, err := readDescriptor(es)
+		if err != nil {
+			return b.wrapError(err)
+		}
+		es = rest
+		if tag != decoderConfigDescrTag {
+			continue
+		}
+		if len(payload) < 13 {
+			return b.errorf("truncated DecoderConfigDescriptor")
+		}
+		b.ObjectTypeIndication = payload[0]
+		b.StreamType = payload[1] >> 2
+		b.BufferSizeDB = uint32(payload[2])<<16 | uint32(payload[3])<<8 | uint32(payload[4])
+		b.MaxBitrate = binary.BigEndian.Uint32(payload[5:9])
+		b.AvgBitrate = binary.BigEndian.Uint32(payload[9:13])
+
+		for config := payload[13:]; len(config) > 0; {
+			tag, info, rest, err := readDescriptor(config)
+			if err != nil {
+				return b.wrapError(err)
+			}
+			config = rest
+			if tag == decSpecificInfoTag {
+				b.DecoderSpecificInfo = info
+				b.parseAudioSpecificConfig()
+			}
+		}
+	}
+	return nil
+}
+
+// parseAudioSpecificConfig decodes the leading fields of the AudioSpecificConfig
+// of MPEG-4 audio streams.
+func (b *ESDescriptorBox) parseAudioSpecificConfig() {
+	if len(b.DecoderSpecificInfo) < 2 {
+		return
+	}
+	r := &bitReader{data: b.DecoderSpecificInfo}
+	b.AudioObjectType = uint8(r.read(5))
+	if b.AudioObjectType == 31 {
+		b.AudioObjectType = uint8(32 + r.read(6))
+	}
+	b.SamplingFrequencyIndex = uint8(r.read(4))
+	if b.SamplingFrequencyIndex == 0x0f {
+		b.SamplingFrequency = r.read(24)
+	} else if int(b.SamplingFrequencyIndex) < len(aacSamplingFrequencies) {
+		b.SamplingFrequency = aacSamplingFrequencies[b.SamplingFrequencyIndex]
+	}
+	b.ChannelConfiguration = uint8(r.read(4))
+}
+
+// bitReader reads big-endian bit fields, returning zeros past the end of data.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) (v uint32) {
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos/8 < len(r.data) {
+			v |= uint32(r.data[r.pos/8]>>(7-uint(r.pos%8))) & 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+// AudioSampleEntry returns the first audio sample entry of the track, or nil if
+// the track has none.
+func (b *TrackBox) AudioSampleEntry() *AudioSampleEntry {
+	stbl, err := b.sampleTable()
+	if err != nil || stbl.Stsd == nil {
+		return nil
+	}
+	return stbl.Stsd.Audio
+}
+
+// ADTSHeaderSize is the size of an ADTS header without CRC.
+const ADTSHeaderSize = 7
+
+// adtsHeader builds the ADTS header of an AAC frame of frameSize payload bytes.
+func adtsHeader(esds *ESDescriptorBox, frameSize int) ([]byte, error) {
+	if esds.AudioObjectType < 1 || esds.AudioObjectType > 4 {
+		return nil, fmt.Errorf("audio object type %d cannot be carried in ADTS", esds.AudioObjectType)
+	}
+	if esds.SamplingFrequencyIndex >= 0x0f {
+		return nil, fmt.Errorf("explicit sampling frequency %d cannot be carried in ADTS", esds.SamplingFrequency)
+	}
+	length := ADTSHeaderSize + frameSize
+	if length >= 1<<13 {
+		return nil, fmt.Errorf("AAC frame of %d bytes is too large for ADTS", frameSize)
+	}
+
+	profile := esds.AudioObjectType - 1
+	channels := esds.ChannelConfiguration
+	return []byte{
+		0xff, // syncword
+		0xf1, // syncword, MPEG-4, layer 0, no CRC
+		profile<<6 | esds.SamplingFrequencyIndex<<2 | channels>>2,
+		(channels&0x03)<<6 | byte(length>>11),
+		byte(length >> 3),
+		byte(length&0x07)<<5 | 0x1f, // buffer fullness 0x7ff (VBR)
+		0xfc,
+	}, nil
+}
+
+// ExtractADTS writes the samples of an AAC audio track to w as an ADTS stream,
+// prefixing each raw AAC frame with a header built from the track's esds.
+func ExtractADTS(track *TrackBox, w io.Writer) error {
+	entry := track.AudioSampleEntry()
+	if entry == nil || entry.Esds == nil {
+		return fmt.Errorf("track has no esds box")
+	}
+	if entry.Esds.ObjectTypeIndication != 0x40 {
+		return fmt.Errorf("track is not MPEG-4 audio (object type 0x%02x)", entry.Esds.ObjectTypeIndication)
+	}
+
+	return track.forEachSample(func(sample Sample, data []byte) error {
+		header, err := adtsHeader(entry.Esds, len(data))
+		if err != nil {
+			return fmt.Errorf("sample %d: %v", sample.Number, err)
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
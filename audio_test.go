@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// quickTime turns a file built by buildFile into a QuickTime movie by changing
+// its major brand.
+func quickTime(data []byte) []byte {
+	copy(data[8:12], BrandQT)
+	return data
+}
+
+func TestQuickTimeSoundDescriptions(t *testing.T) {
+	esds := buildFullBox("esds", 0, 0, []byte{esDescrTag, 3, 0, 1, 0})
+	wave := buildContainer("wave", buildBox("frma", []byte("mp4a")), esds, be32(0))
+	tests := []struct {
+		name     string
+		version  uint16
+		fields   []byte // Version 1 and 2 fields following the ISO layout.
+		channels uint16
+		rate     float64
+	}{
+		{"version 0", 0, nil, 2, 44100},
+		{"version 1", 1, be32s(1024, 0, 4, 2), 2, 44100},
+		{"version 2", 2, cat(be32(72), be64(math.Float64bits(48000)), be32(6), be32s(0x7f000000, 16, 0, 0, 1024)), 6, 48000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := buildBox("mp4a", cat(make([]byte, 6), be16(1), be16(tt.version), be16(0), be32(0),
+				be16(2), be16(16), be16(0), be16(0), be32(44100<<16), tt.fields, wave))
+			m := parseFile(t, quickTime(buildFile(testTrack{id: 1, handler: "soun", entry: entry})))
+			if !m.IsQuickTime() {
+				t.Fatal("not detected as QuickTime")
+			}
+			audio := m.Moov.Traks[0].Mdia.Minf.Stbl.Stsd.Audio
+			if audio.SoundVersion != tt.version || audio.ChannelCount != tt.channels || audio.SampleRate.Float64() != tt.rate {
+				t.Errorf("sound version %d, %d channels at %v Hz; want %d, %d at %v",
+					audio.SoundVersion, audio.ChannelCount, audio.SampleRate.Float64(), tt.version, tt.channels, tt.rate)
+			}
+			if audio.Esds == nil || audio.Esds.ESID != 1 {
+				t.Errorf("esds = %v, want the one of the wave atom", audio.Esds)
+			}
+		})
+	}
+}
+
+func TestQuickTimeDetection(t *testing.T) {
+	data := buildFile(testTrack{id: 1})
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"isom", data, false},
+		{"qt brand", quickTime(append([]byte(nil), data...)), true},
+		{"no ftyp", data[len(buildBox("ftyp", make([]byte, 20))):], true},
+	}
+	for _, tt := range tests {
+		if got := parseFile(t, tt.data).IsQuickTime(); got != tt.want {
+			t.Errorf("%s: IsQuickTime() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAtomListTerminator(t *testing.T) {
+	udta := buildContainer("udta", buildBox("\xa9too", []byte("encoder")), be32(0))
+	m := parseFile(t, quickTime(buildFile(testTrack{id: 1, trak: [][]byte{udta}})))
+	found := false
+	err := m.Walk(func(box *Box, depth int) error {
+		found = found || box.Name == "\xa9too"
+		return nil
+	})
+	if err != nil || !found {
+		t.Errorf("Walk() error %v, found the \xa9too box: %v", err, found)
+	}
+}
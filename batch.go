@@ -0,0 +1,50 @@
+package main
+
+import "sync"
+
+// ParseResult holds the outcome of parsing a single file.
+type ParseResult struct {
+	Reader *Mp4Reader
+	Err    error
+}
+
+// ParseFiles opens and parses the files at paths using at most concurrency
+// workers, and returns the results keyed by path. Unless keepOpen is set, each
+// file is closed once it has been parsed, so only the parsed boxes remain usable.
+func ParseFiles(paths []string, concurrency int, keepOpen bool) map[string]ParseResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan string)
+	results := make(map[string]ParseResult, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				mp4, err := Open(path)
+				if mp4 != nil && (err != nil || !keepOpen) {
+					mp4.Close()
+				}
+				if err != nil {
+					mp4 = nil
+				}
+
+				mu.Lock()
+				results[path] = ParseResult{Reader: mp4, Err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
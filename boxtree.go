@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// containerBoxes lists the boxes whose payload holds child boxes, mapped to the
+// number of payload bytes preceding the first child.
+var containerBoxes = map[string]int64{
+	"moov": 0,
+	"trak": 0,
+	"tref": 0,
+	"edts": 0,
+	"mdia": 0,
+	"minf": 0,
+	"dinf": 0,
+	"stbl": 0,
+	"mvex": 0,
+	"moof": 0,
+	"traf": 0,
+	"udta": 0,
+	"meta": 4, // version and flags, absent in QuickTime files
+	"ilst": 0,
+	"iinf": 6, // version, flags and entry_count, 32-bit from version 1
+	"iprp": 0,
+	"ipco": 0,
+	"dref": 8,  // version, flags and entry_count
+	"stsd": 8,  // version, flags and entry_count
+	"avc1": 78, // VisualSampleEntry fields
+	"avc3": 78,
+	"hvc1": 78,
+	"hev1": 78,
+	"encv": 78,
+	"mp4v": 78,
+	"av01": 78,
+	"vp08": 78,
+	"vp09": 78,
+	"mp4a": 28, // AudioSampleEntry fields, more in QuickTime files
+	"enca": 28,
+	"wave": 0, // QuickTime sound decompression parameters
+	"sinf": 0,
+	"schi": 0,
+	"mfra": 0,
+	"gmhd": 0, // QuickTime base media header
+	"tapt": 0, // QuickTime track aperture modes
+	"hnti": 0,
+	"hinf": 0,
+	"rinf": 0,
+	"strk": 0,
+	"strd": 0,
+	"meco": 0,
+}
+
+// containerHeaderSize returns the number of payload bytes preceding the
+// children of a container box, taking the layouts that depend on the box
+// contents into account. ok is false if the box is not a known container.
+func containerHeaderSize(b *Box) (skip int64, ok bool) {
+	skip, ok = containerBoxes[b.Name]
+	switch b.Name {
+	case "meta":
+		skip = metaHeaderSize(b)
+	case "iinf":
+		skip = itemInfoHeaderSize(b)
+	case "mp4a", "enca":
+		skip = audioEntryHeaderSize(b)
+	}
+	if !ok {
+		skip, ok = registeredContainer(b.Name)
+	}
+	if !ok && b.Reader.Options.DetectContainers && looksLikeContainer(b) {
+		return 0, true
+	}
+	return skip, ok
+}
+
+// looksLikeContainer reports whether the payload of the box is exactly tiled
+// by box headers with printable four-char codes. Media data, padding and uuid
+// boxes are never taken for containers.
+func looksLikeContainer(b *Box) bool {
+	switch b.Name {
+	case "mdat", "free", "skip", "wide", "uuid":
+		return false
+	}
+	offset, end := b.PayloadOffset(), b.Start+b.Size
+	if end-offset < BoxHeaderSize {
+		return false
+	}
+	for offset < end {
+		size, name := b.Reader.ReadBoxAt(offset)
+		if int64(size) < BoxHeaderSize || int64(size) > end-offset || !isPrintableCode(name) {
+			return false
+		}
+		offset += int64(size)
+	}
+	return true
+}
+
+// isPrintableCode reports whether all four characters of a box type are
+// printable ASCII or ‘©’, as in the iTunes tags.
+func isPrintableCode(name string) bool {
+	if len(name) != 4 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; (c < 0x20 || c > 0x7e) && c != 0xa9 {
+			return false
+		}
+	}
+	return true
+}
+
+// children reads the immediate children of a container box, or returns nil if
+// the box is not a known container.
+func (b *Box) children() ([]*Box, error) {
+	skip, ok := containerHeaderSize(b)
+	if !ok || b.PayloadSize() < skip {
+		return nil, nil
+	}
+	return readBoxes(b.Reader, b.PayloadOffset()+skip, b.PayloadSize()-skip)
+}
+
+// Children returns the immediate children of a container box, or nil if the box
+// is not a known container. Boxes following a malformed child are left out; use
+// Walk to learn about such errors. A nil box has no children, so lookups can be
+// chained: moov.Child("trak").Child("mdia").
+func (b *Box) Children() []*Box {
+	if b == nil {
+		return nil
+	}
+	children, _ := b.children()
+	return children
+}
+
+// Child returns the first immediate child of the box with the given four-char
+// code, or nil if there is none.
+func (b *Box) Child(name string) *Box {
+	for _, child := range b.Children() {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for every box of the file in depth-first order, descending into
+// the known container boxes. Depth is 0 for top-level boxes. Walk stops at the
+// first error returned by fn.
+func (m *Mp4Reader) Walk(fn func(box *Box, depth int) error) error {
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return err
+	}
+	return walkBoxes(boxes, 0, fn)
+}
+
+// BoxCodes returns the four-char codes of all boxes of the file with the number
+// of times each occurs, walking the tree like Walk. Unknown boxes are counted
+// without being descended into. On a malformed box the codes found up to that
+// point are returned with the error.
+func (m *Mp4Reader) BoxCodes() (map[string]int, error) {
+	codes := make(map[string]int)
+	err := m.Walk(func(box *Box, depth int) error {
+		codes[box.Name]++
+		return nil
+	})
+	return codes, err
+}
+
+func walkBoxes(boxes []*Box, depth int, fn func(box *Box, depth int) error) error {
+	for _, box := range boxes {
+		if err := box.Reader.checkDepth(box, depth); err != nil {
+			return err
+		}
+		if err := fn(box, depth); err != nil {
+			return err
+		}
+		children, err := box.children()
+		if err != nil {
+			return err
+		}
+		if err := walkBoxes(children, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var boxPtrType = reflect.TypeOf((*Box)(nil))
+
+// isBoxType reports whether t is *Box, a pointer to a struct embedding *Box, or
+// a slice of either.
+func isBoxType(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t == boxPtrType {
+		return true
+	}
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || t.Elem().NumField() == 0 {
+		return false
+	}
+	field := t.Elem().Field(0)
+	return field.Anonymous && field.Type == boxPtrType
+}
+
+// indexBoxes records every parsed box structure reachable from v by its file offset.
+func indexBoxes(v reflect.Value, index map[int64]interface{}) {
+	switch v.Kind() {
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			indexBoxes(v.Index(i), index)
+		}
+		return
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == boxPtrType || !isBoxType(v.Type()) {
+			return
+		}
+	default:
+		return
+	}
+
+	s := v.Elem()
+	box := s.Field(0).Interface().(*Box)
+	if box == nil {
+		return
+	}
+	index[box.Start] = v.Interface()
+	for i := 1; i < s.NumField(); i++ {
+		if s.Type().Field(i).PkgPath == "" && isBoxType(s.Field(i).Type()) {
+			indexBoxes(s.Field(i), index)
+		}
+	}
+}
+
+// parsedBoxes returns the parsed box structures of the file by file offset.
+func (m *Mp4Reader) parsedBoxes() map[int64]interface{} {
+	index := make(map[int64]interface{})
+	indexBoxes(reflect.ValueOf(m.Ftyp), index)
+	indexBoxes(reflect.ValueOf(m.Styp), index)
+	indexBoxes(reflect.ValueOf(m.Moov), index)
+	indexBoxes(reflect.ValueOf(m.Mdat), index)
+	indexBoxes(reflect.ValueOf(m.Moofs), index)
+	indexBoxes(reflect.ValueOf(m.Sidxs), index)
+	indexBoxes(reflect.ValueOf(m.Prfts), index)
+	indexBoxes(reflect.ValueOf(m.Emsgs), index)
+	indexBoxes(reflect.ValueOf(m.Meta), index)
+	return index
+}
+
+// boxFields returns the decoded fields of a parsed box structure, leaving out
+// the embedded Box, child boxes and fields tagged with `json:"-"`.
+func boxFields(v interface{}) map[string]interface{} {
+	s := reflect.ValueOf(v).Elem()
+	fields := make(map[string]interface{})
+	for i := 1; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" || isBoxType(field.Type) {
+			continue
+		}
+		fields[field.Name] = s.Field(i).Interface()
+	}
+	return fields
+}
+
+type jsonBox struct {
+	Name     string                 `json:"name"`
+	Size     int64                  `json:"size"`
+	Start    int64                  `json:"start"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	Value    interface{}            `json:"value,omitempty"`
+	Children []*jsonBox             `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the box tree of the file. Every box carries its name,
+// size and start offset, and the boxes known to the parser their decoded fields.
+func (m *Mp4Reader) MarshalJSON() ([]byte, error) {
+	index := m.parsedBoxes()
+
+	var build func(boxes []*Box, depth int) ([]*jsonBox, error)
+	build = func(boxes []*Box, depth int) ([]*jsonBox, error) {
+		var l []*jsonBox
+		for _, box := range boxes {
+			if err := m.checkDepth(box, depth); err != nil {
+				return nil, err
+			}
+			node := &jsonBox{Name: box.Name, Size: box.Size, Start: box.Start, Value: box.Value}
+			if typed, ok := index[box.Start]; ok {
+				node.Fields = boxFields(typed)
+			}
+			children, err := box.children()
+			if err != nil {
+				return nil, err
+			}
+			if node.Children, err = build(children, depth+1); err != nil {
+				return nil, err
+			}
+			l = append(l, node)
+		}
+		return l, nil
+	}
+
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := build(boxes, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Size  int64      `json:"size"`
+		Boxes []*jsonBox `json:"boxes"`
+	}{m.Size, tree})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildBox serializes a box with a 32-bit size from its payload.
+func buildBox(name string, payload []byte) []byte {
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], name)
+	return append(buf, payload...)
+}
+
+// buildFullBox serializes a full box, its payload following version and flags.
+func buildFullBox(name string, version uint8, flags uint32, payload []byte) []byte {
+	return buildBox(name, cat(be32(uint32(version)<<24|flags&0xffffff), payload))
+}
+
+// buildContainer serializes a box whose payload is its children.
+func buildContainer(name string, children ...[]byte) []byte {
+	return buildBox(name, cat(children...))
+}
+
+// cat concatenates byte slices.
+func cat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// be32s serializes a table of 32-bit values.
+func be32s(values ...uint32) []byte {
+	var buf []byte
+	for _, v := range values {
+		buf = append(buf, be32(v)...)
+	}
+	return buf
+}
+
+// identityMatrix is the unity transformation matrix of mvhd and tkhd.
+var identityMatrix = [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// testTrack describes a track built by buildFile. Zero fields take defaults.
+type testTrack struct {
+	id        uint32
+	handler   string // vide by default.
+	timescale uint32 // 1000 by default
+	delta     uint32 // Duration of every sample, 40 by default.
+	samples   [][]byte
+	perChunk  int       // Samples per chunk, all samples in one chunk when 0.
+	sync      []uint32  // Entries of an stss box, none when nil.
+	matrix    [9]uint32 // identityMatrix when zero.
+	width     uint16    // Coded and presentation size of video tracks, 320x240 by default.
+	height    uint16
+	entry     []byte   // Sample entry, avc1 or mp4a by default.
+	dref      [][]byte // Data entries, a self-contained url entry by default.
+	stbl      [][]byte // Extra stbl children.
+	minf      [][]byte // Extra minf children.
+	trak      [][]byte // Extra trak children.
+}
+
+// buildFile serializes an ftyp+moov+mdat file holding the tracks, their
+// samples stored in track order in the mdat box.
+func buildFile(tracks ...testTrack) []byte {
+	ftyp := buildBox("ftyp", cat([]byte("isom"), be32(0x200), []byte("isomiso2mp41")))
+	moov := buildMoov(tracks, 0)
+	offset := uint32(len(ftyp) + len(moov) + 8)
+	moov = buildMoov(tracks, offset)
+
+	var mdat []byte
+	for _, t := range tracks {
+		for _, s := range t.samples {
+			mdat = append(mdat, s...)
+		}
+	}
+	return cat(ftyp, moov, buildBox("mdat", mdat))
+}
+
+// buildMoov builds the moov box of buildFile, with the media data at offset.
+func buildMoov(tracks []testTrack, offset uint32) []byte {
+	var traks [][]byte
+	nextTrackID := uint32(1)
+	for _, t := range tracks {
+		t = t.withDefaults()
+		var trak []byte
+		trak, offset = t.build(offset)
+		traks = append(traks, trak)
+		if t.id >= nextTrackID {
+			nextTrackID = t.id + 1
+		}
+	}
+	return buildContainer("moov", append([][]byte{buildMvhd(1000, 1000, nextTrackID)}, traks...)...)
+}
+
+// buildMvhd builds a version 0 movie header box.
+func buildMvhd(timescale, duration, nextTrackID uint32) []byte {
+	return buildFullBox("mvhd", 0, 0, cat(
+		be32s(0, 0, timescale, duration, 0x00010000), be16(0x0100), make([]byte, 10),
+		be32s(identityMatrix[:]...), make([]byte, 24), be32(nextTrackID)))
+}
+
+func (t testTrack) withDefaults() testTrack {
+	if t.handler == "" {
+		t.handler = "vide"
+	}
+	if t.timescale == 0 {
+		t.timescale = 1000
+	}
+	if t.delta == 0 {
+		t.delta = 40
+	}
+	if t.matrix == [9]uint32{} {
+		t.matrix = identityMatrix
+	}
+	if t.width == 0 && t.height == 0 && t.handler == "vide" {
+		t.width, t.height = 320, 240
+	}
+	if t.entry == nil {
+		if t.handler == "soun" {
+			t.entry = buildAudioEntry("mp4a")
+		} else {
+			t.entry = buildVisualEntry("avc1", t.width, t.height, buildBox("avcC", testAVCC))
+		}
+	}
+	return t
+}
+
+// build returns the trak box of the track, its samples stored from offset on,
+// and the offset following them.
+func (t testTrack) build(offset uint32) ([]byte, uint32) {
+	count := uint32(len(t.samples))
+	duration := count * t.delta
+	tkhd := buildFullBox("tkhd", 0, 3, cat(
+		be32s(0, 0, t.id, 0, duration), make([]byte, 8), be16(0), be16(0), be16(0), be16(0),
+		be32s(t.matrix[:]...), be32(uint32(t.width)<<16), be32(uint32(t.height)<<16)))
+	mdhd := buildFullBox("mdhd", 0, 0, cat(be32s(0, 0, t.timescale, duration), be16(0x55c4), be16(0)))
+	hdlr := buildFullBox("hdlr", 0, 0, cat(be32(0), []byte(t.handler), make([]byte, 12), []byte{0}))
+	var header []byte
+	switch t.handler {
+	case "vide":
+		header = buildFullBox("vmhd", 0, 1, make([]byte, 8))
+	case "soun":
+		header = buildFullBox("smhd", 0, 0, make([]byte, 4))
+	case "hint":
+		header = buildFullBox("hmhd", 0, 0, make([]byte, 16))
+	default:
+		header = buildFullBox("nmhd", 0, 0, nil)
+	}
+	dref := t.dref
+	if dref == nil {
+		dref = [][]byte{buildFullBox("url ", 0, 1, nil)}
+	}
+	dinf := buildContainer("dinf", buildFullBox("dref", 0, 0, cat(be32(uint32(len(dref))), cat(dref...))))
+
+	perChunk := t.perChunk
+	if perChunk == 0 {
+		perChunk = len(t.samples)
+	}
+	var sizes, offsets, counts []uint32
+	for i, s := range t.samples {
+		if i%perChunk == 0 {
+			offsets = append(offsets, offset)
+			counts = append(counts, 0)
+		}
+		counts[len(counts)-1]++
+		sizes = append(sizes, uint32(len(s)))
+		offset += uint32(len(s))
+	}
+	// One stsc entry for every run of chunks with the same sample count.
+	var stsc []uint32
+	for i, n := range counts {
+		if i == 0 || n != counts[i-1] {
+			stsc = append(stsc, uint32(i+1), n, 1)
+		}
+	}
+	stbl := [][]byte{
+		buildFullBox("stsd", 0, 0, cat(be32(1), t.entry)),
+		buildFullBox("stts", 0, 0, be32s(1, count, t.delta)),
+		buildFullBox("stsc", 0, 0, cat(be32(uint32(len(stsc)/3)), be32s(stsc...))),
+		buildFullBox("stsz", 0, 0, cat(be32s(0, count), be32s(sizes...))),
+		buildFullBox("stco", 0, 0, cat(be32(uint32(len(offsets))), be32s(offsets...))),
+	}
+	if t.sync != nil {
+		stbl = append(stbl, buildFullBox("stss", 0, 0, cat(be32(uint32(len(t.sync))), be32s(t.sync...))))
+	}
+	stbl = append(stbl, t.stbl...)
+
+	minf := buildContainer("minf", append([][]byte{header, dinf, buildContainer("stbl", stbl...)}, t.minf...)...)
+	trak := buildContainer("trak", append([][]byte{tkhd, buildContainer("mdia", mdhd, hdlr, minf)}, t.trak...)...)
+	return trak, offset
+}
+
+// testAVCC is an avcC payload with 4-byte NAL unit lengths, one SPS and one PPS.
+var testAVCC = []byte{1, 0x42, 0xc0, 0x1e, 0xff, 0xe1, 0, 4, 0x67, 0x42, 0xc0, 0x1e, 1, 0, 2, 0x68, 0xce}
+
+// buildVisualEntry builds a visual sample entry with the given children.
+func buildVisualEntry(name string, width, height uint16, children ...[]byte) []byte {
+	return buildBox(name, cat(
+		make([]byte, 6), be16(1), make([]byte, 16), be16(width), be16(height),
+		be32s(0x00480000, 0x00480000, 0), be16(1), make([]byte, 32), be16(0x0018), be16(0xffff),
+		cat(children...)))
+}
+
+// buildAudioEntry builds a version 0 audio sample entry for stereo 16-bit
+// samples at 48 kHz with the given children.
+func buildAudioEntry(name string, children ...[]byte) []byte {
+	return buildBox(name, cat(
+		make([]byte, 6), be16(1), make([]byte, 8), be16(2), be16(16), be16(0), be16(0),
+		be32(48000<<16), cat(children...)))
+}
+
+// parseFile parses data, failing the test on error.
+func parseFile(t *testing.T, data []byte, opts ...Option) *Mp4Reader {
+	t.Helper()
+	m, err := NewReader(bytes.NewReader(data), int64(len(data)), opts...)
+	if err != nil {
+		t.Fatalf("parsing file: %v", err)
+	}
+	return m
+}
+
+// parseInvalid parses data, failing the test if it parses without error.
+func parseInvalid(t *testing.T, data []byte) error {
+	t.Helper()
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("parsed without error")
+	}
+	return err
+}
+
+// writeFile writes data to a file of a temporary directory and returns its path.
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// topBox parses data holding a single top-level box and returns that box.
+func topBox(t *testing.T, data []byte) *Box {
+	t.Helper()
+	m := parseFile(t, data)
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil || len(boxes) != 1 {
+		t.Fatalf("reading box: %d boxes, %v", len(boxes), err)
+	}
+	return boxes[0]
+}
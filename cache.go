@@ -0,0 +1,103 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+// Option configures an Mp4Reader created by Open or NewReader.
+type Option func(*Mp4Reader)
+
+// WithReadCache wraps the reader in an LRU cache of up to blocks aligned blocks
+// of blockSize bytes, so that the many small header and table reads of Parse
+// do not each reach the operating system. Reads of at least blockSize bytes,
+// such as sample reads, bypass the cache. The cache costs up to
+// blockSize*blocks bytes of memory.
+func WithReadCache(blockSize, blocks int) Option {
+	return func(m *Mp4Reader) {
+		if blockSize > 0 && blocks > 0 {
+			m.Reader = newBlockCache(m.Reader, m.Size, int64(blockSize), blocks)
+		}
+	}
+}
+
+// blockCache is an io.ReaderAt keeping recently read blocks of the underlying
+// reader. It is safe for concurrent use.
+type blockCache struct {
+	r         io.ReaderAt
+	size      int64
+	blockSize int64
+	maxBlocks int
+
+	mu     sync.Mutex
+	lru    *list.List // Most recently used block first.
+	blocks map[int64]*list.Element
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+func newBlockCache(r io.ReaderAt, size, blockSize int64, maxBlocks int) *blockCache {
+	return &blockCache{
+		r:         r,
+		size:      size,
+		blockSize: blockSize,
+		maxBlocks: maxBlocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+	}
+}
+
+// block returns the data of the block with the given index, reading it on a miss.
+func (c *blockCache) block(index int64) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.blocks[index]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedBlock).data, nil
+	}
+
+	start := index * c.blockSize
+	n := c.blockSize
+	if start+n > c.size {
+		n = c.size - start
+	}
+	if n <= 0 {
+		return nil, io.EOF
+	}
+	data := make([]byte, n)
+	if _, err := c.r.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	c.blocks[index] = c.lru.PushFront(&cachedBlock{index: index, data: data})
+	if c.lru.Len() > c.maxBlocks {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*cachedBlock).index)
+	}
+	return data, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *blockCache) ReadAt(p []byte, off int64) (n int, err error) {
+	if int64(len(p)) >= c.blockSize {
+		return c.r.ReadAt(p, off)
+	}
+	for n < len(p) {
+		pos := off + int64(n)
+		data, err := c.block(pos / c.blockSize)
+		if err != nil {
+			return n, err
+		}
+		i := pos % c.blockSize
+		if i >= int64(len(data)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[i:])
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// countingReader counts the ReadAt calls reaching the underlying reader.
+type countingReader struct {
+	r     io.ReaderAt
+	reads int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&c.reads, 1)
+	return c.r.ReadAt(p, off)
+}
+
+func TestReadCacheReads(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 50; i++ {
+		samples = append(samples, bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	data := buildFile(testTrack{id: 1, samples: samples, perChunk: 5, sync: []uint32{1, 26}},
+		testTrack{id: 2, handler: "soun", samples: samples[:20], perChunk: 1})
+
+	parse := func(opts ...Option) (*Mp4Reader, int64) {
+		r := &countingReader{r: bytes.NewReader(data)}
+		m, err := NewReader(r, int64(len(data)), opts...)
+		if err != nil {
+			t.Fatalf("parsing file: %v", err)
+		}
+		return m, atomic.LoadInt64(&r.reads)
+	}
+	_, uncached := parse()
+	m, cached := parse(WithReadCache(4096, 4))
+
+	// All the boxes Parse reads lie in the first block.
+	if cached != 1 {
+		t.Errorf("%d reads with the cache, want 1 (%d without)", cached, uncached)
+	}
+	if uncached <= cached {
+		t.Errorf("%d reads without the cache, want more than %d", uncached, cached)
+	}
+	for i, want := range samples {
+		got, err := m.Moov.Traks[0].ReadSample(uint32(i + 1))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("sample %d = %v, %v; want %v", i+1, got, err, want)
+		}
+	}
+}
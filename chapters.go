@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Chapter is a named point in the timeline of the movie.
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// ChapterListBox - The Nero chapter list, written by many tools next to or instead of a chapter track
+// Box Type: ‘chpl’
+// Container: User Data Box (‘udta’)
+// Mandatory: No
+// Quantity: Zero or one
+type ChapterListBox struct {
+	*Box
+	Version  uint8
+	Flags    [3]byte
+	Chapters []Chapter
+}
+
+// chplTimescale is the unit of chpl start times, 100 nanoseconds.
+const chplTimescale = 10000000
+
+func (b *ChapterListBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 5 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	offset := 4
+	if b.Version == 1 {
+		offset += 4 // reserved
+	}
+	if offset >= len(data) {
+		return b.errorf("box is too short")
+	}
+	count := int(data[offset])
+	offset++
+
+	b.Chapters = make([]Chapter, 0, count)
+	for i := 0; i < count; i++ {
+		if offset+9 > len(data) {
+			return b.errorf("chapter %d does not fit in the box", i+1)
+		}
+		start := binary.BigEndian.Uint64(data[offset : offset+8])
+		n := int(data[offset+8])
+		offset += 9
+		if offset+n > len(data) {
+			return b.errorf("chapter %d does not fit in the box", i+1)
+		}
+		b.Chapters = append(b.Chapters, Chapter{
+			Start: ticksToDuration(start, chplTimescale),
+			Title: string(data[offset : offset+n]),
+		})
+		offset += n
+	}
+	return nil
+}
+
+// Chapters returns the chapters of the movie. The text track referenced with
+// ‘chap’ from another track is preferred, QuickTime style, over the Nero chpl
+// box of the movie user data. It returns nil if the file has no chapters.
+func (m *Mp4Reader) Chapters() ([]Chapter, error) {
+	if m.Moov == nil {
+		return nil, nil
+	}
+	for _, trak := range m.Moov.Traks {
+		for _, id := range trak.References("chap") {
+			if chap, err := m.TrackByID(id); err == nil {
+				return chap.chapters()
+			}
+		}
+	}
+	if m.Moov.Udta != nil && m.Moov.Udta.Chpl != nil {
+		return m.Moov.Udta.Chpl.Chapters, nil
+	}
+	return nil, nil
+}
+
+// chapters reads the samples of a chapter text track. Each sample holds a title
+// starting at its decoding time.
+func (b *TrackBox) chapters() ([]Chapter, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return nil, err
+	}
+	if stbl.Stts == nil || b.Mdia.Mdhd == nil || b.Mdia.Mdhd.Timescale == 0 {
+		return nil, fmt.Errorf("chapter track has no stts box or media timescale")
+	}
+	dts := decodeTimes(stbl.Stts)
+
+	var chapters []Chapter
+	err = b.forEachSample(func(sample Sample, data []byte) error {
+		if int(sample.Number) > len(dts) {
+			return fmt.Errorf("chapter sample %d has no decoding time", sample.Number)
+		}
+		chapters = append(chapters, Chapter{
+			Start: ticksToDuration(dts[sample.Number-1], b.Mdia.Mdhd.Timescale),
+			Title: textSampleString(data),
+		})
+		return nil
+	})
+	return chapters, err
+}
+
+// textSampleString decodes the string of a QuickTime text sample: a 16-bit
+// length followed by UTF-8 text, or UTF-16 text when it starts with a byte
+// order mark. Any trailing style boxes are ignored.
+func textSampleString(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(data[0:2]))
+	text := data[2:]
+	if n < len(text) {
+		text = text[:n]
+	}
+	if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+		units := make([]uint16, 0, len(text)/2-1)
+		for i := 2; i+2 <= len(text); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(text[i:i+2]))
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(text)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Checksum returns the CRC-32 (IEEE) of the box data, the payload following the
+// box header; the size and type of the header are left out. For full boxes the
+// version and flags are included. The data is streamed from the file, so large
+// boxes such as mdat are not loaded into memory.
+func (b *Box) Checksum() (uint32, error) {
+	h := crc32.NewIEEE()
+	if err := b.hashData(h); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// hashData writes the box data to h.
+func (b *Box) hashData(h hash.Hash) error {
+	n, err := io.Copy(h, b.DataReader())
+	if err != nil {
+		return b.wrapError(err)
+	}
+	if n != b.PayloadSize() {
+		return b.errorf("box is truncated, read %d of %d bytes", n, b.PayloadSize())
+	}
+	return nil
+}
+
+// Fingerprint returns the hex-encoded SHA-256 of the moov box, header and data,
+// as a stable identity of the file metadata: files with the same fingerprint
+// describe the same tracks and samples. The media data is not included. Chunk
+// offsets are part of moov, so the same movie laid out differently, e.g. after
+// Faststart, has another fingerprint.
+func (m *Mp4Reader) Fingerprint() (string, error) {
+	if m.Moov == nil {
+		return "", fmt.Errorf("no moov box found")
+	}
+	h := sha256.New()
+	h.Write(m.ReadBytesAt(BoxHeaderSize, m.Moov.Start))
+	if err := m.Moov.hashData(h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DataInformationBox - The data information box contains objects that declare the location of the media information in a track
+// Box Type: ‘dinf’
+// Container: Media Information Box (‘minf’) or Meta Box (‘meta’)
+// Mandatory: Yes (required within ‘minf’ box)
+// Quantity: Exactly one
+type DataInformationBox struct {
+	*Box
+	Dref *DataReferenceBox
+}
+
+func (b *DataInformationBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "dref":
+			b.Dref = &DataReferenceBox{Box: box}
+			if err := parseBox(b.Dref); err != nil {
+				return b.wrapError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// DataReferenceBox - The data reference object contains a table of data references which declare the location(s) of the media data
+// Box Type: ‘dref’
+// Container: Data Information Box (‘dinf’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type DataReferenceBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []*DataEntryBox // Indexed by the data_reference_index of sample entries, minus one.
+}
+
+func (b *DataReferenceBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset()+8, b.PayloadSize()-8)
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		entry := &DataEntryBox{Box: box}
+		if err := parseBox(entry); err != nil {
+			return b.wrapError(err)
+		}
+		b.Entries = append(b.Entries, entry)
+	}
+	return nil
+}
+
+// DataEntrySelfContained is the flag of data entries whose media data is in
+// the same file as the movie box.
+const DataEntrySelfContained = 0x000001
+
+// DataEntryBox - A data reference entry, a URL or a URN locating the media data
+// Box Type: ‘url ’, ‘urn ’
+// Container: Data Reference Box (‘dref’)
+// Mandatory: Yes
+// Quantity: One or more
+type DataEntryBox struct {
+	*Box
+	Version  uint8
+	Flags    [3]byte
+	URN      string // Only set for ‘urn ’ entries.
+	Location string // Empty for self-contained entries.
+}
+
+func (b *DataEntryBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+
+	// Both entry types hold null-terminated UTF-8 strings: urn has a name and a
+	// location, url only a location.
+	fields := bytes.Split(bytes.TrimRight(data[4:], "\x00"), []byte{0})
+	switch b.Name {
+	case "url ":
+		b.Location = string(fields[0])
+	case "urn ":
+		b.URN = string(fields[0])
+		if len(fields) > 1 {
+			b.Location = string(fields[1])
+		}
+	}
+	return nil
+}
+
+// SelfContained reports whether the media data of the entry is in this file.
+func (b *DataEntryBox) SelfContained() bool {
+	return flags24(b.Flags)&DataEntrySelfContained != 0
+}
+
+// IsSelfContained reports whether all media data of the track is stored in
+// this file. Tracks without a data reference box are assumed to be.
+func (b *TrackBox) IsSelfContained() bool {
+	if b.Mdia == nil || b.Mdia.Minf == nil || b.Mdia.Minf.Dinf == nil || b.Mdia.Minf.Dinf.Dref == nil {
+		return true
+	}
+	for _, entry := range b.Mdia.Minf.Dinf.Dref.Entries {
+		if !entry.SelfContained() {
+			return false
+		}
+	}
+	return true
+}
+
+// dataEntry returns the data reference of the sample entry with the 1-based
+// index, or nil if it cannot be told. Every sample entry starts with six
+// reserved bytes and its data_reference_index.
+func (b *TrackBox) dataEntry(descIndex uint32) *DataEntryBox {
+	stbl, err := b.sampleTable()
+	if err != nil || stbl.Stsd == nil || b.Mdia.Minf.Dinf == nil || b.Mdia.Minf.Dinf.Dref == nil {
+		return nil
+	}
+	if descIndex == 0 || descIndex > uint32(len(stbl.Stsd.Entries)) {
+		return nil
+	}
+	entry := stbl.Stsd.Entries[descIndex-1]
+	data := b.Reader.ReadBytesAt(2, entry.PayloadOffset()+6)
+	if len(data) < 2 {
+		return nil
+	}
+	index := int(binary.BigEndian.Uint16(data))
+	entries := b.Mdia.Minf.Dinf.Dref.Entries
+	if index == 0 || index > len(entries) {
+		return nil
+	}
+	return entries[index-1]
+}
+
+// isExternal reports whether the samples described by the sample entry with
+// the 1-based index are stored in another file.
+func (b *TrackBox) isExternal(descIndex uint32) bool {
+	entry := b.dataEntry(descIndex)
+	return entry != nil && !entry.SelfContained()
+}
+
+// sampleData returns the reader holding the media data of the sample: the
+// file itself, or the external file of its data reference as resolved by
+// ExternalDataResolver.
+func (b *TrackBox) sampleData(sample Sample) (io.ReaderAt, error) {
+	if b.IsSelfContained() {
+		return b.Reader.Reader, nil
+	}
+	entry := b.dataEntry(sample.DescriptionIndex)
+	if entry == nil || entry.SelfContained() {
+		return b.Reader.Reader, nil
+	}
+	return b.Reader.externalData(entry.Location)
+}
+
+// externalData resolves the location of an external data reference with
+// ExternalDataResolver, once per location.
+func (m *Mp4Reader) externalData(location string) (io.ReaderAt, error) {
+	if m.ExternalDataResolver == nil {
+		return nil, fmt.Errorf("media data is stored in the external file %q, set ExternalDataResolver to read it", location)
+	}
+	m.externalMu.Lock()
+	defer m.externalMu.Unlock()
+	if r, ok := m.external[location]; ok {
+		return r, nil
+	}
+	r, err := m.ExternalDataResolver(location)
+	if err != nil {
+		return nil, fmt.Errorf("resolving external media data %q: %v", location, err)
+	}
+	if m.external == nil {
+		m.external = make(map[string]io.ReaderAt)
+	}
+	m.external[location] = r
+	return r, nil
+}
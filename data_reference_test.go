@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDataReferences(t *testing.T) {
+	type entry struct{ name, urn, location string }
+	tests := []struct {
+		name          string
+		dref          [][]byte
+		want          []entry
+		selfContained bool
+	}{
+		{"self-contained url", [][]byte{buildFullBox("url ", 0, 1, nil)},
+			[]entry{{name: "url "}}, true},
+		{"external url", [][]byte{buildFullBox("url ", 0, 0, []byte("media.mdat\x00"))},
+			[]entry{{"url ", "", "media.mdat"}}, false},
+		{"urn", [][]byte{buildFullBox("urn ", 0, 0, []byte("urn:x-media\x00media.mdat\x00"))},
+			[]entry{{"urn ", "urn:x-media", "media.mdat"}}, false},
+		{"self-contained and external", [][]byte{buildFullBox("url ", 0, 1, nil), buildFullBox("url ", 0, 0, []byte("b.mp4\x00"))},
+			[]entry{{name: "url "}, {"url ", "", "b.mp4"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trak := parseFile(t, buildFile(testTrack{id: 1, dref: tt.dref})).Moov.Traks[0]
+			dref := trak.Mdia.Minf.Dinf.Dref
+			if dref.EntryCount != uint32(len(tt.want)) || len(dref.Entries) != len(tt.want) {
+				t.Fatalf("entry count %d, %d entries; want %d", dref.EntryCount, len(dref.Entries), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := dref.Entries[i]
+				if (entry{got.Name, got.URN, got.Location}) != want {
+					t.Errorf("entry %d = %s %q %q, want %+v", i, got.Name, got.URN, got.Location, want)
+				}
+			}
+			if got := trak.IsSelfContained(); got != tt.selfContained {
+				t.Errorf("IsSelfContained() = %v, want %v", got, tt.selfContained)
+			}
+		})
+	}
+}
+
+// closeCounter counts the Close calls of the reader it wraps.
+type closeCounter struct {
+	*bytes.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestCloseExternalData(t *testing.T) {
+	data := buildFile(testTrack{id: 1, samples: [][]byte{[]byte("ab")},
+		dref: [][]byte{buildFullBox("url ", 0, 0, []byte("media.mdat\x00"))}})
+	m := parseFile(t, data)
+	external := &closeCounter{Reader: bytes.NewReader(data)}
+	m.ExternalDataResolver = func(url string) (io.ReaderAt, error) {
+		return external, nil
+	}
+	if sample, err := m.Moov.Traks[0].ReadSample(1); err != nil || string(sample) != "ab" {
+		t.Fatalf("ReadSample(1) = %q, %v", sample, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if external.closed != 1 {
+		t.Errorf("external reader closed %d times, want 1", external.closed)
+	}
+}
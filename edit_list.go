@@ -0,0 +1,84 @@
+package main
+
+import "encoding/binary"
+
+// EditBox - This box maps the presentation time-line to the media time-line as it is stored in the file
+// Box Type: ‘edts’
+// Container: Track Box (‘trak’)
+// Mandatory: No
+// Quantity: Zero or one
+type EditBox struct {
+	*Box
+	Elst *EditListBox
+}
+
+func (b *EditBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	for _, box := range boxes {
+		switch box.Name {
+		case "elst":
+			b.Elst = &EditListBox{Box: box}
+			parseBox(b.Elst)
+		}
+	}
+	return nil
+}
+
+// EditListEntry is a single edit of the track timeline.
+type EditListEntry struct {
+	SegmentDuration uint64  // Duration of the edit in the movie timescale.
+	MediaTime       int64   // Starting time within the media in the media timescale, -1 for an empty edit.
+	MediaRate       Fixed32 // Relative rate at which to play the media, 16.16.
+}
+
+// EditListBox - This box contains an explicit timeline map
+// Box Type: ‘elst’
+// Container: Edit Box (‘edts’)
+// Mandatory: No
+// Quantity: Zero or one
+type EditListBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []EditListEntry
+}
+
+func (b *EditListBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+
+	entrySize := 12
+	if b.Version == 1 {
+		entrySize = 20
+	}
+	if uint64(len(data)-8) < uint64(b.EntryCount)*uint64(entrySize) {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	b.Entries = make([]EditListEntry, b.EntryCount)
+	for i := range b.Entries {
+		entry := data[8+entrySize*i:]
+		if b.Version == 1 {
+			b.Entries[i].SegmentDuration = binary.BigEndian.Uint64(entry[0:8])
+			b.Entries[i].MediaTime = int64(binary.BigEndian.Uint64(entry[8:16]))
+			b.Entries[i].MediaRate = fixed32(entry[16:20])
+		} else {
+			b.Entries[i].SegmentDuration = uint64(binary.BigEndian.Uint32(entry[0:4]))
+			b.Entries[i].MediaTime = int64(int32(binary.BigEndian.Uint32(entry[4:8])))
+			b.Entries[i].MediaRate = fixed32(entry[8:12])
+		}
+	}
+	return nil
+}
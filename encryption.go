@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// SencUseSubsampleEncryption is the senc flag signalling that each sample
+// carries a subsample map.
+const SencUseSubsampleEncryption = 0x000002
+
+// Subsample is a range of a sample made of clear bytes followed by encrypted bytes.
+type Subsample struct {
+	BytesOfClearData     uint16
+	BytesOfProtectedData uint32
+}
+
+// SampleEncryptionEntry holds the encryption parameters of a single sample.
+type SampleEncryptionEntry struct {
+	IV         []byte
+	Subsamples []Subsample
+}
+
+// SampleEncryptionBox - This box contains the sample specific encryption data (Common Encryption)
+// Box Type: ‘senc’
+// Container: Track Fragment Box (‘traf’) or Sample Table Box (‘stbl’)
+// Mandatory: No
+// Quantity: Zero or one
+type SampleEncryptionBox struct {
+	*Box
+	Version     uint8
+	Flags       [3]byte
+	SampleCount uint32
+	Entries     []SampleEncryptionEntry
+}
+
+// ParseEntries reads the per-sample entries. The IV size is not stored in the
+// box itself and must be taken from the track's tenc box (default_Per_Sample_IV_Size).
+func (b *SampleEncryptionBox) ParseEntries(ivSize uint8) error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.SampleCount = binary.BigEndian.Uint32(data[4:8])
+	subsamples := b.Flags[2]&SencUseSubsampleEncryption != 0
+	if err := b.checkSampleCount(uint64(b.SampleCount)); err != nil {
+		return err
+	}
+	// Every entry takes at least its IV and subsample count.
+	minSize := uint64(ivSize)
+	if subsamples {
+		minSize += 2
+	}
+	if uint64(len(data)-8) < uint64(b.SampleCount)*minSize {
+		return b.errorf("%d samples do not fit in the box", b.SampleCount)
+	}
+
+	b.Entries = make([]SampleEncryptionEntry, 0, b.SampleCount)
+	offset := 8
+	for i := uint32(0); i < b.SampleCount; i++ {
+		var entry SampleEncryptionEntry
+		if len(data) < offset+int(ivSize) {
+			return b.errorf("sample %d is truncated", i+1)
+		}
+		entry.IV = data[offset : offset+int(ivSize)]
+		offset += int(ivSize)
+
+		if subsamples {
+			if len(data) < offset+2 {
+				return b.errorf("sample %d is truncated", i+1)
+			}
+			count := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+			offset += 2
+			if len(data) < offset+6*count {
+				return b.errorf("subsamples of sample %d are truncated", i+1)
+			}
+			entry.Subsamples = make([]Subsample, count)
+			for j := range entry.Subsamples {
+				entry.Subsamples[j].BytesOfClearData = binary.BigEndian.Uint16(data[offset : offset+2])
+				entry.Subsamples[j].BytesOfProtectedData = binary.BigEndian.Uint32(data[offset+2 : offset+6])
+				offset += 6
+			}
+		}
+		b.Entries = append(b.Entries, entry)
+	}
+	return nil
+}
+
+// TrackEncryptionBox - This box contains the default encryption parameters of the samples of a track (Common Encryption)
+// Box Type: ‘tenc’
+// Container: Scheme Information Box (‘schi’)
+// Mandatory: Yes, for the ‘cenc’, ‘cens’, ‘cbc1’ and ‘cbcs’ schemes
+// Quantity: Exactly one
+type TrackEncryptionBox struct {
+	*Box
+	Version                uint8
+	Flags                  [3]byte
+	DefaultCryptByteBlock  uint8 // Pattern encryption, version 1 boxes only: encrypted 16-byte blocks of the pattern.
+	DefaultSkipByteBlock   uint8 // Clear 16-byte blocks of the pattern.
+	DefaultIsProtected     uint8
+	DefaultPerSampleIVSize uint8 // 0, 8 or 16; senc entries carry no IV when 0.
+	DefaultKID             [16]byte
+	DefaultConstantIV      []byte // Only set for protected samples without per-sample IVs.
+}
+
+func (b *TrackEncryptionBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 24 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	// reserved uint8 [4], then reserved uint8 in version 0 [5]
+	if b.Version > 0 {
+		b.DefaultCryptByteBlock = data[5] >> 4
+		b.DefaultSkipByteBlock = data[5] & 0x0f
+	}
+	b.DefaultIsProtected = data[6]
+	b.DefaultPerSampleIVSize = data[7]
+	copy(b.DefaultKID[:], data[8:24])
+
+	if b.DefaultIsProtected == 1 && b.DefaultPerSampleIVSize == 0 {
+		if len(data) < 25 {
+			return b.errorf("box is too short")
+		}
+		size := int(data[24])
+		if len(data) < 25+size {
+			return b.errorf("constant IV of %d bytes exceeds the box", size)
+		}
+		b.DefaultConstantIV = data[25 : 25+size]
+	}
+	return nil
+}
+
+// TrackEncryption returns the tenc box of the first protected sample entry of
+// the track, found in its sinf/schi boxes, or nil if the track has none.
+func (b *TrackBox) TrackEncryption() (*TrackEncryptionBox, error) {
+	stbl, err := b.sampleTable()
+	if err != nil || stbl.Stsd == nil {
+		return nil, nil
+	}
+	for _, entry := range stbl.Stsd.Entries {
+		sinf, err := stbl.Stsd.protectionScheme(entry)
+		if err != nil {
+			return nil, err
+		}
+		if sinf != nil && sinf.Schi != nil && sinf.Schi.Tenc != nil {
+			return sinf.Schi.Tenc, nil
+		}
+	}
+	return nil, nil
+}
+
+// ProtectionSchemeInfoBox - This box contains all the information required to understand the encryption transform applied and its parameters
+// Box Type: ‘sinf’
+// Container: Protected Sample Entry (‘encv’, ‘enca’, ...)
+// Mandatory: Yes, in protected sample entries
+// Quantity: One or more
+type ProtectionSchemeInfoBox struct {
+	*Box
+	Frma *OriginalFormatBox
+	Schm *SchemeTypeBox
+	Schi *SchemeInformationBox
+}
+
+func (b *ProtectionSchemeInfoBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "frma":
+			b.Frma = &OriginalFormatBox{Box: box}
+			if err := parseBox(b.Frma); err != nil {
+				return b.wrapError(err)
+			}
+		case "schm":
+			b.Schm = &SchemeTypeBox{Box: box}
+			if err := parseBox(b.Schm); err != nil {
+				return b.wrapError(err)
+			}
+		case "schi":
+			b.Schi = &SchemeInformationBox{Box: box}
+			if err := parseBox(b.Schi); err != nil {
+				return b.wrapError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// OriginalFormatBox - This box contains the four-character-code of the original un-transformed sample description
+// Box Type: ‘frma’
+// Container: Protection Scheme Information Box (‘sinf’)
+// Mandatory: Yes when used in a protected sample entry
+// Quantity: Exactly one
+type OriginalFormatBox struct {
+	*Box
+	DataFormat string // Coding type of the unprotected sample entry, e.g. ‘avc1’.
+}
+
+func (b *OriginalFormatBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.DataFormat = string(data[0:4])
+	return nil
+}
+
+// SchemeTypeURIPresent is the schm flag signalling that the box ends with a
+// scheme URI.
+const SchemeTypeURIPresent = 0x000001
+
+// SchemeTypeBox - This box identifies the protection or restriction scheme
+// Box Type: ‘schm’
+// Container: Protection Scheme Information Box (‘sinf’)
+// Mandatory: No
+// Quantity: Zero or one in ‘sinf’
+type SchemeTypeBox struct {
+	*Box
+	Version       uint8
+	Flags         [3]byte
+	SchemeType    string // e.g. ‘cenc’ or ‘cbcs’.
+	SchemeVersion uint32 // 0x00010000 for version 1.0 of the Common Encryption schemes.
+	SchemeURI     string
+}
+
+func (b *SchemeTypeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.SchemeType = string(data[4:8])
+	b.SchemeVersion = binary.BigEndian.Uint32(data[8:12])
+	if flags24(b.Flags)&SchemeTypeURIPresent != 0 {
+		b.SchemeURI = string(bytes.TrimRight(data[12:], "\x00"))
+	}
+	return nil
+}
+
+// SchemeInformationBox - This box contains the scheme specific data, e.g. the tenc box of the Common Encryption schemes
+// Box Type: ‘schi’
+// Container: Protection Scheme Information Box (‘sinf’)
+// Mandatory: No
+// Quantity: Zero or one
+type SchemeInformationBox struct {
+	*Box
+	Tenc *TrackEncryptionBox
+}
+
+func (b *SchemeInformationBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "tenc":
+			b.Tenc = &TrackEncryptionBox{Box: box}
+			if err := parseBox(b.Tenc); err != nil {
+				return b.wrapError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// protectionScheme returns the first sinf box of a sample entry, or nil if the
+// entry is not protected. The boxes of the parsed audio and video entries are
+// reused; those of other entries are parsed on demand.
+func (b *SampleDescriptionBox) protectionScheme(entry *Box) (*ProtectionSchemeInfoBox, error) {
+	switch {
+	case b.Visual != nil && b.Visual.Box == entry:
+		return b.Visual.Sinf, nil
+	case b.Audio != nil && b.Audio.Box == entry:
+		return b.Audio.Sinf, nil
+	}
+	box := entry.Child("sinf")
+	if box == nil {
+		return nil, nil
+	}
+	sinf := &ProtectionSchemeInfoBox{Box: box}
+	if err := sinf.parse(); err != nil {
+		return nil, err
+	}
+	return sinf, nil
+}
+
+// SaizAuxInfoTypePresent is the saiz and saio flag signalling that the box
+// starts with the aux_info_type and aux_info_type_parameter fields.
+const SaizAuxInfoTypePresent = 0x000001
+
+// SampleAuxiliaryInformationSizesBox - This box contains the sizes of the auxiliary information of each sample, e.g. the senc entries
+// Box Type: ‘saiz’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more
+type SampleAuxiliaryInformationSizesBox struct {
+	*Box
+	Version               uint8
+	Flags                 [3]byte
+	AuxInfoType           string // e.g. ‘cenc’; empty when absent, then the scheme type applies.
+	AuxInfoTypeParameter  uint32
+	DefaultSampleInfoSize uint8 // Size of every sample when non-zero.
+	SampleCount           uint32
+	SampleInfoSizes       []uint8 // Only set when DefaultSampleInfoSize is 0.
+}
+
+func (b *SampleAuxiliaryInformationSizesBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	offset := 4
+	if flags24(b.Flags)&SaizAuxInfoTypePresent != 0 {
+		if len(data) < offset+8 {
+			return b.errorf("box is too short")
+		}
+		b.AuxInfoType = string(data[offset : offset+4])
+		b.AuxInfoTypeParameter = binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		offset += 8
+	}
+	if len(data) < offset+5 {
+		return b.errorf("box is too short")
+	}
+	b.DefaultSampleInfoSize = data[offset]
+	b.SampleCount = binary.BigEndian.Uint32(data[offset+1 : offset+5])
+	offset += 5
+	if err := b.checkSampleCount(uint64(b.SampleCount)); err != nil {
+		return err
+	}
+	if b.DefaultSampleInfoSize == 0 {
+		if uint64(len(data)-offset) < uint64(b.SampleCount) {
+			return b.errorf("%d sample sizes do not fit in the box", b.SampleCount)
+		}
+		b.SampleInfoSizes = data[offset : offset+int(b.SampleCount)]
+	}
+	return nil
+}
+
+// SizeOf returns the size of the auxiliary information of the 1-based sample
+// number, or 0 if the sample has none.
+func (b *SampleAuxiliaryInformationSizesBox) SizeOf(sampleNumber uint32) uint8 {
+	if sampleNumber == 0 || sampleNumber > b.SampleCount {
+		return 0
+	}
+	if b.DefaultSampleInfoSize != 0 {
+		return b.DefaultSampleInfoSize
+	}
+	// The table is missing when a best-effort parse rejected the count.
+	if sampleNumber > uint32(len(b.SampleInfoSizes)) {
+		return 0
+	}
+	return b.SampleInfoSizes[sampleNumber-1]
+}
+
+// SampleAuxiliaryInformationOffsetsBox - This box contains the offsets of the auxiliary information of the samples
+// Box Type: ‘saio’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more
+//
+// Offsets are absolute file offsets in a sample table, but relative to the base
+// data offset of the track fragment in a traf. A single offset means the
+// information of all samples is stored contiguously; otherwise there is one
+// offset per chunk or track run.
+type SampleAuxiliaryInformationOffsetsBox struct {
+	*Box
+	Version              uint8
+	Flags                [3]byte
+	AuxInfoType          string
+	AuxInfoTypeParameter uint32
+	EntryCount           uint32
+	Offsets              []uint64
+}
+
+func (b *SampleAuxiliaryInformationOffsetsBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	offset := 4
+	if flags24(b.Flags)&SaizAuxInfoTypePresent != 0 {
+		if len(data) < offset+8 {
+			return b.errorf("box is too short")
+		}
+		b.AuxInfoType = string(data[offset : offset+4])
+		b.AuxInfoTypeParameter = binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		offset += 8
+	}
+	if len(data) < offset+4 {
+		return b.errorf("box is too short")
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+
+	entrySize := 4
+	if b.Version == 1 {
+		entrySize = 8
+	}
+	if uint64(len(data)-offset) < uint64(b.EntryCount)*uint64(entrySize) {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.Offsets = make([]uint64, b.EntryCount)
+	for i := range b.Offsets {
+		if b.Version == 1 {
+			b.Offsets[i] = binary.BigEndian.Uint64(data[offset : offset+8])
+		} else {
+			b.Offsets[i] = uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
+		}
+		offset += entrySize
+	}
+	return nil
+}
+
+// Well-known DRM system IDs of pssh boxes.
+var protectionSystems = map[string]string{
+	"edef8ba979d64acea3c827dcd51d21ed": "Widevine",
+	"9a04f07998404286ab92e65be0885f95": "PlayReady",
+	"94ce86fb07ff4f43adb893d2fa968ca2": "FairPlay",
+	"1077efecc0b24d02ace33c1e52e2fb4b": "ClearKey",
+	"e2719d58a985b3c9781ab030af78d30e": "ClearKey (DASH-IF)",
+}
+
+// ProtectionSystemSpecificHeaderBox - This box contains the information needed by a content protection system
+// Box Type: ‘pssh’
+// Container: Movie Box (‘moov’) or Movie Fragment Box (‘moof’)
+// Mandatory: No
+// Quantity: Zero or more
+type ProtectionSystemSpecificHeaderBox struct {
+	*Box
+	Version  uint8
+	Flags    [3]byte
+	SystemID [16]byte
+	KIDs     [][16]byte // Key IDs, only present in version 1 boxes.
+	Data     []byte     // Opaque data of the protection system.
+}
+
+func (b *ProtectionSystemSpecificHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 24 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	copy(b.SystemID[:], data[4:20])
+
+	offset := 20
+	if b.Version > 0 {
+		count := binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		if uint64(len(data)-offset) < uint64(count)*16 {
+			return b.errorf("%d key IDs do not fit in the box", count)
+		}
+		b.KIDs = make([][16]byte, count)
+		for i := range b.KIDs {
+			copy(b.KIDs[i][:], data[offset:offset+16])
+			offset += 16
+		}
+	}
+	if offset+4 > len(data) {
+		return b.errorf("box is too short")
+	}
+	size := binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	if uint64(size) > uint64(len(data)-offset) {
+		return b.errorf("data of %d bytes exceeds the box", size)
+	}
+	b.Data = data[offset : offset+int(size)]
+	return nil
+}
+
+// SystemName returns the name of the DRM system, or its system ID when it is
+// not a well-known one.
+func (b *ProtectionSystemSpecificHeaderBox) SystemName() string {
+	id := fmt.Sprintf("%x", b.SystemID[:])
+	if name, ok := protectionSystems[id]; ok {
+		return name
+	}
+	return id
+}
+
+// IsEncrypted reports whether the samples of the track are encrypted, which is
+// signalled by a protected sample entry (encv, enca, ...) holding a sinf box.
+func (b *TrackBox) IsEncrypted() bool {
+	stbl, err := b.sampleTable()
+	if err != nil || stbl.Stsd == nil {
+		return false
+	}
+	for _, entry := range stbl.Stsd.Entries {
+		switch entry.Name {
+		case "encv", "enca", "enct", "encs":
+			return true
+		}
+		if entry.Child("sinf") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEncrypted reports whether the file carries DRM information, either pssh
+// boxes or encrypted tracks.
+func (m *Mp4Reader) IsEncrypted() bool {
+	if m.Moov == nil {
+		return false
+	}
+	if len(m.Moov.Psshs) > 0 {
+		return true
+	}
+	for _, moof := range m.Moofs {
+		if len(moof.Psshs) > 0 {
+			return true
+		}
+	}
+	for _, trak := range m.Moov.Traks {
+		if trak.IsEncrypted() {
+			return true
+		}
+	}
+	return false
+}
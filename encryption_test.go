@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSampleEncryptionSampleCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  uint32
+		ivSize uint8
+	}{
+		{"no IV", 0, 0},
+		{"8-byte IV", 0, 8},
+		{"subsamples", SencUseSubsampleEncryption, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			senc := &SampleEncryptionBox{Box: topBox(t, buildFullBox("senc", 0, tt.flags, be32(0xffffffff)))}
+			if err := senc.ParseEntries(tt.ivSize); err == nil {
+				t.Fatalf("parsed %d entries without error", len(senc.Entries))
+			}
+		})
+	}
+}
+
+func TestTrackEncryption(t *testing.T) {
+	kid := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	tests := []struct {
+		name    string
+		version uint8
+		payload []byte
+		want    TrackEncryptionBox
+		wantErr bool
+	}{
+		{"cenc", 0, cat([]byte{0, 0, 1, 8}, kid),
+			TrackEncryptionBox{DefaultIsProtected: 1, DefaultPerSampleIVSize: 8}, false},
+		{"cbcs pattern", 1, cat([]byte{0, 0x19, 1, 0}, kid, []byte{16}, iv),
+			TrackEncryptionBox{Version: 1, DefaultCryptByteBlock: 1, DefaultSkipByteBlock: 9, DefaultIsProtected: 1, DefaultConstantIV: iv}, false},
+		{"pattern ignored in version 0", 0, cat([]byte{0, 0x19, 1, 16}, kid),
+			TrackEncryptionBox{DefaultIsProtected: 1, DefaultPerSampleIVSize: 16}, false},
+		{"unprotected", 0, cat([]byte{0, 0, 0, 0}, make([]byte, 16)), TrackEncryptionBox{}, false},
+		{"truncated constant IV", 1, cat([]byte{0, 0x19, 1, 0}, kid, []byte{16}, iv[:8]), TrackEncryptionBox{}, true},
+		{"too short", 0, cat([]byte{0, 0, 1, 8}, kid[:8]), TrackEncryptionBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenc := &TrackEncryptionBox{Box: topBox(t, buildFullBox("tenc", tt.version, 0, tt.payload))}
+			err := tenc.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.Box = tenc.Box
+			if tt.want.DefaultIsProtected == 1 {
+				copy(tt.want.DefaultKID[:], kid)
+			}
+			if !reflect.DeepEqual(*tenc, tt.want) {
+				t.Errorf("got %+v, want %+v", *tenc, tt.want)
+			}
+		})
+	}
+}
+
+// buildSinf builds the protection scheme of an entry transformed from format.
+func buildSinf(format, scheme string) []byte {
+	tenc := buildFullBox("tenc", 0, 0, cat([]byte{0, 0, 1, 8}, make([]byte, 16)))
+	return buildContainer("sinf",
+		buildBox("frma", []byte(format)),
+		buildFullBox("schm", 0, 0, cat([]byte(scheme), be32(0x00010000))),
+		buildContainer("schi", tenc))
+}
+
+func TestProtectedSampleEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler string
+		entry   []byte
+		codec   string
+		scheme  string // Empty for unprotected tracks.
+	}{
+		{"unprotected", "vide", nil, "avc1", ""},
+		{"encv", "vide", buildVisualEntry("encv", 320, 240, buildBox("avcC", testAVCC), buildSinf("avc1", "cenc")), "avc1", "cenc"},
+		{"encv of hvc1", "vide", buildVisualEntry("encv", 320, 240, buildSinf("hvc1", "cbcs")), "hvc1", "cbcs"},
+		{"enca", "soun", buildAudioEntry("enca", buildSinf("mp4a", "cbcs")), "mp4a", "cbcs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trak := parseFile(t, buildFile(testTrack{id: 1, handler: tt.handler, entry: tt.entry})).Moov.Traks[0]
+			stsd := trak.Mdia.Minf.Stbl.Stsd
+			if got := stsd.Codec(); got != tt.codec {
+				t.Errorf("Codec() = %q, want %q", got, tt.codec)
+			}
+			sinf, err := stsd.protectionScheme(stsd.Entries[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			tenc, err := trak.TrackEncryption()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.scheme == "" {
+				if sinf != nil || tenc != nil {
+					t.Errorf("sinf %v, tenc %v for an unprotected track", sinf, tenc)
+				}
+				return
+			}
+			if sinf == nil || sinf.Schm == nil || sinf.Schm.SchemeType != tt.scheme || sinf.Schm.SchemeVersion != 0x00010000 {
+				t.Errorf("sinf = %+v, want scheme %s version 1.0", sinf, tt.scheme)
+			}
+			if tenc == nil || tenc.DefaultPerSampleIVSize != 8 {
+				t.Errorf("tenc = %+v, want 8-byte IVs", tenc)
+			}
+		})
+	}
+}
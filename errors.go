@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseError reports a malformed box: its four-char code, its file offset and
+// the underlying error. Errors returned while parsing wrap the innermost box
+// that failed, so callers can retrieve it with errors.As.
+type ParseError struct {
+	Box    string
+	Offset int64
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Box == "" {
+		return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("box %q at offset %d: %v", e.Box, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// errorf returns a ParseError for the box with a formatted message.
+func (b *Box) errorf(format string, a ...interface{}) error {
+	return &ParseError{Box: b.Name, Offset: b.Start, Err: fmt.Errorf(format, a...)}
+}
+
+// wrapError attributes err to the box unless it already names a box. A nil err
+// is returned as is.
+func (b *Box) wrapError(err error) error {
+	var pe *ParseError
+	if err == nil || errors.As(err, &pe) {
+		return err
+	}
+	return &ParseError{Box: b.Name, Offset: b.Start, Err: err}
+}
+
+// ParseErrors is returned by a best-effort parse with the errors of all the
+// boxes that failed, in file order.
+type ParseErrors []error
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d malformed boxes: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// boxParser is implemented by the parsed box structures: parse comes with each
+// of them and box with the embedded *Box.
+type boxParser interface {
+	parse() error
+	box() *Box
+}
+
+func (b *Box) box() *Box {
+	return b
+}
+
+// parseBox parses a box and keeps its error in ParseErr. With the BestEffort
+// option the error is also collected for ParseContext and nil is returned, so
+// that the caller goes on with the next box.
+func parseBox(p boxParser) error {
+	err := p.parse()
+	if err == nil {
+		return nil
+	}
+	b := p.box()
+	b.ParseErr = err
+	if b.Reader.recordError(err) {
+		return nil
+	}
+	return err
+}
+
+// recordError collects err during a best-effort parse and reports whether it
+// did. Cancellation is never recorded, it always stops the parse.
+func (m *Mp4Reader) recordError(err error) bool {
+	if !m.bestEffort || m.canceled() != nil {
+		return false
+	}
+	m.errsMu.Lock()
+	defer m.errsMu.Unlock()
+	m.errs = append(m.errs, err)
+	return true
+}
+
+// recorded reports whether an error with the same message as err was already
+// collected during a best-effort parse.
+func (m *Mp4Reader) recorded(err error) bool {
+	m.errsMu.Lock()
+	defer m.errsMu.Unlock()
+	for _, e := range m.errs {
+		if e.Error() == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedErrors returns the errors collected during a best-effort parse ordered
+// by file offset, since tracks are parsed concurrently.
+func (m *Mp4Reader) sortedErrors() ParseErrors {
+	errs := ParseErrors(m.errs)
+	offset := func(err error) int64 {
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			return pe.Offset
+		}
+		return 0
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return offset(errs[i]) < offset(errs[j])
+	})
+	return errs
+}
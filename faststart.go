@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// IsFastStart reports whether the moov box precedes the media data, so that
+// playback can start before the whole file is downloaded: moov starts before
+// the first mdat box, later mdat boxes not mattering. Files without mdat are
+// fast start, files without moov are not.
+func (m *Mp4Reader) IsFastStart() bool {
+	if m.Moov == nil {
+		return false
+	}
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return false
+	}
+	for _, box := range boxes {
+		if box.Name == "mdat" {
+			return m.Moov.Start < box.Start
+		}
+	}
+	return true
+}
+
+// Faststart writes the file to out with the moov box moved ahead of the first
+// mdat box, so that players can start progressive playback before the whole
+// file is downloaded. The stco and co64 chunk offsets are shifted to follow the
+// relocated media data. Files already laid out that way are copied unchanged.
+func Faststart(in io.ReaderAt, size int64, out io.Writer) error {
+	m, err := NewReader(in, size)
+	if err != nil {
+		return err
+	}
+	if m.Moov == nil {
+		return fmt.Errorf("no moov box found")
+	}
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return err
+	}
+
+	moov, mdat := -1, -1
+	for i, box := range boxes {
+		switch {
+		case box.Name == "moov" && moov < 0:
+			moov = i
+		case box.Name == "mdat" && mdat < 0:
+			mdat = i
+		}
+	}
+	if mdat < 0 || moov < mdat {
+		_, err := m.WriteTo(out)
+		return err
+	}
+
+	// New order: the boxes preceding the first mdat, moov, then the rest.
+	order := make([]*Box, 0, len(boxes))
+	order = append(order, boxes[:mdat]...)
+	order = append(order, boxes[moov])
+	for i, box := range boxes[mdat:] {
+		if mdat+i != moov {
+			order = append(order, box)
+		}
+	}
+
+	// Boxes keep their size, so every box moves by a fixed amount.
+	shifts := make(map[int64]int64, len(order))
+	start := int64(0)
+	for _, box := range order {
+		shifts[box.Start] = start - box.Start
+		start += box.Size
+	}
+	shift := func(offset int64) (int64, error) {
+		for _, box := range boxes {
+			if offset >= box.Start && offset < box.Start+box.Size {
+				return offset + shifts[box.Start], nil
+			}
+		}
+		return 0, fmt.Errorf("chunk offset %d is outside the file", offset)
+	}
+
+	for _, trak := range m.Moov.Traks {
+		stbl, err := trak.sampleTable()
+		if err != nil {
+			continue
+		}
+		if stbl.Stco != nil {
+			for i, offset := range stbl.Stco.ChunksOffset {
+				moved, err := shift(int64(offset))
+				if err != nil {
+					return err
+				}
+				if moved > math.MaxUint32 {
+					return fmt.Errorf("chunk offset %d overflows stco, the track needs co64", moved)
+				}
+				stbl.Stco.ChunksOffset[i] = uint32(moved)
+			}
+		}
+		if stbl.Co64 != nil {
+			for i, offset := range stbl.Co64.ChunksOffset {
+				if offset > math.MaxInt64 {
+					return fmt.Errorf("chunk offset %d is outside the file", offset)
+				}
+				moved, err := shift(int64(offset))
+				if err != nil {
+					return err
+				}
+				stbl.Co64.ChunksOffset[i] = uint64(moved)
+			}
+		}
+	}
+
+	bw := newBoxWriter(m)
+	for _, box := range order {
+		if _, err := bw.write(out, box); err != nil {
+			return err
+		}
+	}
+	return nil
+}
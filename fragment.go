@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// flags24 returns the 24-bit flags of a full box as an integer.
+func flags24(flags [3]byte) uint32 {
+	return uint32(flags[0])<<16 | uint32(flags[1])<<8 | uint32(flags[2])
+}
+
+// MovieExtendsBox - This box warns readers that there might be Movie Fragment Boxes in this file
+// Box Type: ‘mvex’
+// Container: Movie Box (‘moov’)
+// Mandatory: No
+// Quantity: Zero or one
+type MovieExtendsBox struct {
+	*Box
+	Mehd  *MovieExtendsHeaderBox
+	Trexs []*TrackExtendsBox
+}
+
+func (b *MovieExtendsBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	for _, box := range boxes {
+		switch box.Name {
+		case "mehd":
+			b.Mehd = &MovieExtendsHeaderBox{Box: box}
+			if err := parseBox(b.Mehd); err != nil {
+				return b.wrapError(err)
+			}
+		case "trex":
+			trex := &TrackExtendsBox{Box: box}
+			if err := parseBox(trex); err != nil {
+				return b.wrapError(err)
+			}
+			b.Trexs = append(b.Trexs, trex)
+		}
+	}
+	return nil
+}
+
+// Trex returns the track extends box of the track, or nil if there is none.
+func (b *MovieExtendsBox) Trex(trackID uint32) *TrackExtendsBox {
+	if b == nil {
+		return nil
+	}
+	for _, trex := range b.Trexs {
+		if trex.TrackID == trackID {
+			return trex
+		}
+	}
+	return nil
+}
+
+// MovieExtendsHeaderBox - The Movie Extends Header is optional, and provides the overall duration, including fragments, of a fragmented movie
+// Box Type: ‘mehd’
+// Container: Movie Extends Box (‘mvex’)
+// Mandatory: No
+// Quantity: Zero or one
+type MovieExtendsHeaderBox struct {
+	*Box
+	Version          uint8
+	Flags            [3]byte
+	FragmentDuration uint64 // In the movie timescale.
+}
+
+func (b *MovieExtendsHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if b.Version == 1 {
+		if len(data) < 12 {
+			return b.errorf("box is too short")
+		}
+		b.FragmentDuration = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		b.FragmentDuration = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	return nil
+}
+
+// TrackExtendsBox - This sets up default values used by the movie fragments
+// Box Type: ‘trex’
+// Container: Movie Extends Box (‘mvex’)
+// Mandatory: Yes
+// Quantity: Exactly one for each track in the Movie Box
+type TrackExtendsBox struct {
+	*Box
+	Version                       uint8
+	Flags                         [3]byte
+	TrackID                       uint32
+	DefaultSampleDescriptionIndex uint32
+	DefaultSampleDuration         uint32
+	DefaultSampleSize             uint32
+	DefaultSampleFlags            uint32
+}
+
+func (b *TrackExtendsBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 24 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.TrackID = binary.BigEndian.Uint32(data[4:8])
+	b.DefaultSampleDescriptionIndex = binary.BigEndian.Uint32(data[8:12])
+	b.DefaultSampleDuration = binary.BigEndian.Uint32(data[12:16])
+	b.DefaultSampleSize = binary.BigEndian.Uint32(data[16:20])
+	b.DefaultSampleFlags = binary.BigEndian.Uint32(data[20:24])
+	return nil
+}
+
+// MovieFragmentBox - The movie fragments extend the presentation in time
+// Box Type: ‘moof’
+// Container: File
+// Mandatory: No
+// Quantity: Zero or more
+type MovieFragmentBox struct {
+	*Box
+	Mfhd  *MovieFragmentHeaderBox
+	Trafs []*TrackFragmentBox
+	Psshs []*ProtectionSystemSpecificHeaderBox
+}
+
+func (b *MovieFragmentBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	for _, box := range boxes {
+		switch box.Name {
+		case "mfhd":
+			b.Mfhd = &MovieFragmentHeaderBox{Box: box}
+			parseBox(b.Mfhd)
+		case "traf":
+			traf := &TrackFragmentBox{Box: box}
+			if err := parseBox(traf); err != nil {
+				return b.wrapError(err)
+			}
+			b.Trafs = append(b.Trafs, traf)
+		case "pssh":
+			pssh := &ProtectionSystemSpecificHeaderBox{Box: box}
+			if err := parseBox(pssh); err != nil {
+				return b.wrapError(err)
+			}
+			b.Psshs = append(b.Psshs, pssh)
+		}
+	}
+	return nil
+}
+
+// MovieFragmentHeaderBox - The movie fragment header contains a sequence number, as a safety check
+// Box Type: ‘mfhd’
+// Container: Movie Fragment Box (‘moof’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type MovieFragmentHeaderBox struct {
+	*Box
+	Version        uint8
+	Flags          [3]byte
+	SequenceNumber uint32
+}
+
+func (b *MovieFragmentHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.SequenceNumber = binary.BigEndian.Uint32(data[4:8])
+	return nil
+}
+
+// TrackFragmentBox - Within the movie fragment there is a set of track fragments, zero or more per track
+// Box Type: ‘traf’
+// Container: Movie Fragment Box (‘moof’)
+// Mandatory: No
+// Quantity: Zero or more
+type TrackFragmentBox struct {
+	*Box
+	Tfhd  *TrackFragmentHeaderBox
+	Tfdt  *TrackFragmentBaseMediaDecodeTimeBox
+	Truns []*TrackRunBox
+	Senc  *SampleEncryptionBox // Entries are read with ParseEntries once the IV size is known.
+	Subs  []*SubSampleInformationBox
+	Saiz  []*SampleAuxiliaryInformationSizesBox
+	Saio  []*SampleAuxiliaryInformationOffsetsBox
+	Sbgp  []*SampleToGroupBox
+	Sgpd  []*SampleGroupDescriptionBox
+}
+
+func (b *TrackFragmentBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	for _, box := range boxes {
+		switch box.Name {
+		case "tfhd":
+			b.Tfhd = &TrackFragmentHeaderBox{Box: box}
+			parseBox(b.Tfhd)
+		case "tfdt":
+			b.Tfdt = &TrackFragmentBaseMediaDecodeTimeBox{Box: box}
+			if err := parseBox(b.Tfdt); err != nil {
+				return b.wrapError(err)
+			}
+		case "trun":
+			trun := &TrackRunBox{Box: box}
+			if err := parseBox(trun); err != nil {
+				return b.wrapError(err)
+			}
+			b.Truns = append(b.Truns, trun)
+		case "senc":
+			b.Senc = &SampleEncryptionBox{Box: box}
+		case "subs":
+			subs := &SubSampleInformationBox{Box: box}
+			if err := parseBox(subs); err != nil {
+				return b.wrapError(err)
+			}
+			b.Subs = append(b.Subs, subs)
+		case "saiz":
+			saiz := &SampleAuxiliaryInformationSizesBox{Box: box}
+			if err := parseBox(saiz); err != nil {
+				return b.wrapError(err)
+			}
+			b.Saiz = append(b.Saiz, saiz)
+		case "saio":
+			saio := &SampleAuxiliaryInformationOffsetsBox{Box: box}
+			if err := parseBox(saio); err != nil {
+				return b.wrapError(err)
+			}
+			b.Saio = append(b.Saio, saio)
+		case "sbgp":
+			sbgp := &SampleToGroupBox{Box: box}
+			if err := parseBox(sbgp); err != nil {
+				return b.wrapError(err)
+			}
+			b.Sbgp = append(b.Sbgp, sbgp)
+		case "sgpd":
+			sgpd := &SampleGroupDescriptionBox{Box: box}
+			if err := parseBox(sgpd); err != nil {
+				return b.wrapError(err)
+			}
+			b.Sgpd = append(b.Sgpd, sgpd)
+		}
+	}
+	return nil
+}
+
+// tfhd flags.
+const (
+	TfhdBaseDataOffsetPresent         = 0x000001
+	TfhdSampleDescriptionIndexPresent = 0x000002
+	TfhdDefaultSampleDurationPresent  = 0x000008
+	TfhdDefaultSampleSizePresent      = 0x000010
+	TfhdDefaultSampleFlagsPresent     = 0x000020
+	TfhdDurationIsEmpty               = 0x010000
+	TfhdDefaultBaseIsMoof             = 0x020000
+)
+
+// TrackFragmentHeaderBox - Each movie fragment can add zero or more fragments to each track
+// Box Type: ‘tfhd’
+// Container: Track Fragment Box (‘traf’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type TrackFragmentHeaderBox struct {
+	*Box
+	Version                uint8
+	Flags                  [3]byte
+	TrackID                uint32
+	BaseDataOffset         uint64
+	SampleDescriptionIndex uint32
+	DefaultSampleDuration  uint32
+	DefaultSampleSize      uint32
+	DefaultSampleFlags     uint32
+}
+
+func (b *TrackFragmentHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.TrackID = binary.BigEndian.Uint32(data[4:8])
+
+	flags := flags24(b.Flags)
+	offset := 8
+	field := func(size int) ([]byte, error) {
+		if offset+size > len(data) {
+			return nil, b.errorf("box is too short for its flags 0x%06x", flags)
+		}
+		offset += size
+		return data[offset-size : offset], nil
+	}
+	if flags&TfhdBaseDataOffsetPresent != 0 {
+		v, err := field(8)
+		if err != nil {
+			return b.wrapError(err)
+		}
+		b.BaseDataOffset = binary.BigEndian.Uint64(v)
+	}
+	for _, f := range []struct {
+		flag uint32
+		dst  *uint32
+	}{
+		{TfhdSampleDescriptionIndexPresent, &b.SampleDescriptionIndex},
+		{TfhdDefaultSampleDurationPresent, &b.DefaultSampleDuration},
+		{TfhdDefaultSampleSizePresent, &b.DefaultSampleSize},
+		{TfhdDefaultSampleFlagsPresent, &b.DefaultSampleFlags},
+	} {
+		if flags&f.flag == 0 {
+			continue
+		}
+		v, err := field(4)
+		if err != nil {
+			return b.wrapError(err)
+		}
+		*f.dst = binary.BigEndian.Uint32(v)
+	}
+	return nil
+}
+
+// TrackFragmentBaseMediaDecodeTimeBox - The absolute decode time of the first sample of the track fragment
+// Box Type: ‘tfdt’
+// Container: Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or one
+type TrackFragmentBaseMediaDecodeTimeBox struct {
+	*Box
+	Version             uint8
+	Flags               [3]byte
+	BaseMediaDecodeTime uint64 // In the media timescale of the track.
+}
+
+func (b *TrackFragmentBaseMediaDecodeTimeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if b.Version == 1 {
+		if len(data) < 12 {
+			return b.errorf("box is too short")
+		}
+		b.BaseMediaDecodeTime = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		b.BaseMediaDecodeTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	return nil
+}
+
+// trun flags.
+const (
+	TrunDataOffsetPresent                   = 0x000001
+	TrunFirstSampleFlagsPresent             = 0x000004
+	TrunSampleDurationPresent               = 0x000100
+	TrunSampleSizePresent                   = 0x000200
+	TrunSampleFlagsPresent                  = 0x000400
+	TrunSampleCompositionTimeOffsetsPresent = 0x000800
+)
+
+// sampleIsNonSyncSample is the sample_is_non_sync_sample bit of sample flags.
+const sampleIsNonSyncSample = 0x00010000
+
+// TrackRunEntry holds the per-sample fields of a track run. Fields absent from
+// the run are zero and fall back to the track fragment defaults.
+type TrackRunEntry struct {
+	SampleDuration              uint32
+	SampleSize                  uint32
+	SampleFlags                 uint32
+	SampleCompositionTimeOffset int32
+}
+
+// TrackRunBox - Within the track fragment box, there are zero or more track run boxes
+// Box Type: ‘trun’
+// Container: Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more
+type TrackRunBox struct {
+	*Box
+	Version          uint8
+	Flags            [3]byte
+	SampleCount      uint32
+	DataOffset       int32
+	FirstSampleFlags uint32
+	// Per-sample fields, nil when the run carries none: its SampleCount
+	// samples then all take the track fragment defaults.
+	Entries []TrackRunEntry
+}
+
+func (b *TrackRunBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.SampleCount = binary.BigEndian.Uint32(data[4:8])
+	if err := b.checkSampleCount(uint64(b.SampleCount)); err != nil {
+		return err
+	}
+
+	flags := flags24(b.Flags)
+	offset := 8
+	next := func() (uint32, error) {
+		if offset+4 > len(data) {
+			return 0, b.errorf("%d samples do not fit in the box", b.SampleCount)
+		}
+		offset += 4
+		return binary.BigEndian.Uint32(data[offset-4 : offset]), nil
+	}
+
+	if flags&TrunDataOffsetPresent != 0 {
+		v, err := next()
+		if err != nil {
+			return b.wrapError(err)
+		}
+		b.DataOffset = int32(v)
+	}
+	if flags&TrunFirstSampleFlagsPresent != 0 {
+		v, err := next()
+		if err != nil {
+			return b.wrapError(err)
+		}
+		b.FirstSampleFlags = v
+	}
+
+	fields := 0
+	for _, flag := range []uint32{TrunSampleDurationPresent, TrunSampleSizePresent, TrunSampleFlagsPresent, TrunSampleCompositionTimeOffsetsPresent} {
+		if flags&flag != 0 {
+			fields++
+		}
+	}
+	if uint64(len(data)-offset) < uint64(b.SampleCount)*uint64(4*fields) {
+		return b.errorf("%d samples do not fit in the box", b.SampleCount)
+	}
+	if fields == 0 {
+		// Nothing bounds the sample count of such a run by the size of the
+		// box, so no entry is allocated for it.
+		return nil
+	}
+
+	b.Entries = make([]TrackRunEntry, b.SampleCount)
+	for i := range b.Entries {
+		entry := &b.Entries[i]
+		if flags&TrunSampleDurationPresent != 0 {
+			entry.SampleDuration, _ = next()
+		}
+		if flags&TrunSampleSizePresent != 0 {
+			entry.SampleSize, _ = next()
+		}
+		if flags&TrunSampleFlagsPresent != 0 {
+			entry.SampleFlags, _ = next()
+		}
+		if flags&TrunSampleCompositionTimeOffsetsPresent != 0 {
+			v, _ := next()
+			// Version 0 offsets are unsigned in the specification but read as
+			// signed like those of version 1, as for ctts.
+			entry.SampleCompositionTimeOffset = int32(v)
+		}
+	}
+	return nil
+}
+
+// FragmentSample describes a sample of a movie fragment.
+type FragmentSample struct {
+	Sample
+	TrackID           uint32
+	DecodeTime        uint64 // In the media timescale of the track.
+	Duration          uint32
+	CompositionOffset int32
+	Flags             uint32
+}
+
+// FragmentSamples enumerates the samples of the track across all movie
+// fragments of the file, in decoding order. Sample numbers are counted from the
+// first fragment. Decode times start at the tfdt of each track fragment, or
+// continue from the previous fragment when it has none. Sample fields absent
+// from both the track run and the track fragment header take the defaults of
+// the trex box of the track.
+func (m *Mp4Reader) FragmentSamples(trackID uint32) ([]FragmentSample, error) {
+	var samples []FragmentSample
+	number := uint32(1)
+	var decodeTime uint64
+	var trex *TrackExtendsBox
+	if m.Moov != nil {
+		trex = m.Moov.Mvex.Trex(trackID)
+	}
+
+	for _, moof := range m.Moofs {
+		for _, traf := range moof.Trafs {
+			tfhd := traf.Tfhd
+			if tfhd == nil {
+				return nil, fmt.Errorf("traf at offset %d has no tfhd box", traf.Start)
+			}
+			if tfhd.TrackID != trackID {
+				continue
+			}
+			flags := flags24(tfhd.Flags)
+			if traf.Tfdt != nil {
+				decodeTime = traf.Tfdt.BaseMediaDecodeTime
+			}
+
+			defaults := fragmentDefaults(trex, tfhd)
+
+			// Without an explicit base data offset, data offsets are relative to the
+			// enclosing moof.
+			base := moof.Start
+			if flags&TfhdBaseDataOffsetPresent != 0 {
+				base = int64(tfhd.BaseDataOffset)
+			}
+
+			offset := base
+			for _, trun := range traf.Truns {
+				trunFlags := flags24(trun.Flags)
+				if trunFlags&TrunDataOffsetPresent != 0 {
+					offset = base + int64(trun.DataOffset)
+				}
+
+				for i := 0; i < int(trun.SampleCount); i++ {
+					var entry TrackRunEntry
+					if i < len(trun.Entries) {
+						entry = trun.Entries[i]
+					}
+					s := FragmentSample{
+						TrackID:           trackID,
+						Duration:          defaults.duration,
+						CompositionOffset: entry.SampleCompositionTimeOffset,
+						Flags:             defaults.flags,
+					}
+					s.Size = defaults.size
+					if trunFlags&TrunSampleDurationPresent != 0 {
+						s.Duration = entry.SampleDuration
+					}
+					if trunFlags&TrunSampleSizePresent != 0 {
+						s.Size = entry.SampleSize
+					}
+					if trunFlags&TrunSampleFlagsPresent != 0 {
+						s.Flags = entry.SampleFlags
+					} else if i == 0 && trunFlags&TrunFirstSampleFlagsPresent != 0 {
+						s.Flags = trun.FirstSampleFlags
+					}
+
+					s.Number = number
+					s.DecodeTime = decodeTime
+					s.Offset = offset
+					s.IsSync = s.Flags&sampleIsNonSyncSample == 0
+					samples = append(samples, s)
+					offset += int64(s.Size)
+					decodeTime += uint64(s.Duration)
+					number++
+				}
+			}
+		}
+	}
+	return samples, nil
+}
+
+// sampleDefaults holds the sample fields used for track run entries that do not
+// carry their own.
+type sampleDefaults struct {
+	duration, size, flags uint32
+}
+
+// fragmentDefaults returns the defaults of the track fragment, those of the
+// track fragment header overriding the defaults of the track extends box.
+func fragmentDefaults(trex *TrackExtendsBox, tfhd *TrackFragmentHeaderBox) sampleDefaults {
+	var d sampleDefaults
+	if trex != nil {
+		d = sampleDefaults{trex.DefaultSampleDuration, trex.DefaultSampleSize, trex.DefaultSampleFlags}
+	}
+	flags := flags24(tfhd.Flags)
+	if flags&TfhdDefaultSampleDurationPresent != 0 {
+		d.duration = tfhd.DefaultSampleDuration
+	}
+	if flags&TfhdDefaultSampleSizePresent != 0 {
+		d.size = tfhd.DefaultSampleSize
+	}
+	if flags&TfhdDefaultSampleFlagsPresent != 0 {
+		d.flags = tfhd.DefaultSampleFlags
+	}
+	return d
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// buildSegment serializes a moof box with one track fragment and the mdat box
+// holding its samples. Sample durations fall back to the trex defaults.
+func buildSegment(sequence, trackID uint32, decodeTime uint64, samples ...[]byte) []byte {
+	var sizes []uint32
+	var mdat []byte
+	for _, s := range samples {
+		sizes = append(sizes, uint32(len(s)))
+		mdat = append(mdat, s...)
+	}
+	moof := func(dataOffset uint32) []byte {
+		return buildContainer("moof",
+			buildFullBox("mfhd", 0, 0, be32(sequence)),
+			buildContainer("traf",
+				buildFullBox("tfhd", 0, TfhdDefaultBaseIsMoof, be32(trackID)),
+				buildFullBox("tfdt", 1, 0, be64(decodeTime)),
+				buildFullBox("trun", 0, TrunDataOffsetPresent|TrunSampleSizePresent,
+					cat(be32s(uint32(len(samples)), dataOffset), be32s(sizes...)))))
+	}
+	// The data follows the moof box and the mdat header.
+	size := uint32(len(moof(0)))
+	return cat(moof(size+8), buildBox("mdat", mdat))
+}
+
+func TestSegmentType(t *testing.T) {
+	styp := buildBox("styp", cat([]byte("msdh"), be32(0), []byte("msdhmsix")))
+	data := cat(styp, buildSegment(1, 1, 0, []byte("sample")))
+	m := parseFile(t, data)
+
+	if m.Ftyp != nil || m.Styp == nil {
+		t.Fatalf("ftyp = %v, styp = %v; want only styp", m.Ftyp, m.Styp)
+	}
+	if m.Styp.MajorBrand != "msdh" || !m.Styp.HasBrand(BrandMsix) {
+		t.Errorf("styp = %v, want major brand msdh compatible with msix", m.Styp)
+	}
+	if m.IsQuickTime() {
+		t.Error("segment file reported as QuickTime")
+	}
+	if len(m.Moofs) != 1 {
+		t.Errorf("got %d moof boxes, want 1", len(m.Moofs))
+	}
+	info, err := ProbeFormat(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("ProbeFormat: %v", err)
+	}
+	if info.MajorBrand != "msdh" || len(info.CompatibleBrands) != 2 {
+		t.Errorf("probed brands %s %v, want msdh [msdh msix]", info.MajorBrand, info.CompatibleBrands)
+	}
+}
+
+// buildInit serializes the ftyp and moov boxes of an init segment whose mvex
+// box gives every sample of the track a duration of delta.
+func buildInit(trackID, delta uint32) []byte {
+	ftyp := buildBox("ftyp", cat([]byte("iso6"), be32(0), []byte("iso6dash")))
+	trex := buildFullBox("trex", 0, 0, be32s(trackID, 1, delta, 0, 0))
+	return cat(ftyp, buildContainer("moov", buildMvhd(1000, 0, trackID+1), buildContainer("mvex", trex)))
+}
+
+func TestTrackFragmentDecodeTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint8
+		payload []byte
+		want    uint64
+		wantErr bool
+	}{
+		{"version 0", 0, be32(90000), 90000, false},
+		{"version 1", 1, be64(1 << 40), 1 << 40, false},
+		{"version 1 too short", 1, be32(1), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfdt := &TrackFragmentBaseMediaDecodeTimeBox{Box: topBox(t, buildFullBox("tfdt", tt.version, 0, tt.payload))}
+			err := tfdt.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && tfdt.BaseMediaDecodeTime != tt.want {
+				t.Errorf("base media decode time = %d, want %d", tfdt.BaseMediaDecodeTime, tt.want)
+			}
+		})
+	}
+
+	t.Run("fragment samples", func(t *testing.T) {
+		data := cat(buildInit(1, 10),
+			buildSegment(1, 1, 1000, []byte("a"), []byte("b")),
+			buildSegment(2, 1, 1<<33, []byte("c")))
+		samples, err := parseFile(t, data).FragmentSamples(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uint64{1000, 1010, 1 << 33}
+		if len(samples) != len(want) {
+			t.Fatalf("got %d samples, want %d", len(samples), len(want))
+		}
+		for i, s := range samples {
+			if s.DecodeTime != want[i] || s.Duration != 10 {
+				t.Errorf("sample %d decode time %d, duration %d; want %d, 10", i+1, s.DecodeTime, s.Duration, want[i])
+			}
+			if got := data[s.Offset : s.Offset+int64(s.Size)]; string(got) != "abc"[i:i+1] {
+				t.Errorf("sample %d data %q, want %q", i+1, got, "abc"[i:i+1])
+			}
+		}
+	})
+}
+
+func TestMovieExtends(t *testing.T) {
+	for _, mehd := range [][]byte{buildFullBox("mehd", 0, 0, be32(5000)), buildFullBox("mehd", 1, 0, be64(5000))} {
+		trexs := cat(buildFullBox("trex", 0, 0, be32s(1, 1, 40, 100, sampleIsNonSyncSample)),
+			buildFullBox("trex", 0, 0, be32s(2, 1, 1024, 0, 0)))
+		moov := buildContainer("moov", buildMvhd(1000, 0, 3), buildContainer("mvex", mehd, trexs))
+		mvex := parseFile(t, moov).Moov.Mvex
+		if mvex == nil || mvex.Mehd == nil {
+			t.Fatalf("mvex = %v, want one with mehd", mvex)
+		}
+		if mvex.Mehd.FragmentDuration != 5000 {
+			t.Errorf("mehd version %d: fragment duration %d, want 5000", mvex.Mehd.Version, mvex.Mehd.FragmentDuration)
+		}
+		if trex := mvex.Trex(1); trex == nil || trex.DefaultSampleDuration != 40 || trex.DefaultSampleSize != 100 ||
+			trex.DefaultSampleFlags != sampleIsNonSyncSample {
+			t.Errorf("trex of track 1 = %+v", trex)
+		}
+		if trex := mvex.Trex(2); trex == nil || trex.DefaultSampleDuration != 1024 {
+			t.Errorf("trex of track 2 = %+v", trex)
+		}
+		if trex := mvex.Trex(3); trex != nil {
+			t.Errorf("trex of track 3 = %+v, want none", trex)
+		}
+	}
+}
+
+func TestFragmentDefaults(t *testing.T) {
+	trex := &TrackExtendsBox{DefaultSampleDuration: 40, DefaultSampleSize: 100, DefaultSampleFlags: 1}
+	const both = TfhdDefaultSampleDurationPresent | TfhdDefaultSampleSizePresent | TfhdDefaultSampleFlagsPresent
+	tests := []struct {
+		name  string
+		trex  *TrackExtendsBox
+		flags uint32
+		want  sampleDefaults
+	}{
+		{"no trex", nil, 0, sampleDefaults{}},
+		{"trex", trex, 0, sampleDefaults{40, 100, 1}},
+		{"tfhd duration", trex, TfhdDefaultSampleDurationPresent, sampleDefaults{20, 100, 1}},
+		{"tfhd over trex", trex, both, sampleDefaults{20, 50, 2}},
+		{"tfhd without trex", nil, TfhdDefaultSampleSizePresent, sampleDefaults{0, 50, 0}},
+	}
+	for _, tt := range tests {
+		// The tfhd values only count when their flag is set.
+		tfhd := &TrackFragmentHeaderBox{
+			Flags:                 [3]byte{byte(tt.flags >> 16), byte(tt.flags >> 8), byte(tt.flags)},
+			DefaultSampleDuration: 20,
+			DefaultSampleSize:     50,
+			DefaultSampleFlags:    2,
+		}
+		if got := fragmentDefaults(tt.trex, tfhd); got != tt.want {
+			t.Errorf("%s: defaults %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrackRunEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   uint32
+		payload []byte
+		count   uint32
+		entries []TrackRunEntry
+		wantErr bool
+	}{
+		{"defaults only", TrunDataOffsetPresent, be32s(1<<25, 16), 1 << 25, nil, false},
+		{"sizes", TrunSampleSizePresent, be32s(2, 5, 7), 2, []TrackRunEntry{{SampleSize: 5}, {SampleSize: 7}}, false},
+		{"signed offsets", TrunSampleCompositionTimeOffsetsPresent, be32s(1, 0xfffffffe), 1,
+			[]TrackRunEntry{{SampleCompositionTimeOffset: -2}}, false},
+		{"sizes do not fit", TrunSampleSizePresent, be32s(1<<25, 5), 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trun := &TrackRunBox{Box: topBox(t, buildFullBox("trun", 1, tt.flags, tt.payload))}
+			err := trun.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if trun.SampleCount != tt.count || !reflect.DeepEqual(trun.Entries, tt.entries) {
+				t.Errorf("%d samples, entries %v; want %d, %v", trun.SampleCount, trun.Entries, tt.count, tt.entries)
+			}
+		})
+	}
+
+	t.Run("fragment samples from defaults", func(t *testing.T) {
+		const flags = TfhdDefaultBaseIsMoof | TfhdDefaultSampleDurationPresent | TfhdDefaultSampleSizePresent
+		moof := func(dataOffset uint32) []byte {
+			return buildContainer("moof",
+				buildFullBox("mfhd", 0, 0, be32(1)),
+				buildContainer("traf",
+					buildFullBox("tfhd", 0, flags, be32s(1, 20, 2)),
+					buildFullBox("trun", 0, TrunDataOffsetPresent, be32s(3, dataOffset))))
+		}
+		size := uint32(len(moof(0)))
+		data := cat(buildInit(1, 10), moof(size+8), buildBox("mdat", []byte("aabbcc")))
+		samples, err := parseFile(t, data).FragmentSamples(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 3 {
+			t.Fatalf("got %d samples, want 3", len(samples))
+		}
+		for i, s := range samples {
+			want := "aabbcc"[2*i : 2*i+2]
+			if got := data[s.Offset : s.Offset+int64(s.Size)]; string(got) != want || s.Duration != 20 {
+				t.Errorf("sample %d data %q, duration %d; want %q, 20", i+1, got, s.Duration, want)
+			}
+		}
+	})
+}
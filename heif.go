@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// PrimaryItemBox - The primary item of a meta box, e.g. the main image of a HEIF file
+// Box Type: ‘pitm’
+// Container: Meta Box (‘meta’)
+// Mandatory: No
+// Quantity: Zero or one
+type PrimaryItemBox struct {
+	*Box
+	Version uint8
+	Flags   [3]byte
+	ItemID  uint32
+}
+
+func (b *PrimaryItemBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 6 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if b.Version == 0 {
+		b.ItemID = uint32(binary.BigEndian.Uint16(data[4:6]))
+		return nil
+	}
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.ItemID = binary.BigEndian.Uint32(data[4:8])
+	return nil
+}
+
+// Construction methods of item locations.
+const (
+	ItemConstructionFile = 0 // Extents are file offsets.
+	ItemConstructionIdat = 1 // Extents are offsets into the idat box of the meta box.
+	ItemConstructionItem = 2 // Extents are offsets into other items.
+)
+
+// ItemExtent is a contiguous part of the data of an item. A zero Length
+// stands for the rest of the file.
+type ItemExtent struct {
+	Index  uint64 // Only set with a non-zero index_size.
+	Offset uint64
+	Length uint64
+}
+
+// ItemLocation locates the data of an item.
+type ItemLocation struct {
+	ItemID             uint32
+	ConstructionMethod uint8 // Version 1 and 2 boxes, ItemConstructionFile otherwise.
+	DataReferenceIndex uint16
+	BaseOffset         uint64
+	Extents            []ItemExtent
+}
+
+// ItemLocationBox - The location of every item of a meta box, in this or other files
+// Box Type: ‘iloc’
+// Container: Meta Box (‘meta’)
+// Mandatory: No
+// Quantity: Zero or one
+type ItemLocationBox struct {
+	*Box
+	Version        uint8
+	Flags          [3]byte
+	OffsetSize     uint8 // Sizes in bytes of the variable-length fields: 0, 4 or 8.
+	LengthSize     uint8
+	BaseOffsetSize uint8
+	IndexSize      uint8 // Version 1 and 2 boxes.
+	Items          []ItemLocation
+}
+
+func (b *ItemLocationBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if b.Version > 2 {
+		return b.errorf("unsupported version %d", b.Version)
+	}
+	b.OffsetSize = data[4] >> 4
+	b.LengthSize = data[4] & 0x0f
+	b.BaseOffsetSize = data[5] >> 4
+	if b.Version > 0 {
+		b.IndexSize = data[5] & 0x0f
+	}
+	for _, size := range []uint8{b.OffsetSize, b.LengthSize, b.BaseOffsetSize, b.IndexSize} {
+		if size != 0 && size != 4 && size != 8 {
+			return b.errorf("invalid field size %d", size)
+		}
+	}
+
+	offset := 6
+	field := func(size int) (uint64, error) {
+		if offset+size > len(data) {
+			return 0, b.errorf("box is too short")
+		}
+		v := uint64(0)
+		for _, c := range data[offset : offset+size] {
+			v = v<<8 | uint64(c)
+		}
+		offset += size
+		return v, nil
+	}
+	idSize := 2
+	if b.Version == 2 {
+		idSize = 4
+	}
+	count, err := field(idSize)
+	if err != nil {
+		return err
+	}
+	// Every item takes at least its ID, data_reference_index and extent_count.
+	if count > uint64(len(data)-offset)/uint64(idSize+4) {
+		return b.errorf("%d items do not fit in the box", count)
+	}
+
+	b.Items = make([]ItemLocation, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var item ItemLocation
+		id, err := field(idSize)
+		if err != nil {
+			return err
+		}
+		item.ItemID = uint32(id)
+		if b.Version > 0 {
+			method, err := field(2)
+			if err != nil {
+				return err
+			}
+			item.ConstructionMethod = uint8(method & 0x0f)
+		}
+		ref, err := field(2)
+		if err != nil {
+			return err
+		}
+		item.DataReferenceIndex = uint16(ref)
+		if item.BaseOffset, err = field(int(b.BaseOffsetSize)); err != nil {
+			return err
+		}
+		extents, err := field(2)
+		if err != nil {
+			return err
+		}
+		item.Extents = make([]ItemExtent, extents)
+		for j := range item.Extents {
+			extent := &item.Extents[j]
+			if b.Version > 0 {
+				if extent.Index, err = field(int(b.IndexSize)); err != nil {
+					return err
+				}
+			}
+			if extent.Offset, err = field(int(b.OffsetSize)); err != nil {
+				return err
+			}
+			if extent.Length, err = field(int(b.LengthSize)); err != nil {
+				return err
+			}
+		}
+		b.Items = append(b.Items, item)
+	}
+	return nil
+}
+
+// Item returns the location of the item with the given ID, or nil if there is
+// none.
+func (b *ItemLocationBox) Item(itemID uint32) *ItemLocation {
+	if b == nil {
+		return nil
+	}
+	for i := range b.Items {
+		if b.Items[i].ItemID == itemID {
+			return &b.Items[i]
+		}
+	}
+	return nil
+}
+
+// ItemInfoBox - Information about the items of a meta box, one entry per item
+// Box Type: ‘iinf’
+// Container: Meta Box (‘meta’)
+// Mandatory: No
+// Quantity: Zero or one
+type ItemInfoBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []*ItemInfoEntry
+}
+
+// itemInfoHeaderSize returns the number of payload bytes preceding the
+// entries of an iinf box: version, flags and a 16-bit entry count, 32-bit
+// from version 1.
+func itemInfoHeaderSize(b *Box) int64 {
+	if version := b.Reader.ReadBytesAt(1, b.PayloadOffset()); len(version) == 1 && version[0] > 0 {
+		return 8
+	}
+	return 6
+}
+
+func (b *ItemInfoBox) parse() error {
+	skip := itemInfoHeaderSize(b.Box)
+	data := b.Reader.ReadBytesAt(skip, b.PayloadOffset())
+	if b.PayloadSize() < skip || int64(len(data)) < skip {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if b.Version == 0 {
+		b.EntryCount = uint32(binary.BigEndian.Uint16(data[4:6]))
+	} else {
+		b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	}
+
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset()+skip, b.PayloadSize()-skip)
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		if box.Name != "infe" {
+			continue
+		}
+		infe := &ItemInfoEntry{Box: box}
+		if err := parseBox(infe); err != nil {
+			return b.wrapError(err)
+		}
+		b.Entries = append(b.Entries, infe)
+	}
+	return nil
+}
+
+// Item returns the entry of the item with the given ID, or nil if there is
+// none.
+func (b *ItemInfoBox) Item(itemID uint32) *ItemInfoEntry {
+	if b == nil {
+		return nil
+	}
+	for _, entry := range b.Entries {
+		if entry.ItemID == itemID {
+			return entry
+		}
+	}
+	return nil
+}
+
+// ItemInfoEntry - The type, name and protection of an item
+// Box Type: ‘infe’
+// Container: Item Info Box (‘iinf’)
+// Mandatory: Yes
+// Quantity: One per item
+type ItemInfoEntry struct {
+	*Box
+	Version             uint8
+	Flags               [3]byte
+	ItemID              uint32
+	ItemProtectionIndex uint16
+	ItemType            string // Version 2 and above, e.g. ‘hvc1’, ‘av01’, ‘Exif’ or ‘mime’.
+	ItemName            string
+	ContentType         string // MIME type of ‘mime’ items and version 0 and 1 entries.
+	ContentEncoding     string
+	ItemURIType         string // Only for ‘uri ’ items.
+}
+
+func (b *ItemInfoEntry) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+
+	offset := 4
+	if b.Version == 3 {
+		if len(data) < 10 {
+			return b.errorf("box is too short")
+		}
+		b.ItemID = binary.BigEndian.Uint32(data[4:8])
+		offset += 4
+	} else {
+		b.ItemID = uint32(binary.BigEndian.Uint16(data[4:6]))
+		offset += 2
+	}
+	b.ItemProtectionIndex = binary.BigEndian.Uint16(data[offset : offset+2])
+	offset += 2
+	if b.Version >= 2 {
+		if offset+4 > len(data) {
+			return b.errorf("box is too short")
+		}
+		b.ItemType = string(data[offset : offset+4])
+		offset += 4
+	}
+
+	// The remaining fields are null-terminated UTF-8 strings.
+	fields := bytes.Split(bytes.TrimRight(data[offset:], "\x00"), []byte{0})
+	field := func(i int) string {
+		if i < len(fields) {
+			return string(fields[i])
+		}
+		return ""
+	}
+	b.ItemName = field(0)
+	switch {
+	case b.Version < 2 || b.ItemType == "mime":
+		b.ContentType = field(1)
+		b.ContentEncoding = field(2)
+	case b.ItemType == "uri ":
+		b.ItemURIType = field(1)
+	}
+	return nil
+}
+
+// ItemDataBox - Data of the items of a meta box stored with construction method 1
+// Box Type: ‘idat’
+// Container: Meta Box (‘meta’)
+// Mandatory: No
+// Quantity: Zero or one
+type ItemDataBox struct {
+	*Box
+}
+
+// PrimaryItem returns the info entry and location of the primary item of the
+// meta box, the main image of HEIF and AVIF files.
+func (b *MetaBox) PrimaryItem() (*ItemInfoEntry, *ItemLocation, error) {
+	if b.Pitm == nil {
+		return nil, nil, fmt.Errorf("meta box has no primary item")
+	}
+	id := b.Pitm.ItemID
+	loc := b.Iloc.Item(id)
+	if loc == nil {
+		return nil, nil, fmt.Errorf("primary item %d has no location", id)
+	}
+	return b.Iinf.Item(id), loc, nil
+}
+
+// ItemFileRanges returns the file offsets and lengths of the extents of the
+// item, in order, resolving the base offset and the zero lengths that stand for
+// the rest of the file. Only items stored in this file with construction
+// method 0 have file ranges.
+func (m *Mp4Reader) ItemFileRanges(loc *ItemLocation) (offsets, lengths []int64, err error) {
+	if loc.ConstructionMethod != ItemConstructionFile {
+		return nil, nil, fmt.Errorf("item %d: construction method %d has no file offsets", loc.ItemID, loc.ConstructionMethod)
+	}
+	if loc.DataReferenceIndex != 0 {
+		return nil, nil, fmt.Errorf("item %d is stored in an external file", loc.ItemID)
+	}
+	for _, extent := range loc.Extents {
+		start := loc.BaseOffset + extent.Offset
+		if start < loc.BaseOffset || start > uint64(m.Size) {
+			return nil, nil, fmt.Errorf("item %d: extent offset %d is outside the file", loc.ItemID, start)
+		}
+		length := extent.Length
+		if length == 0 {
+			length = uint64(m.Size) - start
+		}
+		if length > uint64(m.Size)-start {
+			return nil, nil, fmt.Errorf("item %d: extent at offset %d exceeds the file", loc.ItemID, start)
+		}
+		offsets = append(offsets, int64(start))
+		lengths = append(lengths, int64(length))
+	}
+	return offsets, lengths, nil
+}
+
+// ReadItem reads the data of the item, its extents concatenated. Items stored
+// in the idat box of the meta box are supported next to file extents.
+func (m *Mp4Reader) ReadItem(meta *MetaBox, itemID uint32) ([]byte, error) {
+	loc := meta.Iloc.Item(itemID)
+	if loc == nil {
+		return nil, fmt.Errorf("item %d has no location", itemID)
+	}
+	var buf bytes.Buffer
+	if loc.ConstructionMethod == ItemConstructionIdat {
+		if meta.Idat == nil {
+			return nil, fmt.Errorf("item %d: meta box has no idat box", itemID)
+		}
+		data := meta.Idat.ReadBoxData()
+		for _, extent := range loc.Extents {
+			start := loc.BaseOffset + extent.Offset
+			end := start + extent.Length
+			if extent.Length == 0 {
+				end = uint64(len(data))
+			}
+			if start > end || end > uint64(len(data)) {
+				return nil, fmt.Errorf("item %d: extent at offset %d exceeds the idat box", itemID, start)
+			}
+			buf.Write(data[start:end])
+		}
+		return buf.Bytes(), nil
+	}
+
+	offsets, lengths, err := m.ItemFileRanges(loc)
+	if err != nil {
+		return nil, err
+	}
+	for i, offset := range offsets {
+		data := m.ReadBytesAt(lengths[i], offset)
+		if int64(len(data)) != lengths[i] {
+			return nil, fmt.Errorf("item %d: reading extent at offset %d failed", itemID, offset)
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// buildHEIF serializes a HEIF file whose primary item 1 is stored in two
+// extents of the mdat box, "abc" and "fg", and whose item 2 is stored in the
+// idat box.
+func buildHEIF() []byte {
+	ftyp := buildBox("ftyp", cat([]byte("heic"), be32(0), []byte("mif1heic")))
+	mdat := buildBox("mdat", []byte("abcdefgh"))
+	meta := func(base uint32) []byte {
+		hdlr := buildFullBox("hdlr", 0, 0, cat(be32(0), []byte("pict"), make([]byte, 12), []byte{0}))
+		iinf := buildFullBox("iinf", 0, 0, cat(be16(2),
+			buildFullBox("infe", 2, 0, cat(be16(1), be16(0), []byte("hvc1"), []byte("Image\x00"))),
+			buildFullBox("infe", 2, 0, cat(be16(2), be16(0), []byte("Exif"), []byte("\x00")))))
+		iloc := buildFullBox("iloc", 1, 0, cat([]byte{0x44, 0x40}, be16(2),
+			be16(1), be16(ItemConstructionFile), be16(0), be32(base), be16(2), be32s(0, 3, 5, 2),
+			be16(2), be16(ItemConstructionIdat), be16(0), be32(0), be16(1), be32s(1, 0)))
+		return buildFullBox("meta", 0, 0, cat(hdlr, buildFullBox("pitm", 0, 0, be16(1)), iinf, iloc, buildBox("idat", []byte("xEXIF"))))
+	}
+	base := uint32(len(ftyp) + len(meta(0)) + 8)
+	return cat(ftyp, meta(base), mdat)
+}
+
+func TestHEIFItems(t *testing.T) {
+	data := buildHEIF()
+	m := parseFile(t, data)
+	if m.Meta == nil {
+		t.Fatal("no top-level meta box")
+	}
+	entry, loc, err := m.Meta.PrimaryItem()
+	if err != nil {
+		t.Fatalf("PrimaryItem: %v", err)
+	}
+	if entry.ItemID != 1 || entry.ItemType != "hvc1" || entry.ItemName != "Image" {
+		t.Errorf("primary item %d of type %q named %q, want 1 hvc1 Image", entry.ItemID, entry.ItemType, entry.ItemName)
+	}
+
+	base := int64(len(data) - 8)
+	offsets, lengths, err := m.ItemFileRanges(loc)
+	if err != nil {
+		t.Fatalf("ItemFileRanges: %v", err)
+	}
+	if want := []int64{base, base + 5}; !reflect.DeepEqual(offsets, want) || !reflect.DeepEqual(lengths, []int64{3, 2}) {
+		t.Errorf("file ranges at %v of %v, want at %v of [3 2]", offsets, lengths, want)
+	}
+
+	for id, want := range map[uint32]string{1: "abcfg", 2: "EXIF"} {
+		if got, err := m.ReadItem(m.Meta, id); err != nil || string(got) != want {
+			t.Errorf("ReadItem(%d) = %q, %v; want %q", id, got, err, want)
+		}
+	}
+	if _, _, err := m.ItemFileRanges(m.Meta.Iloc.Item(2)); err == nil {
+		t.Error("file ranges of an idat item")
+	}
+	if _, err := m.ReadItem(m.Meta, 3); err == nil {
+		t.Error("read an item without location")
+	}
+}
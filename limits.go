@@ -0,0 +1,84 @@
+package main
+
+import "fmt"
+
+// Default limits of ParseOptions.
+const (
+	DefaultMaxBoxDepth    = 64
+	DefaultMaxSampleCount = 1 << 25
+	DefaultMaxBoxSize     = 256 << 20
+)
+
+// ParseOptions limits the resources the parser spends on a file, so that box
+// sizes and counts declared by a crafted file cannot make it allocate huge
+// amounts of memory or loop for a long time. Zero fields take the defaults.
+type ParseOptions struct {
+	MaxBoxDepth    int    // Nesting depth of the boxes visited by Walk, Validate and MarshalJSON.
+	MaxSampleCount uint32 // Samples, chunks or entries of a sample table, track run or sample group.
+	MaxBoxSize     int64  // Size of any box but mdat, free, skip and wide, whose payloads are not loaded.
+
+	// BestEffort makes Parse go on past malformed boxes, for recovering what is
+	// left of damaged files. The error of a box that fails to parse is kept in
+	// its ParseErr and the box is left partially decoded. A box that exceeds
+	// its container, as in a truncated file, is cut to fit; after any other
+	// malformed box header the remaining siblings are skipped. Parse then returns all errors
+	// as ParseErrors along with the populated tree.
+	BestEffort bool
+
+	// DetectContainers makes Walk, Children and the writer descend into boxes
+	// that are not known containers when their payload is exactly a sequence
+	// of boxes with printable four-char codes, exposing vendor boxes nested in
+	// unknown ones. Leaf payloads rarely pass the check, but a false match is
+	// possible; RegisterContainerBox is the reliable way to add containers.
+	DetectContainers bool
+
+	// AllowBinaryBoxTypes accepts box types that are not four printable
+	// characters. By default such a type fails the box header, since it is
+	// most often read from a misaligned offset after a wrong box size.
+	AllowBinaryBoxTypes bool
+
+	// SkipMdat makes Parse record only the position and size of mdat boxes and
+	// never read their payloads, even when Mp4Reader.ReadMdatData is set, so
+	// that metadata-only probing costs the same for any media size. Samples are
+	// still read on demand from their offsets. ProbeFormat always sets it.
+	SkipMdat bool
+}
+
+// WithParseOptions sets the limits enforced while parsing.
+func WithParseOptions(opts ParseOptions) Option {
+	return func(m *Mp4Reader) {
+		m.Options = opts
+	}
+}
+
+// limits returns the options of the reader with the defaults filled in.
+func (m *Mp4Reader) limits() ParseOptions {
+	opts := m.Options
+	if opts.MaxBoxDepth <= 0 {
+		opts.MaxBoxDepth = DefaultMaxBoxDepth
+	}
+	if opts.MaxSampleCount == 0 {
+		opts.MaxSampleCount = DefaultMaxSampleCount
+	}
+	if opts.MaxBoxSize <= 0 {
+		opts.MaxBoxSize = DefaultMaxBoxSize
+	}
+	return opts
+}
+
+// checkSampleCount fails if count exceeds the MaxSampleCount limit. Totals
+// summed over a table are passed unwrapped, as uint64.
+func (b *Box) checkSampleCount(count uint64) error {
+	if max := b.Reader.limits().MaxSampleCount; count > uint64(max) {
+		return b.errorf("%d samples exceed the limit of %d", count, max)
+	}
+	return nil
+}
+
+// checkDepth fails if depth exceeds the MaxBoxDepth limit.
+func (m *Mp4Reader) checkDepth(box *Box, depth int) error {
+	if max := m.limits().MaxBoxDepth; depth > max {
+		return &ParseError{box.Name, box.Start, fmt.Errorf("box nesting exceeds the limit of %d", max)}
+	}
+	return nil
+}
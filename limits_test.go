@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// countedTables is a track whose stbl carries every table with a sample or
+// entry count, one entry each.
+func countedTables() testTrack {
+	return testTrack{id: 1, samples: [][]byte{{0, 0, 0, 0}}, stbl: [][]byte{
+		buildFullBox("ctts", 0, 0, be32s(1, 1, 0)),
+		buildFullBox("padb", 0, 0, cat(be32(1), []byte{0})),
+		buildFullBox("subs", 0, 0, cat(be32s(1, 1), be16(0))),
+		buildFullBox("sbgp", 0, 0, cat([]byte("roll"), be32s(1, 1, 1))),
+		buildFullBox("sgpd", 1, 0, cat([]byte("roll"), be32s(2, 1), be16(1))),
+		buildFullBox("stz2", 0, 0, cat(be32s(8, 1), []byte{4})),
+		buildFullBox("saio", 0, 0, be32s(1, 0)),
+	}}
+}
+
+func TestSampleCountLimits(t *testing.T) {
+	// Offset of the count field in the payload of each box.
+	tests := []struct {
+		name   string
+		offset int64
+	}{
+		{"stts", 4}, {"ctts", 4}, {"stss", 4}, {"stsc", 4}, {"stco", 4},
+		{"stsz", 8}, {"stz2", 8}, {"padb", 4}, {"subs", 4}, {"sbgp", 8},
+		{"sgpd", 12}, {"saio", 4},
+	}
+	track := countedTables()
+	track.sync = []uint32{1}
+	base := buildFile(track)
+	starts := make(map[string]int64)
+	parseFile(t, base).Walk(func(box *Box, depth int) error {
+		starts[box.Name] = box.Start
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, ok := starts[tt.name]
+			if !ok {
+				t.Fatalf("no %s box in the file", tt.name)
+			}
+			data := append([]byte(nil), base...)
+			binary.BigEndian.PutUint32(data[start+BoxHeaderSize+tt.offset:], 0xffffffff)
+
+			m, err := NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return
+			}
+			parsed, ok := m.parsedBoxes()[start].(boxParser)
+			if !ok {
+				t.Fatalf("%s box not parsed", tt.name)
+			}
+			if parsed.box().ParseErr == nil {
+				t.Errorf("count 0xffffffff accepted")
+			}
+		})
+	}
+}
+
+// TestAdversarialInputs parses randomly corrupted files under tight limits and
+// checks that nothing panics and that no sample expansion exceeds the limit.
+func TestAdversarialInputs(t *testing.T) {
+	const maxSamples = 1000
+	opts := ParseOptions{MaxSampleCount: maxSamples, MaxBoxSize: 1 << 20}
+	track := countedTables()
+	track.sync = []uint32{1}
+	base := buildFile(track, testTrack{id: 2, handler: "soun", samples: [][]byte{{1}, {2}}, perChunk: 1})
+	rng := rand.New(rand.NewSource(1))
+	huge := []uint32{0xffffffff, 0x80000000, 0x7fffffff, maxSamples + 1, 0}
+
+	for i := 0; i < 2000; i++ {
+		data := append([]byte(nil), base...)
+		for n := 1 + rng.Intn(4); n > 0; n-- {
+			offset := rng.Intn(len(data) - 4)
+			if rng.Intn(2) == 0 {
+				binary.BigEndian.PutUint32(data[offset:], huge[rng.Intn(len(huge))])
+			} else {
+				data[offset] = byte(rng.Intn(256))
+			}
+		}
+		for _, bestEffort := range []bool{false, true} {
+			opts.BestEffort = bestEffort
+			m, _ := NewReader(bytes.NewReader(data), int64(len(data)), WithParseOptions(opts))
+			if m.Moov == nil {
+				continue
+			}
+			for _, trak := range m.Moov.Traks {
+				if samples, err := trak.Samples(); err == nil && len(samples) > maxSamples {
+					t.Fatalf("iteration %d: %d samples exceed the limit", i, len(samples))
+				}
+				if order, err := trak.PresentationOrder(); err == nil && len(order) > maxSamples {
+					t.Fatalf("iteration %d: presentation order of %d samples exceeds the limit", i, len(order))
+				}
+				if times, err := trak.SampleTimes(); err == nil && len(times) > maxSamples {
+					t.Fatalf("iteration %d: %d sample times exceed the limit", i, len(times))
+				}
+				trak.Chunks()
+				trak.Bitrate()
+			}
+			m.Chapters()
+			m.Validate()
+		}
+	}
+}
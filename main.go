@@ -1,13 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -19,7 +28,12 @@ const (
 type Fixed16 uint16
 
 func (f Fixed16) String() string {
-	return fmt.Sprintf("%v", uint16(f)>>8)
+	return formatFixed(f.Float64(), 3)
+}
+
+// Float64 returns the value of the 8.8 number.
+func (f Fixed16) Float64() float64 {
+	return float64(f) / (1 << 8)
 }
 
 func fixed16(bytes []byte) Fixed16 {
@@ -29,21 +43,126 @@ func fixed16(bytes []byte) Fixed16 {
 // Fixed32 is a 16.16 Fixed Point Decimal notation
 type Fixed32 uint32
 
+func (f Fixed32) String() string {
+	return formatFixed(f.Float64(), 4)
+}
+
+// Float64 returns the value of the 16.16 number.
+func (f Fixed32) Float64() float64 {
+	return float64(f) / (1 << 16)
+}
+
 func fixed32(bytes []byte) Fixed32 {
 	return Fixed32(binary.BigEndian.Uint32(bytes))
 }
 
+// formatFixed formats v with up to prec fractional digits, dropping trailing zeros.
+func formatFixed(v float64, prec int) string {
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// ticksToDuration converts a tick count in the given timescale to a time.Duration.
+func ticksToDuration(ticks uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	ts := uint64(timescale)
+	return time.Duration(ticks/ts)*time.Second + time.Duration(ticks%ts)*time.Second/time.Duration(ts)
+}
+
 // Mp4Reader defines an mp4 reader structure.
 type Mp4Reader struct {
 	Reader io.ReaderAt
 	Ftyp   *FtypBox
+	Styp   *FtypBox // Segment type of fragmented segment files, which have no ftyp.
 	Moov   *MovieBox
 	Mdat   *MediaDataBox
+	Moofs  []*MovieFragmentBox
+	Sidxs  []*SegmentIndexBox
+	Prfts  []*ProducerReferenceTimeBox
+	Emsgs  []*EventMessageBox
+	Meta   *MetaBox   // Top-level meta box of HEIF and AVIF image files.
+	Free   []*FreeBox // Top-level free, skip and wide boxes.
 	Size   int64
+
+	// ReadMdatData makes Parse load the whole mdat payload into Mdat.Data. By
+	// default only the position of mdat is recorded and samples are read on
+	// demand from their file offsets, which keeps memory flat for large files;
+	// Mdat.DataReader streams the payload without loading it. Ignored when
+	// Options.SkipMdat is set.
+	ReadMdatData bool
+
+	// Options limits what Parse accepts from untrusted input.
+	Options ParseOptions
+
+	// ExternalDataResolver opens the media data of tracks whose data reference
+	// points to another file, given the URL of the url or urn entry, often a
+	// path relative to the movie. Each location is resolved once, and readers
+	// implementing io.Closer are closed by Close. Without a resolver, reading
+	// such samples fails.
+	ExternalDataResolver func(url string) (io.ReaderAt, error)
+
+	file    *os.File        // File opened by Open, closed by Close.
+	removed map[int64]bool  // Start offsets of the boxes left out by WriteTo.
+	ctx     context.Context // Context of the running ParseContext call.
+
+	values map[int64]boxValue // Results of the registered box parsers by box offset.
+
+	externalMu sync.Mutex
+	external   map[string]io.ReaderAt // Readers returned by ExternalDataResolver.
+
+	bestEffort bool // Set during a ParseContext call with the BestEffort option.
+	errsMu     sync.Mutex
+	errs       []error // Errors collected by a best-effort parse.
 }
 
 // Parse reads an MP4 reader for atom boxes.
 func (m *Mp4Reader) Parse() error {
+	return m.ParseContext(context.Background())
+}
+
+// ParseContext is like Parse but stops with ctx.Err() once ctx is done. The
+// context is checked before every box header read, at every level of the tree.
+func (m *Mp4Reader) ParseContext(ctx context.Context) error {
+	m.ctx = ctx
+	m.bestEffort = m.Options.BestEffort
+	m.errs = nil
+	defer func() { m.ctx, m.bestEffort = nil, false }()
+	err := m.parse()
+	if err == nil {
+		// Malformed boxes are already recorded: walking the tree again for
+		// the registered parsers stops short at them, so only the errors of
+		// boxes parse does not descend into are new.
+		m.bestEffort = false
+		if err = m.runBoxParsers(); err != nil && m.Options.BestEffort && ctx.Err() == nil {
+			m.bestEffort = true
+			if m.recorded(err) || m.recordError(err) {
+				err = nil
+			}
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil && len(m.errs) > 0 {
+		return m.sortedErrors()
+	}
+	return err
+}
+
+// canceled returns the error of the context of a running ParseContext call.
+func (m *Mp4Reader) canceled() error {
+	if m.ctx == nil {
+		return nil
+	}
+	return m.ctx.Err()
+}
+
+func (m *Mp4Reader) parse() error {
 	if m.Size == 0 {
 		if ofile, ok := m.Reader.(*os.File); ok {
 			info, err := ofile.Stat()
@@ -55,28 +174,94 @@ func (m *Mp4Reader) Parse() error {
 		}
 	}
 
-	boxes := readBoxes(m, int64(0), m.Size)
+	boxes, err := readBoxes(m, int64(0), m.Size)
+	if err != nil {
+		return err
+	}
 	for _, box := range boxes {
 		switch box.Name {
 		case "ftyp":
 			m.Ftyp = &FtypBox{Box: box}
-			m.Ftyp.parse()
+			parseBox(m.Ftyp)
+
+		case "styp":
+			m.Styp = &FtypBox{Box: box}
+			parseBox(m.Styp)
 
 		case "moov":
 			m.Moov = &MovieBox{Box: box}
-			m.Moov.parse()
+			if err := parseBox(m.Moov); err != nil {
+				return err
+			}
 
 		case "mdat":
 			m.Mdat = &MediaDataBox{Box: box}
-			m.Mdat.parse()
+			if m.ReadMdatData && !m.Options.SkipMdat {
+				parseBox(m.Mdat)
+			}
+
+		case "moof":
+			moof := &MovieFragmentBox{Box: box}
+			if err := parseBox(moof); err != nil {
+				return err
+			}
+			m.Moofs = append(m.Moofs, moof)
+
+		case "sidx":
+			sidx := &SegmentIndexBox{Box: box}
+			parseBox(sidx)
+			m.Sidxs = append(m.Sidxs, sidx)
+
+		case "prft":
+			prft := &ProducerReferenceTimeBox{Box: box}
+			if err := parseBox(prft); err != nil {
+				return err
+			}
+			m.Prfts = append(m.Prfts, prft)
+
+		case "emsg":
+			emsg := &EventMessageBox{Box: box}
+			if err := parseBox(emsg); err != nil {
+				return err
+			}
+			m.Emsgs = append(m.Emsgs, emsg)
+
+		case "meta":
+			m.Meta = &MetaBox{Box: box}
+			if err := parseBox(m.Meta); err != nil {
+				return err
+			}
+
+		case "free", "skip", "wide":
+			m.Free = append(m.Free, &FreeBox{Box: box})
 		}
 	}
 	return nil
 }
 
+// Duration returns the movie duration using the movie timescale.
+func (m *Mp4Reader) Duration() time.Duration {
+	if m.Moov == nil || m.Moov.Mvhd == nil {
+		return 0
+	}
+	return ticksToDuration(m.Moov.Mvhd.Duration, m.Moov.Mvhd.Timescale)
+}
+
+// OverallBitrate returns the average bitrate of the whole file in bits per
+// second, the file size over the movie duration.
+func (m *Mp4Reader) OverallBitrate() (uint64, error) {
+	if m.Moov == nil || m.Moov.Mvhd == nil || m.Moov.Mvhd.Timescale == 0 || m.Moov.Mvhd.Duration == 0 {
+		return 0, fmt.Errorf("file has no movie duration")
+	}
+	return uint64(float64(m.Size) * 8 * float64(m.Moov.Mvhd.Timescale) / float64(m.Moov.Mvhd.Duration)), nil
+}
+
 // ReadBoxAt reads a box from an offset.
 func (m *Mp4Reader) ReadBoxAt(offset int64) (boxSize uint32, boxType string) {
 	buf := m.ReadBytesAt(BoxHeaderSize, offset)
+	if len(buf) < int(BoxHeaderSize) {
+		return 0, ""
+	}
 	boxSize = binary.BigEndian.Uint32(buf[0:4])
 	boxType = string(buf[4:8])
 	return boxSize, boxType
@@ -92,50 +277,236 @@ func (m *Mp4Reader) ReadBytesAt(n int64, offset int64) (word []byte) {
 	return buf
 }
 
-func readBoxes(m *Mp4Reader, start int64, n int64) (l []*Box) {
-	for offset := start; offset < start+n; {
-		size, name := m.ReadBoxAt(offset)
+// readBoxes reads the headers of the consecutive boxes in [start, start+n). A
+// box size of one is followed by a 64-bit largesize, and a box size of zero,
+// meaning "up to the end of the file", is only accepted for top-level boxes
+// (start 0). Since every box advances the offset by at least its
+// header, the loop is bounded by n/BoxHeaderSize iterations. During a
+// best-effort parse a malformed header is recorded and the boxes preceding it
+// are returned, and a box exceeding its container is cut to fit.
+func readBoxes(m *Mp4Reader, start int64, n int64) (l []*Box, err error) {
+	l, err = readBoxHeaders(m, start, n)
+	if err != nil && m.recordError(err) {
+		return l, nil
+	}
+	return l, err
+}
+
+func readBoxHeaders(m *Mp4Reader, start int64, n int64) (l []*Box, err error) {
+	end := start + n
+	for offset := start; offset < end; {
+		if err := m.canceled(); err != nil {
+			return l, err
+		}
+		if end-offset < BoxHeaderSize {
+			// QuickTime may end a list of atoms with a 32-bit zero terminator.
+			if tail := m.ReadBytesAt(end-offset, offset); len(tail) == 4 && binary.BigEndian.Uint32(tail) == 0 {
+				break
+			}
+			return l, &ParseError{Offset: offset, Err: fmt.Errorf("truncated box header")}
+		}
+		buf := make([]byte, BoxHeaderSize)
+		if _, err := m.Reader.ReadAt(buf, offset); err != nil {
+			return l, &ParseError{Offset: offset, Err: fmt.Errorf("reading box header: %v", err)}
+		}
+		size := int64(binary.BigEndian.Uint32(buf[0:4]))
+		name := string(buf[4:8])
+		headerSize := BoxHeaderSize
+		// QuickTime also terminates some atom lists with an empty atom of type 0.
+		terminator := size == BoxHeaderSize && name == "\x00\x00\x00\x00"
+		if !isPrintableCode(name) && !terminator && !m.Options.AllowBinaryBoxTypes {
+			return l, &ParseError{name, offset, fmt.Errorf("non-printable box type, likely misaligned")}
+		}
+
+		if size == 1 {
+			// A largesize follows the box type.
+			if end-offset < 16 {
+				return l, &ParseError{name, offset, fmt.Errorf("truncated 64-bit size")}
+			}
+			large := make([]byte, 8)
+			if _, err := m.Reader.ReadAt(large, offset+BoxHeaderSize); err != nil {
+				return l, &ParseError{name, offset, fmt.Errorf("reading 64-bit size: %v", err)}
+			}
+			largesize := binary.BigEndian.Uint64(large)
+			if largesize > math.MaxInt64 {
+				return l, &ParseError{name, offset, fmt.Errorf("invalid size %d", largesize)}
+			}
+			size, headerSize = int64(largesize), 16
+		}
+
+		switch {
+		case size == 0 && start == 0:
+			size = end - offset
+		case size < headerSize:
+			return l, &ParseError{name, offset, fmt.Errorf("invalid size %d", size)}
+		case size > end-offset:
+			err := &ParseError{name, offset, fmt.Errorf("size %d exceeds its container", size)}
+			// A truncated box is cut to what is left of its container.
+			if !m.recordError(err) {
+				return l, err
+			}
+			size = end - offset
+		}
+		if max := m.limits().MaxBoxSize; size > max {
+			switch name {
+			case "mdat", "free", "skip", "wide":
+			default:
+				return l, &ParseError{name, offset, fmt.Errorf("size %d exceeds the limit of %d", size, max)}
+			}
+		}
 
 		b := &Box{
-			Name:   string(name),
-			Size:   int64(size),
-			Reader: m,
-			Start:  offset,
+			Name:       name,
+			Size:       size,
+			HeaderSize: headerSize,
+			Reader:     m,
+			Start:      offset,
+		}
+		if v, ok := m.values[offset]; ok {
+			b.Value, b.ParseErr = v.value, v.err
 		}
 
 		l = append(l, b)
-		offset += int64(size)
+		offset += size
 	}
-	return l
+	return l, nil
 }
 
 // Open opens a file and returns an &Mp4Reader{}.
-func Open(path string) (f *Mp4Reader, err error) {
+func Open(path string, opts ...Option) (f *Mp4Reader, err error) {
 	file, err := os.Open(path)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return nil, err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
 	f = &Mp4Reader{
 		Reader: file,
+		Size:   info.Size(),
+		file:   file,
+	}
+	for _, opt := range opts {
+		opt(f)
 	}
 	return f, f.Parse()
 }
 
+// Close closes the file opened by Open and the readers returned by
+// ExternalDataResolver that implement io.Closer. It does not close readers
+// passed to NewReader, which stay owned by the caller.
+func (m *Mp4Reader) Close() error {
+	m.externalMu.Lock()
+	external := m.external
+	m.external = nil
+	m.externalMu.Unlock()
+	var err error
+	for _, r := range external {
+		if c, ok := r.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if m.file != nil {
+		if ferr := m.file.Close(); err == nil {
+			err = ferr
+		}
+		m.file = nil
+	}
+	return err
+}
+
+// NewReader parses size bytes of r and returns an &Mp4Reader{}.
+func NewReader(r io.ReaderAt, size int64, opts ...Option) (*Mp4Reader, error) {
+	m := &Mp4Reader{
+		Reader: r,
+		Size:   size,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, m.Parse()
+}
+
 // Box defines an Atom Box structure.
 type Box struct {
 	Name        string
 	Size, Start int64
+	HeaderSize  int64 // BoxHeaderSize, or 16 for a box with a 64-bit largesize.
 	Reader      *Mp4Reader
+	Value       interface{} // Result of the parser registered with RegisterBoxParser, set by Parse.
+	ParseErr    error       // Error returned by the registered parser.
+}
+
+// PayloadOffset returns the file offset of the box data, the bytes following
+// the box header: 8 bytes, or 16 for a 64-bit largesize. The data of full boxes
+// starts with their version and flags.
+func (b *Box) PayloadOffset() int64 {
+	return b.Start + b.headerSize()
+}
+
+// PayloadSize returns the number of bytes of box data.
+func (b *Box) PayloadSize() int64 {
+	if b.Size <= b.headerSize() {
+		return 0
+	}
+	return b.Size - b.headerSize()
+}
+
+// headerSize returns HeaderSize, BoxHeaderSize for boxes built without it.
+func (b *Box) headerSize() int64 {
+	if b.HeaderSize == 0 {
+		return BoxHeaderSize
+	}
+	return b.HeaderSize
 }
 
 // ReadBoxData reads the box data from an atom box.
 func (b *Box) ReadBoxData() []byte {
-	if b.Size <= BoxHeaderSize {
+	if b.PayloadSize() == 0 {
 		return nil
 	}
-	return b.Reader.ReadBytesAt(b.Size-BoxHeaderSize, b.Start+BoxHeaderSize)
+	return b.Reader.ReadBytesAt(b.PayloadSize(), b.PayloadOffset())
+}
+
+// DataReader returns a reader over the box data that reads from the file on
+// demand instead of loading it, the way to consume large boxes such as mdat.
+func (b *Box) DataReader() *io.SectionReader {
+	return io.NewSectionReader(b.Reader.Reader, b.PayloadOffset(), b.PayloadSize())
+}
+
+// boxPreviewSize is the number of payload bytes shown by Box.String.
+const boxPreviewSize = 32
+
+// String describes the box by its four-char code, its size and the first
+// bytes of its payload in hex, followed by "..." when there are more. It is the
+// summary of boxes without a parser of their own.
+func (b *Box) String() string {
+	if b == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%s size=%d data=%s", b.Name, b.Size, b.preview())
+}
+
+// preview returns up to boxPreviewSize bytes of the payload in hex.
+func (b *Box) preview() string {
+	n := b.PayloadSize()
+	if n == 0 {
+		return ""
+	}
+	if n > boxPreviewSize {
+		n = boxPreviewSize
+	}
+	s := hex.EncodeToString(b.Reader.ReadBytesAt(n, b.PayloadOffset()))
+	if b.PayloadSize() > n {
+		s += "..."
+	}
+	return s
 }
 
 // FtypBox - File Type Box
@@ -143,6 +514,9 @@ func (b *Box) ReadBoxData() []byte {
 // Container: File
 // Mandatory: Yes
 // Quantity: Exactly one
+//
+// The Segment Type Box (‘styp’) of media segment files shares its layout and is
+// parsed into a FtypBox as well.
 type FtypBox struct {
 	*Box
 	MajorBrand       string   // Brand identifer.
@@ -152,16 +526,72 @@ type FtypBox struct {
 
 func (b *FtypBox) parse() error {
 	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
 	b.MajorBrand = string(data[0:4])
 	b.MinorVersion = binary.BigEndian.Uint32(data[4:8])
 	if len(data) > 8 {
-		for i := 8; i < len(data); i += 4 {
+		for i := 8; i+4 <= len(data); i += 4 {
 			b.CompatibleBrands = append(b.CompatibleBrands, string(data[i:i+4]))
 		}
 	}
 	return nil
 }
 
+// Common brands of the ftyp and styp boxes.
+const (
+	BrandIsom = "isom" // ISO base media file format
+	BrandIso2 = "iso2"
+	BrandIso4 = "iso4"
+	BrandIso5 = "iso5"
+	BrandIso6 = "iso6"
+	BrandMp41 = "mp41" // MP4 version 1
+	BrandMp42 = "mp42" // MP4 version 2
+	BrandAvc1 = "avc1" // AVC video
+	BrandM4A  = "M4A " // iTunes audio
+	BrandM4V  = "M4V " // iTunes video
+	BrandQT   = "qt  " // QuickTime movie
+	BrandDash = "dash" // MPEG-DASH segments
+	BrandMsdh = "msdh" // MPEG-DASH media segment
+	BrandMsix = "msix" // MPEG-DASH indexed media segment
+	BrandCmfc = "cmfc" // CMAF track
+	BrandCmf2 = "cmf2" // CMAF track, constrained
+	BrandHeic = "heic" // HEIF image, HEVC coded
+	BrandMif1 = "mif1" // HEIF image
+)
+
+// HasBrand reports whether brand is the major brand or one of the compatible
+// brands of the box. A nil box has no brands.
+func (b *FtypBox) HasBrand(brand string) bool {
+	if b == nil {
+		return false
+	}
+	if b.MajorBrand == brand {
+		return true
+	}
+	for _, compatible := range b.CompatibleBrands {
+		if compatible == brand {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *FtypBox) String() string {
+	return fmt.Sprintf("major_brand=%s minor_version=%d compatible_brands=%s",
+		b.MajorBrand, b.MinorVersion, strings.Join(b.CompatibleBrands, ","))
+}
+
+// IsQuickTime reports whether the file is a QuickTime movie: its major brand
+// is ‘qt  ’, or it predates the ftyp box and starts with the movie boxes.
+func (m *Mp4Reader) IsQuickTime() bool {
+	if m.Ftyp == nil {
+		return m.Styp == nil
+	}
+	return m.Ftyp.MajorBrand == BrandQT
+}
+
 // MovieBox - The metadata for a presentation is stored in the single Movie Box
 // Box Type: ‘moov’
 // Container: File
@@ -169,34 +599,113 @@ func (b *FtypBox) parse() error {
 // Quantity: Exactly one
 type MovieBox struct {
 	*Box
-	Mvhd *MovieHeaderBox
-	// @todo На самом деле их можеть быть сколь угодно много, так что по-хорошему тут должен быть массив
-	Trak *TrackBox
+	Mvhd  *MovieHeaderBox
+	Traks []*TrackBox // All tracks in file order.
+	Trak  *TrackBox   // The first video track.
+	Udta  *UserDataBox
+	Meta  *MetaBox
+	Mvex  *MovieExtendsBox // Present in files with movie fragments.
+	Psshs []*ProtectionSystemSpecificHeaderBox
+	Free  []*FreeBox
 }
 
 func (b *MovieBox) parse() error {
-	boxes := readBoxes(b.Reader, b.Start+BoxHeaderSize, b.Size-BoxHeaderSize)
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	var trakBoxes []*Box
 
 	for _, box := range boxes {
 		switch box.Name {
 		case "mvhd":
 			b.Mvhd = &MovieHeaderBox{Box: box}
-			b.Mvhd.parse()
+			parseBox(b.Mvhd)
 		case "trak":
-			if trak := parseTrack(box); trak.Mdia.Hdlr.TypeName == "vide" {
-				b.Trak = trak
+			trakBoxes = append(trakBoxes, box)
+		case "udta":
+			b.Udta = &UserDataBox{Box: box}
+			if err := parseBox(b.Udta); err != nil {
+				return b.wrapError(err)
 			}
-
+		case "meta":
+			b.Meta = &MetaBox{Box: box}
+			if err := parseBox(b.Meta); err != nil {
+				return b.wrapError(err)
+			}
+		case "mvex":
+			b.Mvex = &MovieExtendsBox{Box: box}
+			if err := parseBox(b.Mvex); err != nil {
+				return b.wrapError(err)
+			}
+		case "pssh":
+			pssh := &ProtectionSystemSpecificHeaderBox{Box: box}
+			if err := parseBox(pssh); err != nil {
+				return b.wrapError(err)
+			}
+			b.Psshs = append(b.Psshs, pssh)
+		case "free", "skip", "wide":
+			b.Free = append(b.Free, &FreeBox{Box: box})
 		}
 	}
 
+	if b.Traks, err = parseTracks(trakBoxes); err != nil {
+		return b.wrapError(err)
+	}
+	for _, trak := range b.Traks {
+		if trak.HandlerType() == "vide" {
+			b.Trak = trak
+			break
+		}
+	}
 	return nil
 }
 
-func parseTrack(box *Box) *TrackBox {
+func (b *MovieBox) String() string {
+	return fmt.Sprintf("tracks=%d", len(b.Traks))
+}
+
+func parseTrack(box *Box) (*TrackBox, error) {
 	trackBox := &TrackBox{Box: box}
-	trackBox.parse()
-	return trackBox
+	return trackBox, parseBox(trackBox)
+}
+
+// parseTracks parses the trak boxes on a bounded pool of workers. Tracks are
+// independent and io.ReaderAt allows parallel reads, so the only shared state
+// is the result slice, which keeps the file order. The error of the first
+// failing track in file order is returned.
+func parseTracks(boxes []*Box) ([]*TrackBox, error) {
+	traks := make([]*TrackBox, len(boxes))
+	errs := make([]error, len(boxes))
+
+	workers := runtime.NumCPU()
+	if workers > len(boxes) {
+		workers = len(boxes)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				traks[i], errs[i] = parseTrack(boxes[i])
+			}
+		}()
+	}
+	for i := range boxes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return traks, nil
 }
 
 // MovieHeaderBox - This box defines overall information which is media-independent
@@ -207,25 +716,82 @@ func parseTrack(box *Box) *TrackBox {
 type MovieHeaderBox struct {
 	*Box
 	Version          uint8
-	Flags            uint32
-	CreationTime     uint32
-	ModificationTime uint32
+	Flags            [3]byte
+	CreationTime     uint64 // 32-bit in version 0, like ModificationTime and Duration.
+	ModificationTime uint64
 	Timescale        uint32
-	Duration         uint32
+	Duration         uint64
 	Rate             Fixed32
 	Volume           Fixed16
+	Matrix           [9]Fixed32 // {a, b, u, c, d, v, x, y, w}: u, v and w are 2.30, the others 16.16.
+	// QuickTime preview and selection times, pre_defined zeros in ISO files.
+	PreviewTime       uint32
+	PreviewDuration   uint32
+	PosterTime        uint32
+	SelectionTime     uint32
+	SelectionDuration uint32
+	CurrentTime       uint32
+	NextTrackID       uint32 // Greater than the largest track ID in use.
 }
 
 func (b *MovieHeaderBox) parse() error {
 	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
-	b.Timescale = binary.BigEndian.Uint32(data[12:16])
-	b.Duration = binary.BigEndian.Uint32(data[16:20])
-	b.Rate = fixed32(data[20:24])
-	b.Volume = fixed16(data[24:26])
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	// The times and the duration are 64-bit in version 1.
+	offset := 20
+	if b.Version == 1 {
+		offset = 32
+	}
+	if len(data) < offset+80 {
+		return b.errorf("box is too short")
+	}
+	if b.Version == 1 {
+		b.CreationTime = binary.BigEndian.Uint64(data[4:12])
+		b.ModificationTime = binary.BigEndian.Uint64(data[12:20])
+		b.Timescale = binary.BigEndian.Uint32(data[20:24])
+		b.Duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		b.CreationTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+		b.ModificationTime = uint64(binary.BigEndian.Uint32(data[8:12]))
+		b.Timescale = binary.BigEndian.Uint32(data[12:16])
+		b.Duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	data = data[offset:]
+	b.Rate = fixed32(data[0:4])
+	b.Volume = fixed16(data[4:6])
+	// reserved uint16 and [2]uint32 [6:16]
+	for i := range b.Matrix {
+		b.Matrix[i] = fixed32(data[16+4*i : 20+4*i])
+	}
+	b.PreviewTime = binary.BigEndian.Uint32(data[52:56])
+	b.PreviewDuration = binary.BigEndian.Uint32(data[56:60])
+	b.PosterTime = binary.BigEndian.Uint32(data[60:64])
+	b.SelectionTime = binary.BigEndian.Uint32(data[64:68])
+	b.SelectionDuration = binary.BigEndian.Uint32(data[68:72])
+	b.CurrentTime = binary.BigEndian.Uint32(data[72:76])
+	b.NextTrackID = binary.BigEndian.Uint32(data[76:80])
 	return nil
 }
 
+// DurationSeconds returns the movie duration in seconds.
+func (b *MovieHeaderBox) DurationSeconds() float64 {
+	if b.Timescale == 0 {
+		return 0
+	}
+	return float64(b.Duration) / float64(b.Timescale)
+}
+
+func (b *MovieHeaderBox) String() string {
+	return fmt.Sprintf("timescale=%d duration=%v rate=%v volume=%v next_track_id=%d",
+		b.Timescale, ticksToDuration(b.Duration, b.Timescale), b.Rate, b.Volume, b.NextTrackID)
+}
+
 // TrackBox - This is a container box for a single track of a presentation
 // Box Type: ‘trak’
 // Container: Movie Box (‘moov’)
@@ -234,26 +800,78 @@ func (b *MovieHeaderBox) parse() error {
 type TrackBox struct {
 	*Box
 	Tkhd *TrackHeaderBox
+	Tref *TrackReferenceBox
+	Edts *EditBox
 	Mdia *MediaBox
+	Udta *UserDataBox
 }
 
 func (b *TrackBox) parse() error {
-	boxes := readBoxes(b.Reader, b.Start+BoxHeaderSize, b.Size-BoxHeaderSize)
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
 
 	for _, box := range boxes {
 		switch box.Name {
 		case "tkhd":
 			b.Tkhd = &TrackHeaderBox{Box: box}
-			b.Tkhd.parse()
+			parseBox(b.Tkhd)
+
+		case "tref":
+			b.Tref = &TrackReferenceBox{Box: box}
+			if err := parseBox(b.Tref); err != nil {
+				return b.wrapError(err)
+			}
+
+		case "edts":
+			b.Edts = &EditBox{Box: box}
+			if err := parseBox(b.Edts); err != nil {
+				return b.wrapError(err)
+			}
+
+		case "udta":
+			b.Udta = &UserDataBox{Box: box}
+			if err := parseBox(b.Udta); err != nil {
+				return b.wrapError(err)
+			}
 
 		case "mdia":
 			b.Mdia = &MediaBox{Box: box}
-			b.Mdia.parse()
+			if err := parseBox(b.Mdia); err != nil {
+				return b.wrapError(err)
+			}
 		}
 	}
 	return nil
 }
 
+func (b *TrackBox) String() string {
+	id := uint32(0)
+	if b.Tkhd != nil {
+		id = b.Tkhd.TrackID
+	}
+	samples := uint32(0)
+	if stbl, err := b.sampleTable(); err == nil {
+		samples = stbl.SampleCount()
+	}
+	return fmt.Sprintf("track_id=%d handler=%s samples=%d", id, b.HandlerType(), samples)
+}
+
+// FrameRate estimates the number of samples per second from the stts table and
+// the media timescale. It returns 0 when the track lacks the required boxes.
+func (b *TrackBox) FrameRate() float64 {
+	if b.Mdia == nil || b.Mdia.Mdhd == nil || b.Mdia.Minf == nil || b.Mdia.Minf.Stbl == nil || b.Mdia.Minf.Stbl.Stts == nil {
+		return 0
+	}
+	stts := b.Mdia.Minf.Stbl.Stts
+	ticks := stts.Duration()
+	if ticks == 0 {
+		return 0
+	}
+	return float64(stts.SampleCount()) * float64(b.Mdia.Mdhd.Timescale) / float64(ticks)
+}
+
 // TrackHeaderBox - This box specifies the characteristics of a single track
 // Box Type: ‘tkhd’
 // Container: Track Box (‘trak’)
@@ -271,13 +889,16 @@ type TrackHeaderBox struct {
 	Layer            uint16
 	AlternateGroup   uint16
 	Volume           Fixed16
-	Width            Fixed16
-	Height           Fixed16
+	Matrix           [9]Fixed32 // {a, b, u, c, d, v, x, y, w}: u, v and w are 2.30, the others 16.16.
+	Width            Fixed32
+	Height           Fixed32
 }
 
 func (b *TrackHeaderBox) parse() error {
-	fmt.Println("tkhd.parse()")
 	data := b.ReadBoxData()
+	if len(data) < 84 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
@@ -293,13 +914,44 @@ func (b *TrackHeaderBox) parse() error {
 	b.AlternateGroup = binary.BigEndian.Uint16(data[34:36])
 	b.Volume = fixed16(data[36:38])
 	// reserved uint16 [38:40]
-	// matrix [9]int32 [40:76]
-	b.Width = fixed16(data[76:80])
-	b.Height = fixed16(data[80:84])
+	for i := range b.Matrix {
+		b.Matrix[i] = fixed32(data[40+4*i : 44+4*i])
+	}
+	b.Width = fixed32(data[76:80])
+	b.Height = fixed32(data[80:84])
 
 	return nil
 }
 
+// Fixed-point values of the rotation matrix coefficients.
+const (
+	fixed32One      = Fixed32(0x00010000)
+	fixed32MinusOne = Fixed32(0xffff0000)
+)
+
+// Rotation returns the clockwise rotation in degrees described by the matrix:
+// 0, 90, 180 or 270. ok is false when the matrix is not a plain rotation, e.g.
+// when it mirrors or scales the picture.
+func (b *TrackHeaderBox) Rotation() (degrees int, ok bool) {
+	m := b.Matrix
+	switch [4]Fixed32{m[0], m[1], m[3], m[4]} {
+	case [4]Fixed32{fixed32One, 0, 0, fixed32One}:
+		return 0, true
+	case [4]Fixed32{0, fixed32One, fixed32MinusOne, 0}:
+		return 90, true
+	case [4]Fixed32{fixed32MinusOne, 0, 0, fixed32MinusOne}:
+		return 180, true
+	case [4]Fixed32{0, fixed32MinusOne, fixed32One, 0}:
+		return 270, true
+	}
+	return 0, false
+}
+
+func (b *TrackHeaderBox) String() string {
+	return fmt.Sprintf("track_id=%d duration=%d width=%d height=%d",
+		b.TrackID, b.Duration, b.Width>>16, b.Height>>16)
+}
+
 // MediaBox - The media declaration container contains all the objects that declare information about the media data within a track
 // Box Type: ‘mdia’
 // Container: Track Box (‘trak’)
@@ -313,22 +965,26 @@ type MediaBox struct {
 }
 
 func (b *MediaBox) parse() error {
-	fmt.Println("MediaBox.parse()")
-	boxes := readBoxes(b.Reader, b.Start+BoxHeaderSize, b.Size-BoxHeaderSize)
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
 
 	for _, box := range boxes {
 		switch box.Name {
 		case "mdhd":
 			b.Mdhd = &MediaHeaderBox{Box: box}
-			b.Mdhd.parse()
+			parseBox(b.Mdhd)
 
 		case "hdlr":
 			b.Hdlr = &HandlerBox{Box: box}
-			b.Hdlr.parse()
+			parseBox(b.Hdlr)
 
 		case "minf":
 			b.Minf = &MediaInformationBox{Box: box}
-			b.Minf.parse()
+			if err := parseBox(b.Minf); err != nil {
+				return b.wrapError(err)
+			}
 		}
 	}
 	return nil
@@ -343,31 +999,73 @@ type MediaHeaderBox struct {
 	*Box
 	Version          uint8
 	Flags            [3]byte
-	CreationTime     uint32
-	ModificationTime uint32
+	CreationTime     uint64 // 32-bit in version 0, like ModificationTime and Duration.
+	ModificationTime uint64
 	Timescale        uint32
-	Duration         uint32
-	Language         [3]byte
+	Duration         uint64
+	Language         string // ISO-639-2/T language code, "und" when undetermined or malformed.
 	PreDefined       uint16
 }
 
 func (b *MediaHeaderBox) parse() error {
-	fmt.Println("mdhd.parse()")
 	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
 	}
-	// flags 24 bit
-	b.CreationTime = binary.BigEndian.Uint32(data[4:8])
-	b.ModificationTime = binary.BigEndian.Uint32(data[8:12])
-	b.Timescale = binary.BigEndian.Uint32(data[8:12])
-	b.Duration = binary.BigEndian.Uint32(data[12:16])
-	// b.Language = language(data[16:19])
-	b.PreDefined = binary.BigEndian.Uint16(data[19:21])
+	// The times and the duration are 64-bit in version 1.
+	offset := 20
+	if b.Version == 1 {
+		offset = 32
+	}
+	if len(data) < offset+4 {
+		return b.errorf("box is too short")
+	}
+	if b.Version == 1 {
+		b.CreationTime = binary.BigEndian.Uint64(data[4:12])
+		b.ModificationTime = binary.BigEndian.Uint64(data[12:20])
+		b.Timescale = binary.BigEndian.Uint32(data[20:24])
+		b.Duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		b.CreationTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+		b.ModificationTime = uint64(binary.BigEndian.Uint32(data[8:12]))
+		b.Timescale = binary.BigEndian.Uint32(data[12:16])
+		b.Duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	b.Language = language(data[offset : offset+2])
+	b.PreDefined = binary.BigEndian.Uint16(data[offset+2 : offset+4])
 	return nil
 }
 
+// language decodes a packed ISO-639-2/T language code: a pad bit followed by
+// three 5-bit values, each an offset from 0x60.
+func language(data []byte) string {
+	packed := binary.BigEndian.Uint16(data)
+	code := make([]byte, 3)
+	for i := 0; i < 3; i++ {
+		c := (packed >> uint(10-5*i)) & 0x1f
+		if c < 1 || c > 26 {
+			return "und"
+		}
+		code[i] = byte(c) + 0x60
+	}
+	return string(code)
+}
+
+// MediaDuration returns the Duration field converted to a time.Duration with
+// the track's own Timescale. It is not named Duration, which is taken by the
+// field.
+func (b *MediaHeaderBox) MediaDuration() time.Duration {
+	return ticksToDuration(b.Duration, b.Timescale)
+}
+
+func (b *MediaHeaderBox) String() string {
+	return fmt.Sprintf("timescale=%d duration=%v language=%s", b.Timescale, b.MediaDuration(), b.Language)
+}
+
 // Handler Reference Box - This box within a Media Box declares the process by which the media-data in the track is presented
 // Box Type: ‘hdlr’
 // Container: Media Box (‘mdia’) or Meta Box (‘meta’)
@@ -385,6 +1083,9 @@ type HandlerBox struct {
 
 func (b *HandlerBox) parse() error {
 	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
@@ -395,11 +1096,13 @@ func (b *HandlerBox) parse() error {
 	// b.reserved = reserverd(data[12:24])
 	b.TypeName = string(data[8:12])
 
-	fmt.Println("handlerType.parse(). TypeName = ", b.TypeName)
-
 	return nil
 }
 
+func (b *HandlerBox) String() string {
+	return fmt.Sprintf("handler_type=%s", b.TypeName)
+}
+
 // MediaInformationBox - This box contains all the objects that declare characteristic information of the media in the track.
 // Box Type: ‘minf’
 // Container: Media Box (‘mdia’)
@@ -410,28 +1113,41 @@ type MediaInformationBox struct {
 	Vmhd *VideoMediaHeaderBox
 	Smhd *SoundMediaHeaderBox
 	Hmhd *HintMediaHeaderBox
-	// Nmhd *NullMediaHeaderBox
-	// Dinf *DataInformationBox
+	Nmhd *NullMediaHeaderBox
+	Dinf *DataInformationBox
 	Stbl *SampleTableBox
 }
 
 func (b *MediaInformationBox) parse() error {
-	boxes := readBoxes(b.Reader, b.Start+BoxHeaderSize, b.Size-BoxHeaderSize)
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
 
 	for _, box := range boxes {
 		switch box.Name {
 		case "vmhd":
 			b.Vmhd = &VideoMediaHeaderBox{Box: box}
-			b.Vmhd.parse()
+			parseBox(b.Vmhd)
 		case "smhd":
 			b.Smhd = &SoundMediaHeaderBox{Box: box}
-			b.Smhd.parse()
+			parseBox(b.Smhd)
 		case "hmhd":
 			b.Hmhd = &HintMediaHeaderBox{Box: box}
-			b.Hmhd.parse()
+			parseBox(b.Hmhd)
+		case "nmhd":
+			b.Nmhd = &NullMediaHeaderBox{Box: box}
+			parseBox(b.Nmhd)
+		case "dinf":
+			b.Dinf = &DataInformationBox{Box: box}
+			if err := parseBox(b.Dinf); err != nil {
+				return b.wrapError(err)
+			}
 		case "stbl":
 			b.Stbl = &SampleTableBox{Box: box}
-			b.Stbl.parse()
+			if err := parseBox(b.Stbl); err != nil {
+				return b.wrapError(err)
+			}
 		}
 	}
 	return nil
@@ -447,7 +1163,18 @@ type VideoMediaHeaderBox struct {
 }
 
 func (b *VideoMediaHeaderBox) parse() error {
-
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.GraphicsMode = binary.BigEndian.Uint16(data[4:6])
+	for i := 0; i < 3; i++ {
+		b.OpColor[i] = binary.BigEndian.Uint16(data[6+2*i : 8+2*i])
+	}
 	return nil
 }
 
@@ -456,24 +1183,68 @@ type SoundMediaHeaderBox struct {
 	*Box
 	Version  uint8
 	Flags    [3]byte
-	Balance  uint16
+	Balance  Fixed16 // Places mono audio tracks in stereo space, 0 is centre.
 	Reserved uint16
 }
 
 func (b *SoundMediaHeaderBox) parse() error {
-
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.Balance = fixed16(data[4:6])
+	b.Reserved = binary.BigEndian.Uint16(data[6:8])
 	return nil
 }
 
 // HintMediaHeaderBox - The hint media header contains general information, independent of the protocol, for hint tracks
 type HintMediaHeaderBox struct {
 	*Box
-	Version uint8
-	Flags   [3]byte
+	Version    uint8
+	Flags      [3]byte
+	MaxPDUSize uint16 // Size in bytes of the largest PDU of the hint stream.
+	AvgPDUSize uint16
+	MaxBitrate uint32 // Maximum rate in bits/second over any window of one second.
+	AvgBitrate uint32
 }
 
 func (b *HintMediaHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 16 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.MaxPDUSize = binary.BigEndian.Uint16(data[4:6])
+	b.AvgPDUSize = binary.BigEndian.Uint16(data[6:8])
+	b.MaxBitrate = binary.BigEndian.Uint32(data[8:12])
+	b.AvgBitrate = binary.BigEndian.Uint32(data[12:16])
+	// reserved uint32 [16:20]
+	return nil
+}
+
+// NullMediaHeaderBox - Streams other than visual, audio and hint, e.g. timed metadata, may use a null media header
+type NullMediaHeaderBox struct {
+	*Box
+	Version uint8
+	Flags   [3]byte
+}
 
+func (b *NullMediaHeaderBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
 	return nil
 }
 
@@ -485,29 +1256,250 @@ func (b *HintMediaHeaderBox) parse() error {
 type SampleTableBox struct {
 	*Box
 	Stsz *SampleSizeBox
+	Stz2 *CompactSampleSizeBox
 	Stsc *SampleToChunkBox
+	Stsd *SampleDescriptionBox
 	Stco *ChunkOffsetBox
+	Co64 *ChunkLargeOffsetBox
+	Stts *TimeToSampleBox
+	Ctts *CompositionOffsetBox
+	Stss *SyncSampleBox
+	Padb *PaddingBitsBox
+	Sdtp *SampleDependencyTypeBox
+	Subs []*SubSampleInformationBox
+	Saiz []*SampleAuxiliaryInformationSizesBox
+	Saio []*SampleAuxiliaryInformationOffsetsBox
+	Cslg *CompositionToDecodeBox
+	Stdp *DegradationPriorityBox
+	Sbgp []*SampleToGroupBox
+	Sgpd []*SampleGroupDescriptionBox
 }
 
 func (b *SampleTableBox) parse() error {
-	boxes := readBoxes(b.Reader, b.Start+BoxHeaderSize, b.Size-BoxHeaderSize)
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
 
 	for _, box := range boxes {
 		switch box.Name {
+		case "stsd":
+			b.Stsd = &SampleDescriptionBox{Box: box}
+			if err := parseBox(b.Stsd); err != nil {
+				return b.wrapError(err)
+			}
 		case "stsz":
 			b.Stsz = &SampleSizeBox{Box: box}
-			b.Stsz.parse()
+			if err := parseBox(b.Stsz); err != nil {
+				return b.wrapError(err)
+			}
+		case "stz2":
+			b.Stz2 = &CompactSampleSizeBox{Box: box}
+			if err := parseBox(b.Stz2); err != nil {
+				return b.wrapError(err)
+			}
 		case "stsc":
 			b.Stsc = &SampleToChunkBox{Box: box}
-			b.Stsc.parse()
+			if err := parseBox(b.Stsc); err != nil {
+				return b.wrapError(err)
+			}
 		case "stco":
 			b.Stco = &ChunkOffsetBox{Box: box}
-			b.Stco.parse()
+			if err := parseBox(b.Stco); err != nil {
+				return b.wrapError(err)
+			}
+		case "co64":
+			b.Co64 = &ChunkLargeOffsetBox{Box: box}
+			if err := parseBox(b.Co64); err != nil {
+				return b.wrapError(err)
+			}
+		case "stts":
+			b.Stts = &TimeToSampleBox{Box: box}
+			if err := parseBox(b.Stts); err != nil {
+				return b.wrapError(err)
+			}
+		case "ctts":
+			b.Ctts = &CompositionOffsetBox{Box: box}
+			if err := parseBox(b.Ctts); err != nil {
+				return b.wrapError(err)
+			}
+		case "stss":
+			b.Stss = &SyncSampleBox{Box: box}
+			if err := parseBox(b.Stss); err != nil {
+				return b.wrapError(err)
+			}
+		case "padb":
+			b.Padb = &PaddingBitsBox{Box: box}
+			if err := parseBox(b.Padb); err != nil {
+				return b.wrapError(err)
+			}
+		case "sdtp":
+			b.Sdtp = &SampleDependencyTypeBox{Box: box}
+			if err := parseBox(b.Sdtp); err != nil {
+				return b.wrapError(err)
+			}
+		case "cslg":
+			b.Cslg = &CompositionToDecodeBox{Box: box}
+			if err := parseBox(b.Cslg); err != nil {
+				return b.wrapError(err)
+			}
+		case "stdp":
+			b.Stdp = &DegradationPriorityBox{Box: box}
+			if err := parseBox(b.Stdp); err != nil {
+				return b.wrapError(err)
+			}
+		case "sbgp":
+			sbgp := &SampleToGroupBox{Box: box}
+			if err := parseBox(sbgp); err != nil {
+				return b.wrapError(err)
+			}
+			b.Sbgp = append(b.Sbgp, sbgp)
+		case "sgpd":
+			sgpd := &SampleGroupDescriptionBox{Box: box}
+			if err := parseBox(sgpd); err != nil {
+				return b.wrapError(err)
+			}
+			b.Sgpd = append(b.Sgpd, sgpd)
+		case "subs":
+			subs := &SubSampleInformationBox{Box: box}
+			if err := parseBox(subs); err != nil {
+				return b.wrapError(err)
+			}
+			b.Subs = append(b.Subs, subs)
+		case "saiz":
+			saiz := &SampleAuxiliaryInformationSizesBox{Box: box}
+			if err := parseBox(saiz); err != nil {
+				return b.wrapError(err)
+			}
+			b.Saiz = append(b.Saiz, saiz)
+		case "saio":
+			saio := &SampleAuxiliaryInformationOffsetsBox{Box: box}
+			if err := parseBox(saio); err != nil {
+				return b.wrapError(err)
+			}
+			b.Saio = append(b.Saio, saio)
 		}
 	}
 	return nil
 }
 
+// SampleDependency returns the dependency flags of the 1-based sample number
+// from the sdtp box. ok is false when the track has no sdtp box or the sample
+// is not described by it.
+func (b *SampleTableBox) SampleDependency(sampleNumber uint32) (dep SampleDependency, ok bool) {
+	if b.Sdtp == nil || sampleNumber == 0 || sampleNumber > uint32(len(b.Sdtp.Samples)) {
+		return SampleDependency{}, false
+	}
+	return b.Sdtp.Samples[sampleNumber-1], true
+}
+
+// SampleGroupDescription returns the raw sample group entry of the given
+// grouping type, e.g. "roll", that the 1-based sample number belongs to. Samples
+// outside any group fall back to the default of a version 2 description. ok is
+// false when the sample has no group of that type.
+func (b *SampleTableBox) SampleGroupDescription(groupingType string, sampleNumber uint32) (entry []byte, ok bool) {
+	var sgpd *SampleGroupDescriptionBox
+	for _, box := range b.Sgpd {
+		if box.GroupingType == groupingType {
+			sgpd = box
+			break
+		}
+	}
+	if sgpd == nil {
+		return nil, false
+	}
+	index := uint32(0)
+	for _, sbgp := range b.Sbgp {
+		if sbgp.GroupingType == groupingType {
+			index = sbgp.GroupDescriptionIndex(sampleNumber)
+			break
+		}
+	}
+	if index == 0 {
+		index = sgpd.DefaultSampleDescriptionIndex
+	}
+	if index == 0 || index > uint32(len(sgpd.Entries)) {
+		return nil, false
+	}
+	return sgpd.Entries[index-1], true
+}
+
+// SubSamples returns the sub-sample layout of the 1-based sample number from
+// the first subs box describing it, or nil if the sample has no sub-samples.
+func (b *SampleTableBox) SubSamples(sampleNumber uint32) []SubSample {
+	for _, subs := range b.Subs {
+		if l := subs.SubSamples(sampleNumber); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// SampleCount returns the number of samples from whichever of the stsz and stz2
+// boxes is present.
+func (b *SampleTableBox) SampleCount() uint32 {
+	switch {
+	case b.Stsz != nil:
+		return b.Stsz.SampleCount
+	case b.Stz2 != nil:
+		return b.Stz2.SampleCount
+	}
+	return 0
+}
+
+// SizeOf returns the size in bytes of the 1-based sample number from whichever
+// of the stsz and stz2 boxes is present.
+func (b *SampleTableBox) SizeOf(sampleNumber uint32) uint32 {
+	switch {
+	case b.Stsz != nil:
+		return b.Stsz.SizeOf(sampleNumber)
+	case b.Stz2 != nil:
+		return b.Stz2.SizeOf(sampleNumber)
+	}
+	return 0
+}
+
+// ChunkOffsets returns the file offset of every chunk from whichever of the
+// stco and co64 boxes is present.
+func (b *SampleTableBox) ChunkOffsets() ([]int64, error) {
+	switch {
+	case b.Stco != nil:
+		offsets := make([]int64, len(b.Stco.ChunksOffset))
+		for i, offset := range b.Stco.ChunksOffset {
+			offsets[i] = int64(offset)
+		}
+		return offsets, nil
+	case b.Co64 != nil:
+		offsets := make([]int64, len(b.Co64.ChunksOffset))
+		for i, offset := range b.Co64.ChunksOffset {
+			if offset > math.MaxInt64 {
+				return nil, fmt.Errorf("co64: offset %d of chunk %d overflows int64", offset, i+1)
+			}
+			offsets[i] = int64(offset)
+		}
+		return offsets, nil
+	}
+	return nil, fmt.Errorf("sample table has neither stco nor co64 box")
+}
+
+// IsSyncSample reports whether the 1-based sample number is a sync sample. When
+// the stss box is absent every sample is a sync sample.
+func (b *SampleTableBox) IsSyncSample(sampleNumber uint32) bool {
+	if b.Stss == nil {
+		return true
+	}
+	return b.Stss.IsSyncSample(sampleNumber)
+}
+
+// CompositionOffset returns the composition time offset of the 1-based sample
+// number. When the ctts box is absent composition and decoding times are equal.
+func (b *SampleTableBox) CompositionOffset(sampleNumber uint32) int32 {
+	if b.Ctts == nil {
+		return 0
+	}
+	return b.Ctts.CompositionOffset(sampleNumber)
+}
+
 // SampleSizeBox - This box contains the sample count and a table giving the size in bytes of each sample
 // Box Type: stsz’, ‘stz2’
 // Container: Sample Table Box (‘stbl’)
@@ -523,17 +1515,26 @@ type SampleSizeBox struct {
 }
 
 func (b *SampleSizeBox) parse() error {
-	fmt.Println("SampleSizeBox")
 	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
 	}
 
 	b.SampleSize = binary.BigEndian.Uint32(data[4:8])
-	b.SampleCount = binary.BigEndian.Uint32(data[8:12])
-	fmt.Println("stsz.SampleSize: ", b.SampleSize)
-	fmt.Println("stsz.SampleCount: ", b.SampleCount)
+	// The count is only kept once checked, since SampleCount sizes the
+	// sample iteration even when a best-effort parse goes on.
+	count := binary.BigEndian.Uint32(data[8:12])
+	if err := b.checkSampleCount(uint64(count)); err != nil {
+		return err
+	}
+	if b.SampleSize == 0 && uint64(len(data)-12) < uint64(count)*4 {
+		return b.errorf("%d samples do not fit in the box", count)
+	}
+	b.SampleCount = count
 	if b.SampleSize == 0 {
 		b.SamplesSize = make([]uint32, b.SampleCount)
 		for i := uint32(1); i <= b.SampleCount; i++ {
@@ -544,6 +1545,18 @@ func (b *SampleSizeBox) parse() error {
 	return nil
 }
 
+// SizeOf returns the size in bytes of the 1-based sample number, or 0 if there
+// is no such sample.
+func (b *SampleSizeBox) SizeOf(sampleNumber uint32) uint32 {
+	if sampleNumber == 0 || sampleNumber > b.SampleCount {
+		return 0
+	}
+	if b.SampleSize != 0 {
+		return b.SampleSize
+	}
+	return b.SamplesSize[sampleNumber-1]
+}
+
 // SampleToChunkBox - Samples within the media data are grouped into chunks. Chunks can be of different sizes, and the samples
 // within a chunk can have different sizes
 // Box Type: ‘stsc’
@@ -559,14 +1572,22 @@ type SampleToChunkBox struct {
 }
 
 func (b *SampleToChunkBox) parse() error {
-	fmt.Println("SampleToChunkBox")
 	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
 	}
 	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*12 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
 
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
 	b.SampleToChunks = make([]uint32, b.EntryCount * 3)
 	for i := 1; i <= len(b.SampleToChunks); i+=3 {
 		b.SampleToChunks[i - 1] = binary.BigEndian.Uint32(data[4*(2+(i - 1)):4*(2+(i-1))+4])
@@ -591,14 +1612,21 @@ type ChunkOffsetBox struct {
 }
 
 func (b *ChunkOffsetBox) parse() error {
-	fmt.Println("ChunkOffsetBox")
 	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
 	b.Version = data[0]
 	for i := 0; i < 3; i++ {
 		b.Flags[i] = data[i+1]
 	}
 	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
-	fmt.Println("stco.EntryCount: ", b.EntryCount)
+	if uint64(len(data)-8) < uint64(b.EntryCount)*4 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
 	b.ChunksOffset = make([]uint32, b.EntryCount)
 	for i := uint32(1); i <= b.EntryCount; i++ {
 		b.ChunksOffset[i - 1] = binary.BigEndian.Uint32(data[4*(i+1):4*(i+1)+4])
@@ -606,6 +1634,42 @@ func (b *ChunkOffsetBox) parse() error {
 	return nil
 }
 
+// ChunkLargeOffsetBox - The 64-bit variant of the chunk offset table
+// Box Type: ‘co64’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: Yes
+// Quantity: Exactly one variant must be present
+type ChunkLargeOffsetBox struct {
+	*Box
+	Version      uint8
+	Flags        [3]byte
+	EntryCount   uint32
+	ChunksOffset []uint64
+}
+
+func (b *ChunkLargeOffsetBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*8 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.ChunksOffset = make([]uint64, b.EntryCount)
+	for i := range b.ChunksOffset {
+		b.ChunksOffset[i] = binary.BigEndian.Uint64(data[8+8*i : 16+8*i])
+	}
+	return nil
+}
+
 // MediaDataBox - This box contains the media data
 // Box Type: ‘mdat’
 // Container: File
@@ -613,7 +1677,7 @@ func (b *ChunkOffsetBox) parse() error {
 // Quantity: Any number
 type MediaDataBox struct {
 	*Box
-	Data []byte
+	Data []byte `json:"-"` // Only loaded when Mp4Reader.ReadMdatData is set, and SkipMdat is not.
 }
 
 func (b *MediaDataBox) parse() error {
@@ -621,89 +1685,221 @@ func (b *MediaDataBox) parse() error {
 	return nil
 }
 
-func extractVideoChunks(mp4 *Mp4Reader) (videoStream []byte) {
-	chunks := bytes.NewBuffer([]byte{0, 0, 0, 1})
-	chunks.Write(mp4.Mdat.Data[4:])
+// FreeBox - The contents of a free-space box are irrelevant and may be ignored
+// Box Type: ‘free’, ‘skip’ (and the QuickTime ‘wide’)
+// Container: File or other box
+// Mandatory: No
+// Quantity: Any number
+//
+// The position and length are kept so that a writer can reproduce or strip the padding.
+type FreeBox struct {
+	*Box
+}
 
-	offsets := mp4.Moov.Trak.Mdia.Minf.Stbl.Stco.ChunksOffset
-	samplesSizes := mp4.Moov.Trak.Mdia.Minf.Stbl.Stsz.SamplesSize
-	sampleToChunks := mp4.Moov.Trak.Mdia.Minf.Stbl.Stsc.SampleToChunks
+// writeStream creates the file and writes the stream produced by extract to
+// it. The file is removed if extraction fails.
+func writeStream(fileName string, extract func(w io.Writer) error) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0644))
+	if err != nil {
+		fmt.Println("Unable to open file")
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = extract(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fileName)
+	}
+	return err
+}
 
-	k := 0
-	for i := uint32(0); i < 1; i++ {
-		if k >= len(sampleToChunks) {
+const usage = `Usage: webinar <command> [flags]
 
-		}
-		if uint32(i) > sampleToChunks[k]-1 && k+3 < len(sampleToChunks){
-			k+=3
-		}
-		// Читаем целый чанк равный количеству сэмплов в нём, умноженные на размер этих сэмплов
-			chunks.Write(mp4.ReadBytesAt(int64(samplesSizes[i] * sampleToChunks[k + 1]), int64(offsets[i])))
-	}
+Commands:
+  info     print a summary of the file
+  dump     print the box tree of the file
+  extract  extract a track as an Annex-B (H.264) or ADTS (AAC) stream
 
-	fmt.Println("Offsets.size = ", len(offsets))
-	// for _, size := range offsets {
-	// 	fmt.Println("stsz.entry_size: ", size)
-	// }
-	fmt.Println("samplesSizes.size = ", len(samplesSizes))
-	// for _, size := range samplesSizes {
-	// 	fmt.Println("stsz.entry_size: ", size)
-	// }
+Run "webinar <command> -h" for the flags of a command.
+`
 
-	fmt.Println("sampleToChunks.size = ", mp4.Moov.Trak.Mdia.Minf.Stbl.Stsc.EntryCount, len(sampleToChunks))
-	for i := 1; i <= len(sampleToChunks); i+=3 {
-		fmt.Println("stsc.first_chunk: ", sampleToChunks[i - 1])
-		fmt.Println("stsc.samples_per_chunk: ", sampleToChunks[i])
-		fmt.Println("stsc.sample_description_chunk: ", sampleToChunks[i + 1])
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	// @todo convert in Annex-B format
-	// ...
-
-	return chunks.Bytes()
+	var err error
+	switch os.Args[1] {
+	case "info":
+		err = runInfo(os.Args[2:])
+	case "dump":
+		err = runDump(os.Args[2:])
+	case "extract":
+		err = runExtract(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func writeVideoStreamInAnnexBFormat(bytes []byte, fileName string) error {
-	err := ioutil.WriteFile(fileName, bytes, os.FileMode(0644))
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	inputFileName := fs.String("input", "input.mp4", "name of .mp4 file")
+	asJSON := fs.Bool("json", false, "print the summary as JSON")
+	fs.Parse(args)
+
+	file, err := os.Open(*inputFileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	info, err := ProbeFormat(file, stat.Size())
 	if err != nil {
-		fmt.Println("Unable to open file")
 		return err
 	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	fmt.Println("major_brand: ", info.MajorBrand)
+	fmt.Println("compatible_brands: ", info.CompatibleBrands)
+	fmt.Println("duration: ", info.Duration)
+	for _, t := range info.Tracks {
+		fmt.Printf("track %d: type=%s codec=%s", t.TrackID, t.Type, t.Codec)
+		if t.Width != 0 || t.Height != 0 {
+			fmt.Printf(" size=%dx%d", t.Width, t.Height)
+		}
+		fmt.Printf(" timescale=%d duration=%v samples=%d bitrate=%d\n", t.Timescale, t.Duration, t.SampleCount, t.Bitrate)
+	}
 	return nil
 }
 
-func main() {
-	inputFileName := flag.String("input", "input.mp4", "name of .mp4 file")
-	outputFileName := flag.String("output", "output.h264", "name of output file")
-	flag.Parse()
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	inputFileName := fs.String("input", "input.mp4", "name of .mp4 file")
+	fs.Parse(args)
 
 	mp4, err := Open(*inputFileName)
-	defer mp4.Reader.(*os.File).Close()
 	if err != nil {
-		fmt.Println("Unable to open file")
-		return
+		return err
 	}
+	defer mp4.Close()
+
+	parsed := mp4.parsedBoxes()
+	return mp4.Walk(func(box *Box, depth int) error {
+		fmt.Printf("%*s[%s] start=%d size=%d", 2*depth, "", box.Name, box.Start, box.Size)
+		if s, ok := parsed[box.Start].(fmt.Stringer); ok {
+			// Parsed boxes without a summary of their own inherit Box.String.
+			if summary := s.String(); summary != box.String() {
+				fmt.Printf(" %s", summary)
+			}
+		} else if _, container := containerHeaderSize(box); !container && box.PayloadSize() > 0 {
+			fmt.Printf(" data=%s", box.preview())
+		}
+		fmt.Println()
+		return nil
+	})
+}
 
-	fmt.Println("ftyp.name: ", mp4.Ftyp.Name)
-	fmt.Println("ftyp.major_brand: ", mp4.Ftyp.MajorBrand)
-	fmt.Println("ftyp.minor_version: ", mp4.Ftyp.MinorVersion)
-	fmt.Println("ftyp.compatible_brands: ", mp4.Ftyp.CompatibleBrands)
-
-	fmt.Println("moov.name: ", mp4.Moov.Name, mp4.Moov.Size)
-	fmt.Println("moov.mvhd.name: ", mp4.Moov.Mvhd.Name)
-	fmt.Println("moov.mvhd.version: ", mp4.Moov.Mvhd.Version)
-	fmt.Println("moov.mvhd.volume: ", mp4.Moov.Mvhd.Volume)
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	inputFileName := fs.String("input", "input.mp4", "name of .mp4 file")
+	outputFileName := fs.String("output", "", "name of output file (default output.h264, output.h265 or output.aac)")
+	trackID := fs.Uint("track", 0, "ID of the track to extract (default the first video track)")
+	split := fs.Bool("split", false, "write every video frame to its own file in the -output directory (default frames)")
+	fs.Parse(args)
 
-	fmt.Println("moov.Trak.Tkhd.Version: ", mp4.Moov.Trak.Tkhd.Version)
-	fmt.Println("moov.Trak.Tkhd.CreationTime: ", mp4.Moov.Trak.Tkhd.CreationTime)
-	fmt.Println("moov.Trak.Tkhd.ModificationTime: ", mp4.Moov.Trak.Tkhd.ModificationTime)
-	fmt.Println("moov.Trak.Tkhd.Duration: ", mp4.Moov.Trak.Tkhd.Duration)
-	fmt.Println("moov.Trak.Tkhd.TrackID: ", mp4.Moov.Trak.Tkhd.TrackID)
-	fmt.Println("moov.Trak.Tkhd.Volume: ", mp4.Moov.Trak.Tkhd.Volume)
-	fmt.Printf("moov.Trak.Tkhd.Width: %f \n", mp4.Moov.Trak.Tkhd.Width)
-	fmt.Printf("moov.Trak.Tkhd.Height: %f \n", mp4.Moov.Trak.Tkhd.Height)
+	mp4, err := Open(*inputFileName)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	if mp4.Moov == nil {
+		return fmt.Errorf("%s: no moov box found", *inputFileName)
+	}
 
-	fmt.Println("moov.Trak.Mdia.Hdir.TypeName: ", mp4.Moov.Trak.Mdia.Hdlr.TypeName)
+	track := mp4.Moov.Trak
+	if *trackID != 0 {
+		if track, err = mp4.TrackByID(uint32(*trackID)); err != nil {
+			return fmt.Errorf("%s: %v", *inputFileName, err)
+		}
+	}
+	if track == nil {
+		return fmt.Errorf("%s: track not found", *inputFileName)
+	}
+	stbl, err := track.sampleTable()
+	if err != nil {
+		return fmt.Errorf("%s: %v", *inputFileName, err)
+	}
+	if stbl.SampleCount() == 0 {
+		if len(mp4.Moofs) > 0 {
+			return fmt.Errorf("%s: track samples are stored in movie fragments, which cannot be extracted", *inputFileName)
+		}
+		return fmt.Errorf("%s: track has no samples", *inputFileName)
+	}
+	if mp4.Mdat == nil && track.IsSelfContained() {
+		return fmt.Errorf("%s: no mdat box found, the file holds no media data", *inputFileName)
+	}
+	if track.IsEncrypted() {
+		return fmt.Errorf("%s: track is encrypted and cannot be extracted", *inputFileName)
+	}
+	// Reference movies point to media files next to them.
+	mp4.ExternalDataResolver = func(url string) (io.ReaderAt, error) {
+		path := strings.TrimPrefix(url, "file://")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(*inputFileName), path)
+		}
+		return os.Open(path)
+	}
 
-	writeVideoStreamInAnnexBFormat(extractVideoChunks(mp4), *outputFileName)
+	var extract func(w io.Writer) error
+	switch track.HandlerType() {
+	case "vide":
+		if *split {
+			dir := *outputFileName
+			if dir == "" {
+				dir = "frames"
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			return ExtractFrames(track, dir)
+		}
+		if *outputFileName == "" {
+			*outputFileName = "output.h264"
+			if entry := track.VisualSampleEntry(); entry != nil && entry.Hvcc != nil {
+				*outputFileName = "output.h265"
+			}
+		}
+		extract = func(w io.Writer) error { return ExtractAnnexB(track, w) }
+	case "soun":
+		if *outputFileName == "" {
+			*outputFileName = "output.aac"
+		}
+		if *split {
+			return fmt.Errorf("-split only applies to video tracks")
+		}
+		extract = func(w io.Writer) error { return ExtractADTS(track, w) }
+	default:
+		return fmt.Errorf("cannot extract a %q track", track.HandlerType())
+	}
+	return writeStream(*outputFileName, extract)
 }
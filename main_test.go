@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenMinimalFile(t *testing.T) {
+	samples := [][]byte{[]byte("first sample"), []byte("second"), []byte("third sample")}
+	data := buildFile(testTrack{id: 1, samples: samples})
+	m, err := Open(writeFile(t, "minimal.mp4", data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer m.Close()
+
+	if m.Ftyp == nil || m.Ftyp.MajorBrand != "isom" {
+		t.Fatalf("ftyp = %v, want major brand isom", m.Ftyp)
+	}
+	if m.Moov == nil || m.Moov.Mvhd == nil {
+		t.Fatal("moov or mvhd not parsed")
+	}
+	if got := m.Moov.Mvhd.Timescale; got != 1000 {
+		t.Errorf("mvhd timescale = %d, want 1000", got)
+	}
+	if len(m.Moov.Traks) != 1 {
+		t.Fatalf("got %d tracks, want 1", len(m.Moov.Traks))
+	}
+	trak := m.Moov.Traks[0]
+	if trak.Tkhd == nil || trak.Tkhd.TrackID != 1 {
+		t.Errorf("tkhd = %v, want track ID 1", trak.Tkhd)
+	}
+	if got := trak.HandlerType(); got != "vide" {
+		t.Errorf("handler type = %q, want vide", got)
+	}
+	if trak.Mdia.Mdhd == nil || trak.Mdia.Mdhd.Timescale != 1000 {
+		t.Errorf("mdhd = %v, want timescale 1000", trak.Mdia.Mdhd)
+	}
+	if got := trak.Mdia.Minf.Stbl.SampleCount(); got != 3 {
+		t.Errorf("sample count = %d, want 3", got)
+	}
+	if m.Mdat == nil || m.Mdat.Start+m.Mdat.Size != int64(len(data)) {
+		t.Errorf("mdat = %v, want the last box of the file", m.Mdat)
+	}
+	for i, want := range samples {
+		got, err := trak.ReadSample(uint32(i + 1))
+		if err != nil {
+			t.Fatalf("ReadSample(%d): %v", i+1, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("sample %d = %q, want %q", i+1, got, want)
+		}
+	}
+	if errs := m.Validate(); len(errs) > 0 {
+		t.Errorf("Validate: %v", errs)
+	}
+}
+
+// TestRandomBoxHeaders overwrites the size and type of random boxes of valid
+// files and checks that parsing ends, without panics, on boxes that lie within
+// the file.
+func TestRandomBoxHeaders(t *testing.T) {
+	seeds := [][]byte{
+		buildFile(testTrack{id: 1, samples: [][]byte{{0}, {1}}}),
+		buildFile(testTrack{id: 1, samples: [][]byte{{0}}, sync: []uint32{1}},
+			testTrack{id: 2, handler: "soun", samples: [][]byte{{1}, {2}, {3}}, perChunk: 2}),
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, seed := range seeds {
+		var headers []int64
+		parseFile(t, seed).Walk(func(box *Box, depth int) error {
+			headers = append(headers, box.Start)
+			return nil
+		})
+
+		for i := 0; i < 1000; i++ {
+			data := append([]byte(nil), seed...)
+			for n := 1 + rng.Intn(3); n > 0; n-- {
+				header := data[headers[rng.Intn(len(headers))]:]
+				switch rng.Intn(4) {
+				case 0:
+					// Sizes around the header size, including 0 and the 64-bit marker.
+					binary.BigEndian.PutUint32(header, uint32(rng.Int63n(2*BoxHeaderSize)))
+				case 1:
+					binary.BigEndian.PutUint32(header, rng.Uint32())
+				case 2:
+					binary.BigEndian.PutUint32(header, uint32(len(data)+rng.Intn(64)))
+				default:
+					rng.Read(header[4:8])
+				}
+			}
+			for _, bestEffort := range []bool{false, true} {
+				m, err := NewReader(bytes.NewReader(data), int64(len(data)), WithParseOptions(ParseOptions{BestEffort: bestEffort}))
+				if err != nil {
+					continue
+				}
+				m.Walk(func(box *Box, depth int) error {
+					if box.Size < BoxHeaderSize || box.Start < 0 || box.Start+box.Size > int64(len(data)) {
+						t.Fatalf("iteration %d: box %q at %d of size %d outside the file", i, box.Name, box.Start, box.Size)
+					}
+					return nil
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkParseTracks compares parsing the trak boxes of a many-track file one
+// after the other with parseTracks.
+func BenchmarkParseTracks(b *testing.B) {
+	var tracks []testTrack
+	for id := uint32(1); id <= 16; id++ {
+		samples := make([][]byte, 20000)
+		for i := range samples {
+			samples[i] = []byte{byte(i)}
+		}
+		tracks = append(tracks, testTrack{id: id, samples: samples, perChunk: 10})
+	}
+	data := buildFile(tracks...)
+	m, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var boxes []*Box
+	for _, trak := range m.Moov.Traks {
+		boxes = append(boxes, trak.Box)
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, box := range boxes {
+				if _, err := parseTrack(box); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := parseTracks(boxes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestVideoMediaHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    VideoMediaHeaderBox
+		wantErr bool
+	}{
+		{"copy mode", make([]byte, 8), VideoMediaHeaderBox{Flags: [3]byte{0, 0, 1}}, false},
+		{"blend mode", cat(be16(0x0100), be16(1), be16(2), be16(0xffff)),
+			VideoMediaHeaderBox{Flags: [3]byte{0, 0, 1}, GraphicsMode: 0x0100, OpColor: [3]uint16{1, 2, 0xffff}}, false},
+		{"too short", make([]byte, 6), VideoMediaHeaderBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmhd := &VideoMediaHeaderBox{Box: topBox(t, buildFullBox("vmhd", 0, 1, tt.payload))}
+			err := vmhd.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.Box = vmhd.Box
+			if *vmhd != tt.want {
+				t.Errorf("got %+v, want %+v", *vmhd, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoundMediaHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		balance float64
+		wantErr bool
+	}{
+		{"centre", make([]byte, 4), 0, false},
+		{"right", cat(be16(0x0100), be16(0)), 1, false},
+		{"half right", cat(be16(0x0080), be16(0)), 0.5, false},
+		{"too short", make([]byte, 2), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smhd := &SoundMediaHeaderBox{Box: topBox(t, buildFullBox("smhd", 0, 0, tt.payload))}
+			err := smhd.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if got := smhd.Balance.Float64(); err == nil && got != tt.balance {
+				t.Errorf("balance = %v, want %v", got, tt.balance)
+			}
+		})
+	}
+}
+
+func TestTrackRotation(t *testing.T) {
+	const one, minusOne = 0x00010000, 0xffff0000
+	tests := []struct {
+		name    string
+		matrix  [9]uint32
+		degrees int
+		ok      bool
+	}{
+		{"identity", identityMatrix, 0, true},
+		{"90", [9]uint32{0, one, 0, minusOne, 0, 0, 240 << 16, 0, 0x40000000}, 90, true},
+		{"180", [9]uint32{minusOne, 0, 0, 0, minusOne, 0, 320 << 16, 240 << 16, 0x40000000}, 180, true},
+		{"270", [9]uint32{0, minusOne, 0, one, 0, 0, 0, 320 << 16, 0x40000000}, 270, true},
+		{"mirrored", [9]uint32{minusOne, 0, 0, 0, one, 0, 320 << 16, 0, 0x40000000}, 0, false},
+		{"scaled", [9]uint32{2 * one, 0, 0, 0, 2 * one, 0, 0, 0, 0x40000000}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tkhd := parseFile(t, buildFile(testTrack{id: 1, matrix: tt.matrix})).Moov.Traks[0].Tkhd
+			for i, v := range tt.matrix {
+				if tkhd.Matrix[i] != Fixed32(v) {
+					t.Errorf("matrix[%d] = %#x, want %#x", i, uint32(tkhd.Matrix[i]), v)
+				}
+			}
+			if degrees, ok := tkhd.Rotation(); degrees != tt.degrees || ok != tt.ok {
+				t.Errorf("Rotation() = %d, %v; want %d, %v", degrees, ok, tt.degrees, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFixedPoint(t *testing.T) {
+	type fixed interface {
+		Float64() float64
+		String() string
+	}
+	tests := []struct {
+		name   string
+		value  fixed
+		float  float64
+		string string
+	}{
+		{"Fixed16 one", Fixed16(0x0100), 1, "1"},
+		{"Fixed16 one and a half", Fixed16(0x0180), 1.5, "1.5"},
+		{"Fixed16 smallest", Fixed16(0x0001), 1.0 / 256, "0.004"},
+		{"Fixed32 zero", Fixed32(0), 0, "0"},
+		{"Fixed32 72 dpi", Fixed32(0x00480000), 72, "72"},
+		{"Fixed32 a quarter", Fixed32(0x00004000), 0.25, "0.25"},
+	}
+	for _, tt := range tests {
+		if got := tt.value.Float64(); got != tt.float {
+			t.Errorf("%s: Float64() = %v, want %v", tt.name, got, tt.float)
+		}
+		if got := tt.value.String(); got != tt.string {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.string)
+		}
+	}
+}
+
+func TestMediaHeaderByHandler(t *testing.T) {
+	for _, handler := range []string{"vide", "soun", "hint", "meta", "text"} {
+		t.Run(handler, func(t *testing.T) {
+			trak := parseFile(t, buildFile(testTrack{id: 1, handler: handler})).Moov.Traks[0]
+			minf := trak.Mdia.Minf
+			got := map[string]bool{"vmhd": minf.Vmhd != nil, "smhd": minf.Smhd != nil, "hmhd": minf.Hmhd != nil, "nmhd": minf.Nmhd != nil}
+			want := map[string]bool{"vmhd": handler == "vide", "smhd": handler == "soun", "hmhd": handler == "hint", "nmhd": handler == "meta" || handler == "text"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("media headers %v, want %v", got, want)
+			}
+			if minf.Nmhd != nil && minf.Nmhd.ParseErr != nil {
+				t.Errorf("nmhd: %v", minf.Nmhd.ParseErr)
+			}
+		})
+	}
+}
+
+func TestHintMediaHeader(t *testing.T) {
+	payload := cat(be16(1400), be16(1200), be32s(2000000, 1500000, 0))
+	hmhd := &HintMediaHeaderBox{Box: topBox(t, buildFullBox("hmhd", 0, 0, payload))}
+	if err := hmhd.parse(); err != nil {
+		t.Fatal(err)
+	}
+	want := HintMediaHeaderBox{Box: hmhd.Box, MaxPDUSize: 1400, AvgPDUSize: 1200, MaxBitrate: 2000000, AvgBitrate: 1500000}
+	if *hmhd != want {
+		t.Errorf("got %+v, want %+v", *hmhd, want)
+	}
+
+	short := &HintMediaHeaderBox{Box: topBox(t, buildFullBox("hmhd", 0, 0, be16(1400)))}
+	if err := short.parse(); err == nil {
+		t.Error("parsed a truncated hmhd box")
+	}
+}
+
+func TestHasBrand(t *testing.T) {
+	ftyp := &FtypBox{MajorBrand: BrandMp42, CompatibleBrands: []string{BrandIsom, BrandAvc1}}
+	tests := []struct {
+		box   *FtypBox
+		brand string
+		want  bool
+	}{
+		{ftyp, BrandMp42, true},
+		{ftyp, BrandIsom, true},
+		{ftyp, BrandAvc1, true},
+		{ftyp, BrandQT, false},
+		{ftyp, "mp4", false},
+		{&FtypBox{MajorBrand: BrandM4A}, "M4A ", true},
+		{nil, BrandIsom, false},
+	}
+	for _, tt := range tests {
+		if got := tt.box.HasBrand(tt.brand); got != tt.want {
+			t.Errorf("%v HasBrand(%q) = %v, want %v", tt.box, tt.brand, got, tt.want)
+		}
+	}
+}
+
+func TestExtractWithoutMediaData(t *testing.T) {
+	withSamples := buildFile(testTrack{id: 1, samples: [][]byte{{0, 0, 0, 1, 0x65}}})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"no mdat", withSamples[:len(withSamples)-len(buildBox("mdat", make([]byte, 5)))], "no mdat box found"},
+		{"no samples", buildFile(testTrack{id: 1}), "track has no samples"},
+		{"fragmented", cat(buildFile(testTrack{id: 1}), buildSegment(1, 1, 0, []byte{0, 0, 0, 1, 0x65})), "stored in movie fragments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := writeFile(t, "input.mp4", tt.data)
+			err := runExtract([]string{"-input", input, "-output", input + ".h264"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runExtract error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitNALUnitsLengthSize(t *testing.T) {
+	for _, size := range []int{-1, 0, 5} {
+		if _, err := splitNALUnits([]byte{0, 0, 0, 1, 0x65}, size); err == nil {
+			t.Errorf("length size %d accepted", size)
+		}
+	}
+	for size := 1; size <= 4; size++ {
+		data := cat(make([]byte, size-1), []byte{1, 0x65})
+		if nals, err := splitNALUnits(data, size); err != nil || len(nals) != 1 || !bytes.Equal(nals[0], []byte{0x65}) {
+			t.Errorf("length size %d: NAL units %x, %v", size, nals, err)
+		}
+	}
+}
+
+func TestMovieHeader(t *testing.T) {
+	var matrix [9]Fixed32
+	for i, v := range identityMatrix {
+		matrix[i] = Fixed32(v)
+	}
+	tail := cat(be32s(identityMatrix[:]...), be32s(10, 20, 30, 40, 50, 60), be32(3))
+	tests := []struct {
+		name    string
+		version uint8
+		payload []byte
+		want    MovieHeaderBox
+		wantErr bool
+	}{
+		{"version 0", 0, cat(be32s(1, 2, 600, 1200, 0x00010000), be16(0x0100), make([]byte, 10), tail),
+			MovieHeaderBox{CreationTime: 1, ModificationTime: 2, Timescale: 600, Duration: 1200, Rate: 0x00010000,
+				Volume: 0x0100, Matrix: matrix, PreviewTime: 10, PreviewDuration: 20, PosterTime: 30,
+				SelectionTime: 40, SelectionDuration: 50, CurrentTime: 60, NextTrackID: 3}, false},
+		{"version 1", 1, cat(be64(1<<32), be64(2<<32), be32(90000), be64(1<<33), be32(0x00020000), be16(0),
+			make([]byte, 10), tail),
+			MovieHeaderBox{Version: 1, CreationTime: 1 << 32, ModificationTime: 2 << 32, Timescale: 90000,
+				Duration: 1 << 33, Rate: 0x00020000, Matrix: matrix, PreviewTime: 10, PreviewDuration: 20,
+				PosterTime: 30, SelectionTime: 40, SelectionDuration: 50, CurrentTime: 60, NextTrackID: 3}, false},
+		{"no next_track_ID", 0, cat(be32s(1, 2, 600, 1200, 0x00010000), be16(0x0100), make([]byte, 10),
+			tail[:len(tail)-4]), MovieHeaderBox{}, true},
+		{"version 1 too short", 1, cat(be32s(1, 2, 600, 1200, 0x00010000), be16(0x0100), make([]byte, 10), tail),
+			MovieHeaderBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mvhd := &MovieHeaderBox{Box: topBox(t, buildFullBox("mvhd", tt.version, 0, tt.payload))}
+			err := mvhd.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.Box = mvhd.Box
+			if *mvhd != tt.want {
+				t.Errorf("got %+v, want %+v", *mvhd, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextTrackID(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []uint32
+		want uint32
+	}{
+		{"one track", []uint32{1}, 2},
+		{"two tracks", []uint32{1, 2}, 3},
+		{"gap in the IDs", []uint32{7, 2}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracks []testTrack
+			for _, id := range tt.ids {
+				tracks = append(tracks, testTrack{id: id, samples: [][]byte{{0}}})
+			}
+			m := parseFile(t, buildFile(tracks...))
+			if got := m.Moov.Mvhd.NextTrackID; got != tt.want {
+				t.Errorf("NextTrackID = %d, want %d", got, tt.want)
+			}
+			for _, trak := range m.Moov.Traks {
+				if trak.Tkhd.TrackID >= m.Moov.Mvhd.NextTrackID {
+					t.Errorf("track ID %d not below next_track_ID %d", trak.Tkhd.TrackID, m.Moov.Mvhd.NextTrackID)
+				}
+			}
+		})
+	}
+}
+
+func TestLargeSizeBoxes(t *testing.T) {
+	samples := [][]byte{[]byte("first"), []byte("second")}
+	tracks := []testTrack{{id: 1, samples: samples}}
+	ftyp := buildBox("ftyp", cat([]byte("isom"), be32(0x200), []byte("isom")))
+	mdat := buildLargeBox("mdat", cat(samples...))
+	// The sample offsets depend on the size of moov, which does not depend on
+	// them: build it once to learn its size.
+	moovSize := len(buildMoov(tracks, 0))
+
+	tests := []struct {
+		name string
+		data func() []byte
+	}{
+		{"mdat", func() []byte {
+			return cat(ftyp, buildMoov(tracks, uint32(len(ftyp)+moovSize+16)), mdat)
+		}},
+		{"mdat and moov", func() []byte {
+			moov := buildMoov(tracks, uint32(len(ftyp)+moovSize+8+16))
+			return cat(ftyp, buildLargeBox("moov", moov[BoxHeaderSize:]), mdat)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data()
+			m := parseFile(t, data)
+			if m.Mdat == nil || m.Mdat.HeaderSize != 16 {
+				t.Fatalf("mdat %v, want a 16-byte header", m.Mdat)
+			}
+			if got, want := m.Mdat.PayloadOffset(), m.Mdat.Start+16; got != want {
+				t.Errorf("PayloadOffset() = %d, want %d", got, want)
+			}
+			if got, want := m.Mdat.PayloadSize(), int64(len(cat(samples...))); got != want {
+				t.Errorf("PayloadSize() = %d, want %d", got, want)
+			}
+			if m.Moov == nil || len(m.Moov.Traks) != 1 {
+				t.Fatalf("moov %v, want one track", m.Moov)
+			}
+			for i, want := range samples {
+				if got, err := m.Moov.Traks[0].ReadSample(uint32(i + 1)); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("ReadSample(%d) = %q, %v; want %q", i+1, got, err, want)
+				}
+			}
+		})
+	}
+
+	for name, data := range map[string][]byte{
+		"largesize below the header": cat(ftyp, be32(1), []byte("mdat"), be64(15), make([]byte, 8)),
+		"truncated largesize":        cat(ftyp, be32(1), []byte("mdat"), be32(0)),
+	} {
+		if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestMediaHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint8
+		payload  []byte
+		want     MediaHeaderBox
+		duration time.Duration
+		wantErr  bool
+	}{
+		{"version 0", 0, cat(be32s(1, 2, 48000, 96000), be16(0x55c4), be16(0)),
+			MediaHeaderBox{CreationTime: 1, ModificationTime: 2, Timescale: 48000, Duration: 96000, Language: "und"},
+			2 * time.Second, false},
+		{"version 1", 1, cat(be64(1<<32), be64(2<<32), be32(90000), be64(90000*(1<<32)), be16(0x55c4), be16(0)),
+			MediaHeaderBox{Version: 1, CreationTime: 1 << 32, ModificationTime: 2 << 32, Timescale: 90000,
+				Duration: 90000 * (1 << 32), Language: "und"}, (1 << 32) * time.Second, false},
+		{"version 0 too short", 0, be32s(1, 2, 48000, 96000), MediaHeaderBox{}, 0, true},
+		{"version 1 too short", 1, cat(be32s(1, 2, 48000, 96000), be16(0x55c4), be16(0)), MediaHeaderBox{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdhd := &MediaHeaderBox{Box: topBox(t, buildFullBox("mdhd", tt.version, 0, tt.payload))}
+			err := mdhd.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.Box = mdhd.Box
+			if *mdhd != tt.want {
+				t.Errorf("got %+v, want %+v", *mdhd, tt.want)
+			}
+			if got := mdhd.MediaDuration(); got != tt.duration {
+				t.Errorf("MediaDuration() = %v, want %v", got, tt.duration)
+			}
+		})
+	}
+}
+
+func TestMediaLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint8
+		packed  uint16
+		want    string
+	}{
+		{"version 0", 0, 0x15c7, "eng"},
+		{"version 1", 1, 0x1a41, "fra"},
+		{"undetermined", 1, 0x55c4, "und"},
+		{"zero", 0, 0, "und"},
+		{"out of range", 0, 0x7fff, "und"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times := be32s(0, 0, 1000, 0)
+			if tt.version == 1 {
+				times = cat(be64(0), be64(0), be32(1000), be64(0))
+			}
+			data := buildFullBox("mdhd", tt.version, 0, cat(times, be16(tt.packed), be16(0)))
+			mdhd := &MediaHeaderBox{Box: topBox(t, data)}
+			if err := mdhd.parse(); err != nil {
+				t.Fatal(err)
+			}
+			if mdhd.Language != tt.want {
+				t.Errorf("language %q, want %q", mdhd.Language, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoxParserWalkErrors(t *testing.T) {
+	RegisterBoxParser("Xtra", func(b *Box) (interface{}, error) {
+		return b.ReadBoxData(), nil
+	})
+	t.Cleanup(func() { RegisterBoxParser("Xtra", nil) })
+
+	// Parse does not read the children of mfra, only the walk of the
+	// registered parsers reaches its truncated child.
+	mfra := buildBox("mfra", cat(be32(64), []byte("tfra")))
+	data := cat(buildFile(testTrack{id: 1, samples: [][]byte{{0}}}), mfra)
+	err := parseInvalid(t, data)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != int64(len(data)-len(mfra))+BoxHeaderSize {
+		t.Errorf("strict parse: %v, want the error of the mfra child", err)
+	}
+
+	_, err = NewReader(bytes.NewReader(data), int64(len(data)), WithParseOptions(ParseOptions{BestEffort: true}))
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("best-effort parse: %v, want the error of the mfra child", err)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+)
+
+// UserDataBox - This box contains objects that declare user information about the containing box and its data
+// Box Type: ‘udta’
+// Container: Movie Box (‘moov’) or Track Box (‘trak’)
+// Mandatory: No
+// Quantity: Zero or one
+type UserDataBox struct {
+	*Box
+	Meta *MetaBox
+	Chpl *ChapterListBox
+}
+
+func (b *UserDataBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "meta":
+			b.Meta = &MetaBox{Box: box}
+			if err := parseBox(b.Meta); err != nil {
+				return b.wrapError(err)
+			}
+		case "chpl":
+			b.Chpl = &ChapterListBox{Box: box}
+			if err := parseBox(b.Chpl); err != nil {
+				return b.wrapError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// MetaBox - A common base structure is used to contain general metadata
+// Box Type: ‘meta’
+// Container: File, Movie Box (‘moov’), Track Box (‘trak’) or User Data Box (‘udta’)
+// Mandatory: No
+// Quantity: Zero or one
+type MetaBox struct {
+	*Box
+	Version uint8
+	Flags   [3]byte
+	Hdlr    *HandlerBox
+	Ilst    *ItemListBox
+	Pitm    *PrimaryItemBox
+	Iloc    *ItemLocationBox
+	Iinf    *ItemInfoBox
+	Idat    *ItemDataBox
+}
+
+// metaHeaderSize returns the number of payload bytes preceding the children of
+// a meta box. meta is a full box in ISO files, but QuickTime writes it as a
+// plain container, which shows as a child box header where version and flags
+// would be.
+func metaHeaderSize(b *Box) int64 {
+	if b.PayloadSize() < 8 {
+		return 0
+	}
+	buf := b.Reader.ReadBytesAt(8, b.PayloadOffset())
+	if len(buf) == 8 && string(buf[4:8]) == "hdlr" {
+		return 0
+	}
+	return 4
+}
+
+func (b *MetaBox) parse() error {
+	skip := metaHeaderSize(b.Box)
+	if skip > 0 {
+		data := b.Reader.ReadBytesAt(skip, b.PayloadOffset())
+		if len(data) < 4 {
+			return b.errorf("box is too short")
+		}
+		b.Version = data[0]
+		for i := 0; i < 3; i++ {
+			b.Flags[i] = data[i+1]
+		}
+	}
+
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset()+skip, b.PayloadSize()-skip)
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "hdlr":
+			b.Hdlr = &HandlerBox{Box: box}
+			parseBox(b.Hdlr)
+		case "ilst":
+			b.Ilst = &ItemListBox{Box: box}
+			if err := parseBox(b.Ilst); err != nil {
+				return b.wrapError(err)
+			}
+		case "pitm":
+			b.Pitm = &PrimaryItemBox{Box: box}
+			if err := parseBox(b.Pitm); err != nil {
+				return b.wrapError(err)
+			}
+		case "iloc":
+			b.Iloc = &ItemLocationBox{Box: box}
+			if err := parseBox(b.Iloc); err != nil {
+				return b.wrapError(err)
+			}
+		case "iinf":
+			b.Iinf = &ItemInfoBox{Box: box}
+			if err := parseBox(b.Iinf); err != nil {
+				return b.wrapError(err)
+			}
+		case "idat":
+			b.Idat = &ItemDataBox{Box: box}
+		}
+	}
+	return nil
+}
+
+// ItemListBox - The iTunes metadata item list, one child box per tag, e.g. ‘©nam’
+// Box Type: ‘ilst’
+// Container: Meta Box (‘meta’)
+// Mandatory: No
+// Quantity: Zero or one
+type ItemListBox struct {
+	*Box
+	Tags map[string]string // Decoded tag values keyed by item name, e.g. "©nam".
+}
+
+// Well-known types of the iTunes data atom.
+const (
+	ilstTypeImplicit = 0
+	ilstTypeUTF8     = 1
+	ilstTypeUTF16    = 2
+	ilstTypeInteger  = 21
+)
+
+func (b *ItemListBox) parse() error {
+	items, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	b.Tags = make(map[string]string)
+	for _, item := range items {
+		children, err := readBoxes(b.Reader, item.PayloadOffset(), item.PayloadSize())
+		if err != nil {
+			return b.wrapError(err)
+		}
+
+		key := itemKey(item.Name)
+		for _, child := range children {
+			data := child.ReadBoxData()
+			switch child.Name {
+			case "name":
+				// Freeform ‘----’ items carry their key in a name box.
+				if len(data) >= 4 {
+					key = string(data[4:])
+				}
+			case "data":
+				if value, ok := itemValue(item.Name, data); ok {
+					b.Tags[key] = value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// itemKey converts an item four-char code to UTF-8. The leading byte of tags
+// such as ‘©nam’ is 0xa9 in ISO-8859-1.
+func itemKey(name string) string {
+	runes := make([]rune, len(name))
+	for i := 0; i < len(name); i++ {
+		runes[i] = rune(name[i])
+	}
+	return string(runes)
+}
+
+// itemValue decodes the payload of a data atom. Binary values are only decoded
+// for the track and disc number items.
+func itemValue(name string, data []byte) (string, bool) {
+	if len(data) < 8 {
+		return "", false
+	}
+	// type indicator [0:4], locale [4:8]
+	typ := binary.BigEndian.Uint32(data[0:4]) & 0x00ffffff
+	value := data[8:]
+
+	switch typ {
+	case ilstTypeUTF8:
+		return string(value), true
+	case ilstTypeUTF16:
+		units := make([]uint16, 0, len(value)/2)
+		for i := 0; i+2 <= len(value); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(value[i:i+2]))
+		}
+		return string(utf16.Decode(units)), true
+	case ilstTypeInteger:
+		var n int64
+		for _, c := range value {
+			n = n<<8 | int64(c)
+		}
+		if len(value) > 0 && len(value) < 8 && value[0]&0x80 != 0 {
+			n -= 1 << (8 * uint(len(value)))
+		}
+		return strconv.FormatInt(n, 10), true
+	case ilstTypeImplicit:
+		if (name == "trkn" || name == "disk") && len(value) >= 6 {
+			number := binary.BigEndian.Uint16(value[2:4])
+			total := binary.BigEndian.Uint16(value[4:6])
+			if total == 0 {
+				return strconv.Itoa(int(number)), true
+			}
+			return fmt.Sprintf("%d/%d", number, total), true
+		}
+	}
+	return "", false
+}
+
+// Tags returns the iTunes-style metadata tags of the movie, e.g. "©nam" for
+// the title and "©ART" for the artist, or nil if the file has none.
+func (m *Mp4Reader) Tags() map[string]string {
+	if m.Moov == nil {
+		return nil
+	}
+	for _, meta := range []*MetaBox{m.Moov.Meta, udtaMeta(m.Moov.Udta)} {
+		if meta != nil && meta.Ilst != nil {
+			return meta.Ilst.Tags
+		}
+	}
+	return nil
+}
+
+func udtaMeta(udta *UserDataBox) *MetaBox {
+	if udta == nil {
+		return nil
+	}
+	return udta.Meta
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestItemValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		typ   uint32
+		value []byte
+		want  string
+	}{
+		{"©nam", ilstTypeUTF8, []byte("Title"), "Title"},
+		{"©nam", ilstTypeUTF16, []byte{0, 'T', 0x00, 0xe9}, "Té"},
+		{"©nam", ilstTypeUTF16, []byte{0xd8, 0x3d, 0xde, 0x00, 0, '!'}, "\U0001f600!"},
+		{"tmpo", ilstTypeInteger, []byte{0, 120}, "120"},
+		{"rtng", ilstTypeInteger, []byte{0xff}, "-1"},
+		{"trkn", ilstTypeImplicit, []byte{0, 0, 0, 3, 0, 12, 0, 0}, "3/12"},
+		{"disk", ilstTypeImplicit, []byte{0, 0, 0, 1, 0, 0}, "1"},
+	}
+	for _, tt := range tests {
+		got, ok := itemValue(tt.name, cat(be32(tt.typ), be32(0), tt.value))
+		if !ok || got != tt.want {
+			t.Errorf("%s value %x = %q, %v; want %q", tt.name, tt.value, got, ok, tt.want)
+		}
+	}
+}
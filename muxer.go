@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// H.264 NAL unit types handled by the muxer.
+const (
+	nalSlice   = 1
+	nalIDR     = 5
+	nalSEI     = 6
+	nalSPS     = 7
+	nalPPS     = 8
+	nalAUD     = 9
+	nalTypeMax = 0x1f
+)
+
+// Muxer builds a playable MP4 file from a raw H.264 stream: a single video track
+// with an ‘avc1’ sample entry whose samples are stored in one chunk of a single
+// mdat box, placed after the moov box.
+//
+// Limitations: the frame rate is constant, every frame lasting FrameDuration;
+// frames are written in the order they are added and no composition offsets
+// are recorded, so streams with B-frames play with wrong timing; the coded
+// size is not read from the SPS and has to be set; the whole media data is kept
+// in memory until WriteTo.
+type Muxer struct {
+	Timescale     uint32 // Time units per second, e.g. 30000.
+	FrameDuration uint32 // Duration of every frame in Timescale units, e.g. 1001.
+	Width         uint16 // Coded width in pixels.
+	Height        uint16
+	SPS           [][]byte // Taken from the stream when not set.
+	PPS           [][]byte
+
+	mdat  bytes.Buffer
+	sizes []uint32
+	sync  []uint32 // 1-based numbers of the IDR frames
+}
+
+// AddFrame appends a frame, the NAL units of one access unit without start
+// codes. Parameter sets are moved to the avcC box, the first ones being kept
+// unless SPS and PPS are already set. Frames with an IDR slice are sync samples.
+func (m *Muxer) AddFrame(nals [][]byte) error {
+	size := 0
+	idr := false
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & nalTypeMax {
+		case nalSPS:
+			if len(m.SPS) == 0 || len(m.sizes) == 0 && !containsNAL(m.SPS, nal) {
+				m.SPS = append(m.SPS, nal)
+			}
+			continue
+		case nalPPS:
+			if len(m.PPS) == 0 || len(m.sizes) == 0 && !containsNAL(m.PPS, nal) {
+				m.PPS = append(m.PPS, nal)
+			}
+			continue
+		case nalIDR:
+			idr = true
+		}
+		if int64(size)+4+int64(len(nal)) > math.MaxUint32 {
+			return fmt.Errorf("frame %d is too large", len(m.sizes)+1)
+		}
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], uint32(len(nal)))
+		m.mdat.Write(prefix[:])
+		m.mdat.Write(nal)
+		size += 4 + len(nal)
+	}
+	if size == 0 {
+		return fmt.Errorf("frame %d has no NAL units besides parameter sets", len(m.sizes)+1)
+	}
+	m.sizes = append(m.sizes, uint32(size))
+	if idr {
+		m.sync = append(m.sync, uint32(len(m.sizes)))
+	}
+	return nil
+}
+
+func containsNAL(nals [][]byte, nal []byte) bool {
+	for _, n := range nals {
+		if bytes.Equal(n, nal) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAnnexB appends the frames of an Annex-B byte stream, like the output of
+// extract. Access units are delimited by AUD NAL units, by parameter sets or SEI
+// following a slice, and by slices starting a new picture (first_mb_in_slice 0).
+func (m *Muxer) WriteAnnexB(stream []byte) error {
+	var frame [][]byte
+	hasSlice := false
+	for _, nal := range splitAnnexB(stream) {
+		typ := nal[0] & nalTypeMax
+		isSlice := typ >= nalSlice && typ <= nalIDR
+		starts := typ == nalAUD ||
+			hasSlice && (typ == nalSEI || typ == nalSPS || typ == nalPPS) ||
+			hasSlice && isSlice && len(nal) > 1 && nal[1]&0x80 != 0
+		if starts && len(frame) > 0 {
+			if err := m.AddFrame(frame); err != nil {
+				return err
+			}
+			frame, hasSlice = nil, false
+		}
+		frame = append(frame, nal)
+		hasSlice = hasSlice || isSlice
+	}
+	if len(frame) > 0 {
+		return m.AddFrame(frame)
+	}
+	return nil
+}
+
+// splitAnnexB splits an Annex-B byte stream into its NAL units, dropping the
+// start codes and trailing zero bytes.
+func splitAnnexB(stream []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(stream); i++ {
+		if stream[i] != 0 || stream[i+1] != 0 || stream[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			nals = appendNAL(nals, stream[start:i])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 {
+		nals = appendNAL(nals, stream[start:])
+	}
+	return nals
+}
+
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	nal = bytes.TrimRight(nal, "\x00")
+	if len(nal) == 0 {
+		return nals
+	}
+	return append(nals, nal)
+}
+
+// FrameCount returns the number of frames added so far.
+func (m *Muxer) FrameCount() int {
+	return len(m.sizes)
+}
+
+// WriteTo writes the ftyp, moov and mdat boxes of the file to w.
+func (m *Muxer) WriteTo(w io.Writer) (int64, error) {
+	switch {
+	case len(m.sizes) == 0:
+		return 0, fmt.Errorf("no frames to write")
+	case m.Timescale == 0 || m.FrameDuration == 0:
+		return 0, fmt.Errorf("timescale and frame duration must be set")
+	case m.Width == 0 || m.Height == 0:
+		return 0, fmt.Errorf("width and height must be set")
+	case len(m.SPS) == 0 || len(m.PPS) == 0:
+		return 0, fmt.Errorf("no SPS or PPS found")
+	case len(m.SPS[0]) < 4:
+		return 0, fmt.Errorf("SPS of %d bytes is too short", len(m.SPS[0]))
+	case len(m.SPS) > 0x1f || len(m.PPS) > 0xff:
+		return 0, fmt.Errorf("too many parameter sets")
+	}
+	duration := uint64(len(m.sizes)) * uint64(m.FrameDuration)
+	if duration > math.MaxUint32 {
+		return 0, fmt.Errorf("duration of %d units overflows the version 0 headers", duration)
+	}
+
+	ftyp := muxBox("ftyp", []byte(BrandIsom), u32(0x200), []byte(BrandIsom+BrandIso2+BrandAvc1+BrandMp41))
+
+	// The chunk offset depends on the size of moov, which does not depend on
+	// the offset itself: build moov once to learn its size.
+	mdatHeader := muxBox("mdat")
+	if int64(m.mdat.Len()) > math.MaxUint32-BoxHeaderSize {
+		mdatHeader = make([]byte, 16)
+		binary.BigEndian.PutUint32(mdatHeader[0:4], 1)
+		copy(mdatHeader[4:8], "mdat")
+		binary.BigEndian.PutUint64(mdatHeader[8:16], uint64(16+m.mdat.Len()))
+	} else {
+		binary.BigEndian.PutUint32(mdatHeader[0:4], uint32(BoxHeaderSize+int64(m.mdat.Len())))
+	}
+	moov := m.moov(uint32(duration), 0)
+	offset := uint64(len(ftyp) + len(moov) + len(mdatHeader))
+	moov = m.moov(uint32(duration), offset)
+
+	var n int64
+	for _, p := range [][]byte{ftyp, moov, mdatHeader, m.mdat.Bytes()} {
+		written, err := w.Write(p)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// moov builds the movie box, the samples being stored in one chunk at offset.
+func (m *Muxer) moov(duration uint32, offset uint64) []byte {
+	matrix := make([]byte, 36)
+	for i, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		binary.BigEndian.PutUint32(matrix[4*i:], v)
+	}
+
+	mvhd := muxFullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(m.Timescale), u32(duration),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix, make([]byte, 24),
+		u32(2)) // next_track_ID
+	tkhd := muxFullBox("tkhd", 0, 0x000003, // track enabled and in movie
+		u32(0), u32(0), u32(1), u32(0), u32(duration),
+		make([]byte, 8), u16(0), u16(0), u16(0), u16(0), matrix,
+		u32(uint32(m.Width)<<16), u32(uint32(m.Height)<<16))
+	mdhd := muxFullBox("mdhd", 0, 0,
+		u32(0), u32(0), u32(m.Timescale), u32(duration), u16(0x55c4), u16(0)) // ‘und’
+	hdlr := muxFullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+	vmhd := muxFullBox("vmhd", 0, 0x000001, make([]byte, 8))
+	dinf := muxBox("dinf", muxFullBox("dref", 0, 0, u32(1), muxFullBox("url ", 0, DataEntrySelfContained)))
+
+	avcc := []byte{1, m.SPS[0][1], m.SPS[0][2], m.SPS[0][3], 0xfc | 3, 0xe0 | byte(len(m.SPS))}
+	for _, sps := range m.SPS {
+		avcc = append(append(avcc, u16(uint16(len(sps)))...), sps...)
+	}
+	avcc = append(avcc, byte(len(m.PPS)))
+	for _, pps := range m.PPS {
+		avcc = append(append(avcc, u16(uint16(len(pps)))...), pps...)
+	}
+	avc1 := muxBox("avc1",
+		make([]byte, 6), u16(1), make([]byte, 16), u16(m.Width), u16(m.Height),
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1), make([]byte, 32),
+		u16(0x0018), u16(0xffff), muxBox("avcC", avcc))
+
+	count := uint32(len(m.sizes))
+	sizes := make([]byte, 4*len(m.sizes))
+	for i, size := range m.sizes {
+		binary.BigEndian.PutUint32(sizes[4*i:], size)
+	}
+	chunkOffset := muxFullBox("stco", 0, 0, u32(1), u32(uint32(offset)))
+	if offset > math.MaxUint32 {
+		chunkOffset = muxFullBox("co64", 0, 0, u32(1), u64(offset))
+	}
+	stbl := [][]byte{
+		muxFullBox("stsd", 0, 0, u32(1), avc1),
+		muxFullBox("stts", 0, 0, u32(1), u32(count), u32(m.FrameDuration)),
+	}
+	if len(m.sync) < len(m.sizes) {
+		stss := make([]byte, 4*len(m.sync))
+		for i, n := range m.sync {
+			binary.BigEndian.PutUint32(stss[4*i:], n)
+		}
+		stbl = append(stbl, muxFullBox("stss", 0, 0, u32(uint32(len(m.sync))), stss))
+	}
+	stbl = append(stbl,
+		muxFullBox("stsc", 0, 0, u32(1), u32(1), u32(count), u32(1)),
+		muxFullBox("stsz", 0, 0, u32(0), u32(count), sizes),
+		chunkOffset)
+
+	minf := muxBox("minf", vmhd, dinf, muxBox("stbl", stbl...))
+	trak := muxBox("trak", tkhd, muxBox("mdia", mdhd, hdlr, minf))
+	return muxBox("moov", mvhd, trak)
+}
+
+// muxBox serializes a box from the parts of its payload.
+func muxBox(name string, parts ...[]byte) []byte {
+	size := BoxHeaderSize
+	for _, p := range parts {
+		size += int64(len(p))
+	}
+	buf := make([]byte, BoxHeaderSize, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	copy(buf[4:8], name)
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// muxFullBox serializes a full box, its payload starting with version and flags.
+func muxFullBox(name string, version uint8, flags uint32, parts ...[]byte) []byte {
+	header := u32(uint32(version)<<24 | flags&0xffffff)
+	return muxBox(name, append([][]byte{header}, parts...)...)
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// boxHeader is a box header read by scanBoxHeaders. HeaderSize is 16 for a
+// box with a 64-bit largesize.
+type boxHeader struct {
+	Name                    string
+	Start, Size, HeaderSize int64
+}
+
+// scanBoxHeaders reads the headers of consecutive boxes in [start, start+n)
+// without reading their payloads. As in readBoxHeaders, a size of 0 extends
+// the box to the end of the file and is only accepted at the top level.
+func scanBoxHeaders(r io.ReaderAt, start int64, n int64) (l []boxHeader, err error) {
+	buf := make([]byte, BoxHeaderSize+8)
+	for offset := start; offset+BoxHeaderSize <= start+n; {
+		if _, err := r.ReadAt(buf[:BoxHeaderSize], offset); err != nil {
+			return l, err
+		}
+		h := boxHeader{Name: string(buf[4:8]), Start: offset, HeaderSize: BoxHeaderSize}
+		h.Size = int64(binary.BigEndian.Uint32(buf[0:4]))
+		switch {
+		case h.Size == 0 && start == 0:
+			h.Size = start + n - offset
+		case h.Size == 1:
+			if offset+16 > start+n {
+				return l, fmt.Errorf("truncated 64-bit box size at offset %d", offset)
+			}
+			if _, err := r.ReadAt(buf[BoxHeaderSize:], offset+BoxHeaderSize); err != nil {
+				return l, err
+			}
+			largesize := binary.BigEndian.Uint64(buf[8:16])
+			if largesize > math.MaxInt64 {
+				return l, fmt.Errorf("invalid box size %d at offset %d", largesize, offset)
+			}
+			h.Size, h.HeaderSize = int64(largesize), 16
+		}
+		if h.Size < h.HeaderSize {
+			return l, fmt.Errorf("invalid box size %d at offset %d", h.Size, offset)
+		}
+
+		l = append(l, h)
+		offset += h.Size
+	}
+	return l, nil
+}
+
+// IsFragmentedFile reports whether the input is a fragmented mp4. Only box
+// headers are read: the top level is scanned for moof or styp and the moov box
+// for mvex, so the sample tables are never parsed.
+func IsFragmentedFile(r io.ReaderAt, size int64) (bool, error) {
+	boxes, err := scanBoxHeaders(r, 0, size)
+	if err != nil {
+		return false, err
+	}
+
+	for _, box := range boxes {
+		switch box.Name {
+		case "moof", "styp":
+			return true, nil
+
+		case "moov":
+			children, err := scanBoxHeaders(r, box.Start+box.HeaderSize, box.Size-box.HeaderSize)
+			if err != nil {
+				return false, err
+			}
+			for _, child := range children {
+				if child.Name == "mvex" {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// TrackInfo is a summary of a single track. The JSON encoding uses the field
+// names of the tags and carries the duration both in seconds and in ticks.
+type TrackInfo struct {
+	TrackID       uint32        `json:"track_id"`
+	Type          string        `json:"type"`  // Handler type: vide, soun, ...
+	Codec         string        `json:"codec"` // Coding type of the first sample entry: avc1, mp4a, ...
+	Width         uint16        `json:"width,omitempty"`
+	Height        uint16        `json:"height,omitempty"`
+	Timescale     uint32        `json:"timescale"`
+	Duration      time.Duration `json:"-"`
+	DurationTicks uint64        `json:"duration_ticks"` // Duration in Timescale units.
+	SampleCount   uint32        `json:"sample_count"`
+	Bitrate       uint64        `json:"bitrate"` // Average bitrate in bits per second.
+}
+
+// MarshalJSON encodes the track summary with its duration in seconds.
+func (t TrackInfo) MarshalJSON() ([]byte, error) {
+	type trackInfo TrackInfo
+	return json.Marshal(struct {
+		trackInfo
+		Duration float64 `json:"duration"`
+	}{trackInfo(t), t.Duration.Seconds()})
+}
+
+// Info is a lightweight summary of an mp4 file, encoded to JSON like TrackInfo.
+type Info struct {
+	Duration         time.Duration `json:"-"`
+	Timescale        uint32        `json:"timescale"`      // Movie timescale, 0 without mvhd.
+	DurationTicks    uint64        `json:"duration_ticks"` // Duration in Timescale units.
+	MajorBrand       string        `json:"major_brand"`
+	CompatibleBrands []string      `json:"compatible_brands"`
+	Tracks           []TrackInfo   `json:"tracks"`
+}
+
+// MarshalJSON encodes the file summary with its duration in seconds.
+func (i *Info) MarshalJSON() ([]byte, error) {
+	type info Info
+	return json.Marshal(struct {
+		*info
+		Duration float64 `json:"duration"`
+	}{(*info)(i), i.Duration.Seconds()})
+}
+
+// ProbeFormat parses the metadata of an mp4 file and returns its summary. The
+// mdat payload is never read, so probing is fast even for huge files. Media
+// segment files without moov are accepted when they carry movie fragments; the
+// brands then come from styp and the summary has no tracks.
+func ProbeFormat(r io.ReaderAt, size int64) (*Info, error) {
+	m := &Mp4Reader{Reader: r, Size: size, Options: ParseOptions{SkipMdat: true}}
+	if err := m.Parse(); err != nil {
+		return nil, err
+	}
+	if m.Moov == nil && len(m.Moofs) == 0 {
+		return nil, fmt.Errorf("no moov box found")
+	}
+
+	info := &Info{Duration: m.Duration()}
+	if m.Moov != nil && m.Moov.Mvhd != nil {
+		info.Timescale = m.Moov.Mvhd.Timescale
+		info.DurationTicks = m.Moov.Mvhd.Duration
+	}
+	brands := m.Ftyp
+	if brands == nil {
+		brands = m.Styp
+	}
+	if brands != nil {
+		info.MajorBrand = brands.MajorBrand
+		info.CompatibleBrands = brands.CompatibleBrands
+	}
+	if m.Moov != nil {
+		for _, trak := range m.Moov.Traks {
+			info.Tracks = append(info.Tracks, probeTrack(trak))
+		}
+	}
+	return info, nil
+}
+
+func probeTrack(trak *TrackBox) TrackInfo {
+	t := TrackInfo{Type: trak.HandlerType()}
+	if trak.Tkhd != nil {
+		t.TrackID = trak.Tkhd.TrackID
+		t.Width = uint16(trak.Tkhd.Width >> 16)
+		t.Height = uint16(trak.Tkhd.Height >> 16)
+	}
+	if trak.Mdia != nil && trak.Mdia.Mdhd != nil {
+		t.Timescale = trak.Mdia.Mdhd.Timescale
+		t.Duration = trak.Mdia.Mdhd.MediaDuration()
+		t.DurationTicks = trak.Mdia.Mdhd.Duration
+	}
+	if stbl, err := trak.sampleTable(); err == nil {
+		if stbl.Stsd != nil {
+			t.Codec = stbl.Stsd.Codec()
+		}
+		t.SampleCount = stbl.SampleCount()
+	}
+	t.Bitrate, _ = trak.Bitrate()
+	return t
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// buildLargeBox serializes a box with a 64-bit largesize.
+func buildLargeBox(name string, payload []byte) []byte {
+	return cat(be32(1), []byte(name), be64(uint64(16+len(payload))), payload)
+}
+
+func TestIsFragmentedFile(t *testing.T) {
+	ftyp := buildBox("ftyp", cat([]byte("isom"), be32(0x200), []byte("isom")))
+	mvhd := buildMvhd(1000, 0, 2)
+	mvex := buildContainer("mvex", buildFullBox("trex", 0, 0, be32s(1, 1, 0, 0, 0)))
+	moof := buildContainer("moof", buildFullBox("mfhd", 0, 0, be32(1)))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		fragmented bool
+		wantErr    bool
+	}{
+		{"progressive", buildFile(testTrack{id: 1, samples: [][]byte{{0}}}), false, false},
+		{"moov with mvex", cat(ftyp, buildContainer("moov", mvhd, mvex)), true, false},
+		{"moof after 64-bit mdat", cat(ftyp, buildLargeBox("mdat", make([]byte, 32)), moof), true, false},
+		{"64-bit moov with mvex", cat(ftyp, buildLargeBox("moov", cat(mvhd, mvex))), true, false},
+		{"64-bit moov without mvex", cat(ftyp, buildLargeBox("moov", mvhd)), false, false},
+		{"last box to the end of file", cat(ftyp, buildContainer("moov", mvhd), be32(0), []byte("mdat"), make([]byte, 16)), false, false},
+		{"size 0 inside moov", cat(ftyp, buildContainer("moov", mvhd, be32(0), []byte("mvex"))), false, true},
+		{"truncated largesize", cat(ftyp, be32(1), []byte("mdat"), be32(0)), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fragmented, err := IsFragmentedFile(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if fragmented != tt.fragmented {
+				t.Errorf("fragmented %v, want %v", fragmented, tt.fragmented)
+			}
+		})
+	}
+}
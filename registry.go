@@ -0,0 +1,123 @@
+package main
+
+import "sync"
+
+// BoxParserFunc decodes the payload of a box. Its result is stored in Box.Value.
+type BoxParserFunc func(box *Box) (interface{}, error)
+
+var (
+	boxParsersMu sync.RWMutex
+	boxParsers   = make(map[string]BoxParserFunc)
+)
+
+// RegisterBoxParser registers fn as the parser of the boxes named name. Parse
+// passes every box of the file with that four-char code to fn once, with the
+// result stored in Box.Value and any error in Box.ParseErr; boxes read later by
+// Walk, Children or MarshalJSON carry the same results. This lets callers decode
+// vendor or proprietary boxes the package does not model, for example the
+// Windows Media tags found in udta:
+//
+//	RegisterBoxParser("Xtra", func(b *Box) (interface{}, error) {
+//		return b.ReadBoxData(), nil
+//	})
+//
+// Codes the package parses itself, such as udta or stsd, are never passed to
+// a registered parser. Registering a nil fn removes the parser for name.
+func RegisterBoxParser(name string, fn BoxParserFunc) {
+	boxParsersMu.Lock()
+	defer boxParsersMu.Unlock()
+	if fn == nil {
+		delete(boxParsers, name)
+		return
+	}
+	boxParsers[name] = fn
+}
+
+// builtinBoxes lists the four-char codes with a built-in parser.
+var builtinBoxes = map[string]bool{
+	"ftyp": true, "styp": true, "moov": true, "mdat": true, "moof": true, "sidx": true,
+	"prft": true, "emsg": true, "meta": true, "free": true, "skip": true, "wide": true,
+	"mvhd": true, "trak": true, "udta": true, "mvex": true, "pssh": true, "mehd": true,
+	"trex": true, "tkhd": true, "tref": true, "edts": true, "elst": true, "mdia": true,
+	"mdhd": true, "hdlr": true, "minf": true, "vmhd": true, "smhd": true, "hmhd": true,
+	"nmhd": true, "dinf": true, "dref": true, "url ": true, "urn ": true, "stbl": true,
+	"stsd": true, "stsz": true, "stz2": true, "stsc": true, "stco": true, "co64": true,
+	"stts": true, "ctts": true, "stss": true, "padb": true, "sdtp": true, "cslg": true,
+	"stdp": true, "sbgp": true, "sgpd": true, "subs": true, "saiz": true, "saio": true,
+	"avc1": true, "avc3": true, "hvc1": true, "hev1": true, "encv": true, "mp4v": true,
+	"av01": true, "vp08": true, "vp09": true, "mp4a": true, "enca": true, "avcC": true,
+	"hvcC": true, "btrt": true, "colr": true, "pasp": true, "clap": true, "esds": true,
+	"wave": true, "sinf": true, "frma": true, "schm": true, "schi": true, "tenc": true,
+	"mfhd": true, "traf": true, "tfhd": true, "tfdt": true, "trun": true, "senc": true,
+	"ilst": true, "data": true, "name": true, "chpl": true, "pitm": true, "iloc": true,
+	"iinf": true, "idat": true,
+}
+
+// registeredBoxParser returns the parser registered for the boxes named name,
+// or nil if there is none or the package parses them itself.
+func registeredBoxParser(name string) BoxParserFunc {
+	if builtinBoxes[name] {
+		return nil
+	}
+	boxParsersMu.RLock()
+	defer boxParsersMu.RUnlock()
+	return boxParsers[name]
+}
+
+// boxValue is the outcome of a registered parser for one box.
+type boxValue struct {
+	value interface{}
+	err   error
+}
+
+// runBoxParsers runs the registered parsers on the boxes of the file visited by
+// Walk, once per box, and keeps their results by box offset for the boxes read
+// afterwards. Boxes following a malformed one are not visited.
+func (m *Mp4Reader) runBoxParsers() error {
+	m.values = nil
+	boxParsersMu.RLock()
+	n := len(boxParsers)
+	boxParsersMu.RUnlock()
+	if n == 0 {
+		return nil
+	}
+	values := make(map[int64]boxValue)
+	err := m.Walk(func(box *Box, depth int) error {
+		if fn := registeredBoxParser(box.Name); fn != nil {
+			box.Value, box.ParseErr = fn(box)
+			values[box.Start] = boxValue{box.Value, box.ParseErr}
+		}
+		return nil
+	})
+	m.values = values
+	return err
+}
+
+var (
+	containersMu         sync.RWMutex
+	registeredContainers = make(map[string]int64)
+)
+
+// RegisterContainerBox marks the boxes named name as containers, so that Walk,
+// Children, Validate and the writer descend into them. headerSize is the number
+// of payload bytes preceding the first child, e.g. 4 for the version and flags
+// of a full box. The built-in containers cannot be overridden. Registering a
+// negative headerSize removes the registration.
+func RegisterContainerBox(name string, headerSize int64) {
+	containersMu.Lock()
+	defer containersMu.Unlock()
+	if headerSize < 0 {
+		delete(registeredContainers, name)
+		return
+	}
+	registeredContainers[name] = headerSize
+}
+
+// registeredContainer returns the header size of a container registered with
+// RegisterContainerBox.
+func registeredContainer(name string) (skip int64, ok bool) {
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+	skip, ok = registeredContainers[name]
+	return skip, ok
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// SampleDescriptionBox - This box gives detailed information about the coding type used, and any initialization information needed for that coding
+// Box Type: ‘stsd’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type SampleDescriptionBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []*Box             // Sample entries, named by their coding type (avc1, mp4a, ...).
+	Audio      *AudioSampleEntry  // The first audio sample entry.
+	Visual     *VisualSampleEntry // The first video sample entry.
+}
+
+func (b *SampleDescriptionBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	entries, err := readBoxes(b.Reader, b.PayloadOffset()+8, b.PayloadSize()-8)
+	b.Entries = entries
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	for _, entry := range b.Entries {
+		switch entry.Name {
+		case "avc1", "avc3", "hvc1", "hev1", "encv", "mp4v", "av01", "vp08", "vp09":
+			if b.Visual == nil {
+				b.Visual = &VisualSampleEntry{Box: entry}
+				parseBox(b.Visual)
+			}
+		case "mp4a", "enca":
+			if b.Audio == nil {
+				b.Audio = &AudioSampleEntry{Box: entry}
+				parseBox(b.Audio)
+			}
+		}
+	}
+	return nil
+}
+
+// Codec returns the coding type of the first sample entry, e.g. "avc1" or "mp4a".
+// For protected entries such as encv it is the original format from their frma
+// box.
+func (b *SampleDescriptionBox) Codec() string {
+	if len(b.Entries) == 0 {
+		return ""
+	}
+	if sinf, _ := b.protectionScheme(b.Entries[0]); sinf != nil && sinf.Frma != nil {
+		return sinf.Frma.DataFormat
+	}
+	return b.Entries[0].Name
+}
+
+// CompactSampleSizeBox - A compact variant of the sample size table using 4, 8 or 16 bit fields
+// Box Type: ‘stz2’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: Yes
+// Quantity: Exactly one variant must be present
+type CompactSampleSizeBox struct {
+	*Box
+	Version     uint8
+	Flags       [3]byte
+	FieldSize   uint8
+	SampleCount uint32
+	SamplesSize []uint32
+}
+
+func (b *CompactSampleSizeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	// reserved 24 bit [4:7]
+	b.FieldSize = data[7]
+	// As for stsz, the count is only kept once checked.
+	count := binary.BigEndian.Uint32(data[8:12])
+	if b.FieldSize != 4 && b.FieldSize != 8 && b.FieldSize != 16 {
+		return b.errorf("invalid field size %d", b.FieldSize)
+	}
+	if uint64(len(data)-12)*8 < uint64(count)*uint64(b.FieldSize) {
+		return b.errorf("%d samples do not fit in the box", count)
+	}
+	if err := b.checkSampleCount(uint64(count)); err != nil {
+		return err
+	}
+	b.SampleCount = count
+
+	entries := data[12:]
+	b.SamplesSize = make([]uint32, b.SampleCount)
+	for i := range b.SamplesSize {
+		switch b.FieldSize {
+		case 4:
+			// Two samples share a byte, the first one in the high nibble.
+			if i%2 == 0 {
+				b.SamplesSize[i] = uint32(entries[i/2] >> 4)
+			} else {
+				b.SamplesSize[i] = uint32(entries[i/2] & 0x0f)
+			}
+		case 8:
+			b.SamplesSize[i] = uint32(entries[i])
+		case 16:
+			b.SamplesSize[i] = uint32(binary.BigEndian.Uint16(entries[2*i : 2*i+2]))
+		}
+	}
+	return nil
+}
+
+// SizeOf returns the size in bytes of the 1-based sample number, or 0 if there
+// is no such sample.
+func (b *CompactSampleSizeBox) SizeOf(sampleNumber uint32) uint32 {
+	if sampleNumber == 0 || sampleNumber > uint32(len(b.SamplesSize)) {
+		return 0
+	}
+	return b.SamplesSize[sampleNumber-1]
+}
+
+// PaddingBitsBox - This box records the number of padding bits at the end of each sample
+// Box Type: ‘padb’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: No
+// Quantity: Zero or one
+type PaddingBitsBox struct {
+	*Box
+	Version     uint8
+	Flags       [3]byte
+	SampleCount uint32
+	Padding     []uint8 // Padding bits of each sample, in sample order.
+}
+
+func (b *PaddingBitsBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.SampleCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < (uint64(b.SampleCount)+1)/2 {
+		return b.errorf("%d samples do not fit in the box", b.SampleCount)
+	}
+
+	// Each byte packs two samples: reserved(1) pad1(3) reserved(1) pad2(3).
+	if err := b.checkSampleCount(uint64(b.SampleCount)); err != nil {
+		return err
+	}
+	b.Padding = make([]uint8, b.SampleCount)
+	for i := uint32(0); i < b.SampleCount; i++ {
+		packed := data[8+i/2]
+		if i%2 == 0 {
+			b.Padding[i] = (packed >> 4) & 0x07
+		} else {
+			b.Padding[i] = packed & 0x07
+		}
+	}
+	return nil
+}
+
+// TimeToSampleEntry is a run of consecutive samples sharing the same duration.
+type TimeToSampleEntry struct {
+	SampleCount uint32
+	SampleDelta uint32
+}
+
+// TimeToSampleBox - This box contains a compact version of a table that allows indexing from decoding time to sample number
+// Box Type: ‘stts’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type TimeToSampleBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []TimeToSampleEntry
+}
+
+func (b *TimeToSampleBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*8 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	b.Entries = make([]TimeToSampleEntry, b.EntryCount)
+	for i := range b.Entries {
+		entry := data[8+8*i:]
+		b.Entries[i].SampleCount = binary.BigEndian.Uint32(entry[0:4])
+		b.Entries[i].SampleDelta = binary.BigEndian.Uint32(entry[4:8])
+	}
+	// The table is expanded to a time per sample by PresentationOrder,
+	// SampleTimes and Chapters, so the total is bounded like a sample count.
+	// This also keeps SampleCount from wrapping.
+	var total uint64
+	for _, entry := range b.Entries {
+		total += uint64(entry.SampleCount)
+	}
+	if err := b.checkSampleCount(total); err != nil {
+		b.Entries = nil
+		return err
+	}
+	return nil
+}
+
+// SampleCount returns the total number of samples described by the table.
+func (b *TimeToSampleBox) SampleCount() (count uint32) {
+	for _, entry := range b.Entries {
+		count += entry.SampleCount
+	}
+	return count
+}
+
+// Duration returns the sum of all sample durations in the media timescale.
+func (b *TimeToSampleBox) Duration() (ticks uint64) {
+	for _, entry := range b.Entries {
+		ticks += uint64(entry.SampleCount) * uint64(entry.SampleDelta)
+	}
+	return ticks
+}
+
+// CompositionOffsetEntry is a run of consecutive samples sharing the same composition offset.
+type CompositionOffsetEntry struct {
+	SampleCount  uint32
+	SampleOffset int32
+}
+
+// CompositionOffsetBox - This box provides the offset between decoding time and composition time
+// Box Type: ‘ctts’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: No
+// Quantity: Zero or one
+type CompositionOffsetBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []CompositionOffsetEntry
+}
+
+func (b *CompositionOffsetBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*8 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	b.Entries = make([]CompositionOffsetEntry, b.EntryCount)
+	for i := range b.Entries {
+		entry := data[8+8*i:]
+		b.Entries[i].SampleCount = binary.BigEndian.Uint32(entry[0:4])
+		// Version 0 offsets are unsigned in the specification, but encoders
+		// write negative ones into version 0 boxes too, so both versions are
+		// read as signed.
+		b.Entries[i].SampleOffset = int32(binary.BigEndian.Uint32(entry[4:8]))
+	}
+	// Like stts, the table is expanded to an offset per sample.
+	var total uint64
+	for _, entry := range b.Entries {
+		total += uint64(entry.SampleCount)
+	}
+	if err := b.checkSampleCount(total); err != nil {
+		b.Entries = nil
+		return err
+	}
+	return nil
+}
+
+// CompositionOffset returns the composition time offset of the 1-based sample
+// number, or 0 if the sample is not covered by the table.
+func (b *CompositionOffsetBox) CompositionOffset(sampleNumber uint32) int32 {
+	if sampleNumber == 0 {
+		return 0
+	}
+	n := sampleNumber - 1
+	for _, entry := range b.Entries {
+		if n < entry.SampleCount {
+			return entry.SampleOffset
+		}
+		n -= entry.SampleCount
+	}
+	return 0
+}
+
+// SyncSampleBox - This box provides a compact marking of the sync samples within the stream
+// Box Type: ‘stss’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: No
+// Quantity: Zero or one
+type SyncSampleBox struct {
+	*Box
+	Version       uint8
+	Flags         [3]byte
+	EntryCount    uint32
+	SampleNumbers []uint32 // 1-based numbers of the sync samples, in increasing order.
+}
+
+func (b *SyncSampleBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*4 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.SampleNumbers = make([]uint32, b.EntryCount)
+	for i := range b.SampleNumbers {
+		b.SampleNumbers[i] = binary.BigEndian.Uint32(data[8+4*i : 12+4*i])
+	}
+	return nil
+}
+
+// IsSyncSample reports whether the 1-based sample number is listed as a sync sample.
+func (b *SyncSampleBox) IsSyncSample(sampleNumber uint32) bool {
+	i := sort.Search(len(b.SampleNumbers), func(i int) bool { return b.SampleNumbers[i] >= sampleNumber })
+	return i < len(b.SampleNumbers) && b.SampleNumbers[i] == sampleNumber
+}
+
+// BitRateBox - The bit rate information of the stream described by a sample entry
+// Box Type: ‘btrt’
+// Container: Sample Entry
+// Mandatory: No
+// Quantity: Zero or one
+type BitRateBox struct {
+	*Box
+	BufferSizeDB uint32 // Size of the decoding buffer in bytes.
+	MaxBitrate   uint32 // Maximum rate in bits/second over any window of one second.
+	AvgBitrate   uint32
+}
+
+func (b *BitRateBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.BufferSizeDB = binary.BigEndian.Uint32(data[0:4])
+	b.MaxBitrate = binary.BigEndian.Uint32(data[4:8])
+	b.AvgBitrate = binary.BigEndian.Uint32(data[8:12])
+	return nil
+}
+
+// bitRateBox returns the btrt box of the first sample entry, if any.
+func (b *SampleTableBox) bitRateBox() *BitRateBox {
+	switch {
+	case b.Stsd == nil:
+		return nil
+	case b.Stsd.Visual != nil:
+		return b.Stsd.Visual.Btrt
+	case b.Stsd.Audio != nil:
+		return b.Stsd.Audio.Btrt
+	}
+	return nil
+}
+
+// SampleDependency holds the dependency flags of a sample. Each field is a
+// 2-bit value where 0 means unknown.
+type SampleDependency struct {
+	IsLeading     uint8 // 1: leading with dependency, 2: not leading, 3: leading without dependency.
+	DependsOn     uint8 // 1: depends on other samples (not an I picture), 2: does not.
+	IsDependedOn  uint8 // 1: other samples depend on it, 2: disposable.
+	HasRedundancy uint8 // 1: has redundant coding, 2: has none.
+}
+
+// SampleDependencyTypeBox - This box contains the dependency flags of each sample
+// Box Type: ‘sdtp’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or one
+type SampleDependencyTypeBox struct {
+	*Box
+	Version uint8
+	Flags   [3]byte
+	Samples []SampleDependency // One entry per sample, the count is taken from the box size.
+}
+
+func (b *SampleDependencyTypeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if err := b.checkSampleCount(uint64(len(data) - 4)); err != nil {
+		return err
+	}
+	b.Samples = make([]SampleDependency, len(data)-4)
+	for i, c := range data[4:] {
+		b.Samples[i] = SampleDependency{
+			IsLeading:     c >> 6,
+			DependsOn:     c >> 4 & 0x03,
+			IsDependedOn:  c >> 2 & 0x03,
+			HasRedundancy: c & 0x03,
+		}
+	}
+	return nil
+}
+
+// SubSample is a contiguous byte range of a sample, e.g. a NAL unit or the
+// clear and protected parts of an encrypted sample.
+type SubSample struct {
+	Size                    uint32
+	Priority                uint8
+	Discardable             bool
+	CodecSpecificParameters uint32
+}
+
+// SubSampleEntry lists the sub-samples of one sample.
+type SubSampleEntry struct {
+	SampleDelta uint32 // Difference to the sample number of the previous entry.
+	SubSamples  []SubSample
+}
+
+// SubSampleInformationBox - This box contains the sub-sample information of the samples that have sub-samples
+// Box Type: ‘subs’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more
+type SubSampleInformationBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	EntryCount uint32
+	Entries    []SubSampleEntry
+}
+
+func (b *SubSampleInformationBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(b.EntryCount)*6 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	sizeLen := 2
+	if b.Version == 1 {
+		sizeLen = 4
+	}
+	offset := 8
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.Entries = make([]SubSampleEntry, b.EntryCount)
+	for i := range b.Entries {
+		if offset+6 > len(data) {
+			return b.errorf("entry %d does not fit in the box", i+1)
+		}
+		entry := &b.Entries[i]
+		entry.SampleDelta = binary.BigEndian.Uint32(data[offset : offset+4])
+		count := int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+		offset += 6
+		if offset+count*(sizeLen+6) > len(data) {
+			return b.errorf("sub-samples of entry %d do not fit in the box", i+1)
+		}
+		entry.SubSamples = make([]SubSample, count)
+		for j := range entry.SubSamples {
+			sub := &entry.SubSamples[j]
+			if sizeLen == 4 {
+				sub.Size = binary.BigEndian.Uint32(data[offset : offset+4])
+			} else {
+				sub.Size = uint32(binary.BigEndian.Uint16(data[offset : offset+2]))
+			}
+			offset += sizeLen
+			sub.Priority = data[offset]
+			sub.Discardable = data[offset+1] != 0
+			sub.CodecSpecificParameters = binary.BigEndian.Uint32(data[offset+2 : offset+6])
+			offset += 6
+		}
+	}
+	return nil
+}
+
+// SubSamples returns the sub-samples of the 1-based sample number, or nil if
+// the box does not describe the sample.
+func (b *SubSampleInformationBox) SubSamples(sampleNumber uint32) []SubSample {
+	number := uint32(0)
+	for _, entry := range b.Entries {
+		number += entry.SampleDelta
+		if number == sampleNumber {
+			return entry.SubSamples
+		}
+		if number > sampleNumber {
+			break
+		}
+	}
+	return nil
+}
+
+// CompositionToDecodeBox - This box relates the composition and decoding timelines when signed composition offsets are used
+// Box Type: ‘cslg’
+// Container: Sample Table Box (‘stbl’) or Track Extension Properties Box (‘trep’)
+// Mandatory: No
+// Quantity: Zero or one
+type CompositionToDecodeBox struct {
+	*Box
+	Version                      uint8
+	Flags                        [3]byte
+	CompositionToDTSShift        int64 // Added to composition times so that they are never below the decoding times.
+	LeastDecodeToDisplayDelta    int64 // Smallest composition offset of the ctts box.
+	GreatestDecodeToDisplayDelta int64 // Largest composition offset of the ctts box.
+	CompositionStartTime         int64
+	CompositionEndTime           int64
+}
+
+func (b *CompositionToDecodeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+
+	// Version 0 fields are signed 32-bit, version 1 fields signed 64-bit.
+	fieldSize := 4
+	if b.Version == 1 {
+		fieldSize = 8
+	}
+	if len(data) < 4+5*fieldSize {
+		return b.errorf("box is too short")
+	}
+	fields := []*int64{
+		&b.CompositionToDTSShift,
+		&b.LeastDecodeToDisplayDelta,
+		&b.GreatestDecodeToDisplayDelta,
+		&b.CompositionStartTime,
+		&b.CompositionEndTime,
+	}
+	for i, field := range fields {
+		v := data[4+i*fieldSize:]
+		if b.Version == 1 {
+			*field = int64(binary.BigEndian.Uint64(v[0:8]))
+		} else {
+			*field = int64(int32(binary.BigEndian.Uint32(v[0:4])))
+		}
+	}
+	return nil
+}
+
+// DegradationPriorityBox - This box contains the degradation priority of each sample
+// Box Type: ‘stdp’
+// Container: Sample Table Box (‘stbl’)
+// Mandatory: No
+// Quantity: Zero or one
+type DegradationPriorityBox struct {
+	*Box
+	Version    uint8
+	Flags      [3]byte
+	Priorities []uint16 // One entry per sample, the count is taken from the box size.
+}
+
+func (b *DegradationPriorityBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	if err := b.checkSampleCount(uint64((len(data) - 4) / 2)); err != nil {
+		return err
+	}
+	b.Priorities = make([]uint16, (len(data)-4)/2)
+	for i := range b.Priorities {
+		b.Priorities[i] = binary.BigEndian.Uint16(data[4+2*i : 6+2*i])
+	}
+	return nil
+}
+
+// SampleToGroupEntry assigns a run of consecutive samples to a group.
+type SampleToGroupEntry struct {
+	SampleCount           uint32
+	GroupDescriptionIndex uint32 // 1-based index into the sgpd entries, 0 for no group.
+}
+
+// SampleToGroupBox - This box assigns samples to the groups described in a sample group description box
+// Box Type: ‘sbgp’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more
+type SampleToGroupBox struct {
+	*Box
+	Version               uint8
+	Flags                 [3]byte
+	GroupingType          string // e.g. ‘roll’
+	GroupingTypeParameter uint32 // Only present in version 1 boxes.
+	EntryCount            uint32
+	Entries               []SampleToGroupEntry
+}
+
+func (b *SampleToGroupBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.GroupingType = string(data[4:8])
+	offset := 8
+	if b.Version == 1 {
+		if len(data) < 16 {
+			return b.errorf("box is too short")
+		}
+		b.GroupingTypeParameter = binary.BigEndian.Uint32(data[8:12])
+		offset += 4
+	}
+	b.EntryCount = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	if uint64(len(data)-offset) < uint64(b.EntryCount)*8 {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.Entries = make([]SampleToGroupEntry, b.EntryCount)
+	for i := range b.Entries {
+		entry := data[offset+8*i:]
+		b.Entries[i].SampleCount = binary.BigEndian.Uint32(entry[0:4])
+		b.Entries[i].GroupDescriptionIndex = binary.BigEndian.Uint32(entry[4:8])
+	}
+	return nil
+}
+
+// GroupDescriptionIndex returns the group description index of the 1-based
+// sample number, or 0 if the sample belongs to no group of this type.
+func (b *SampleToGroupBox) GroupDescriptionIndex(sampleNumber uint32) uint32 {
+	if sampleNumber == 0 {
+		return 0
+	}
+	n := sampleNumber - 1
+	for _, entry := range b.Entries {
+		if n < entry.SampleCount {
+			return entry.GroupDescriptionIndex
+		}
+		n -= entry.SampleCount
+	}
+	return 0
+}
+
+// SampleGroupDescriptionBox - This box gives information about the characteristics of sample groups
+// Box Type: ‘sgpd’
+// Container: Sample Table Box (‘stbl’) or Track Fragment Box (‘traf’)
+// Mandatory: No
+// Quantity: Zero or more, one for each grouping type
+type SampleGroupDescriptionBox struct {
+	*Box
+	Version                       uint8
+	Flags                         [3]byte
+	GroupingType                  string
+	DefaultLength                 uint32 // Version 1 and above: length of every entry, 0 when each entry carries its own.
+	DefaultSampleDescriptionIndex uint32 // Version 2 and above.
+	EntryCount                    uint32
+	// Raw sample group entries, decoded according to the grouping type. Left
+	// nil for version 0 boxes of a grouping type missing from
+	// sampleGroupEntrySizes, whose entry size is not recorded.
+	Entries [][]byte
+}
+
+// sampleGroupEntrySizes holds the entry size of the grouping types whose
+// entries are of fixed size, needed for version 0 boxes which do not record it.
+var sampleGroupEntrySizes = map[string]int{
+	"roll": 2, // roll_distance
+	"prol": 2,
+	"rap ": 1,
+	"tele": 1,
+}
+
+func (b *SampleGroupDescriptionBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.GroupingType = string(data[4:8])
+	offset := 8
+	field := func() (uint32, error) {
+		if offset+4 > len(data) {
+			return 0, b.errorf("box is too short")
+		}
+		offset += 4
+		return binary.BigEndian.Uint32(data[offset-4 : offset]), nil
+	}
+	var err error
+	if b.Version >= 1 {
+		if b.DefaultLength, err = field(); err != nil {
+			return err
+		}
+	}
+	if b.Version >= 2 {
+		if b.DefaultSampleDescriptionIndex, err = field(); err != nil {
+			return err
+		}
+	}
+	if b.EntryCount, err = field(); err != nil {
+		return err
+	}
+
+	size := int(b.DefaultLength)
+	if b.Version == 0 {
+		known, ok := sampleGroupEntrySizes[b.GroupingType]
+		if !ok {
+			// The entries of an unknown grouping type cannot be told apart
+			// without a default_length, so they are left unparsed.
+			return nil
+		}
+		size = known
+	}
+	// Every entry takes at least minSize bytes, which bounds entry_count before
+	// anything is allocated.
+	minSize := size
+	if b.Version >= 1 && b.DefaultLength == 0 {
+		minSize = 4
+	}
+	if minSize > 0 && uint64(b.EntryCount) > uint64(len(data)-offset)/uint64(minSize) {
+		return b.errorf("%d entries do not fit in the box", b.EntryCount)
+	}
+	if err := b.checkSampleCount(uint64(b.EntryCount)); err != nil {
+		return err
+	}
+	b.Entries = make([][]byte, 0, b.EntryCount)
+	for i := uint32(0); i < b.EntryCount; i++ {
+		length := size
+		if b.Version >= 1 && b.DefaultLength == 0 {
+			n, err := field()
+			if err != nil {
+				return err
+			}
+			length = int(n)
+		}
+		if length < 0 || length > len(data)-offset {
+			return b.errorf("entry %d does not fit in the box", i+1)
+		}
+		b.Entries = append(b.Entries, data[offset:offset+length])
+		offset += length
+	}
+	return nil
+}
+
+// RollDistance decodes a ‘roll’ or ‘prol’ entry: the number of samples that
+// have to be decoded before, if negative, or after a sample for it to be
+// presented correctly.
+func RollDistance(entry []byte) (int16, bool) {
+	if len(entry) < 2 {
+		return 0, false
+	}
+	return int16(binary.BigEndian.Uint16(entry[0:2])), true
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleGroupDescriptionEntryCount(t *testing.T) {
+	tests := []struct {
+		name string
+		sgpd []byte
+	}{
+		{"default length", buildFullBox("sgpd", 1, 0, cat([]byte("roll"), be32s(2, 0xffffffff), be16(1)))},
+		{"explicit lengths", buildFullBox("sgpd", 1, 0, cat([]byte("roll"), be32s(0, 0xffffffff, 2), be16(1)))},
+		{"version 0", buildFullBox("sgpd", 0, 0, cat([]byte("roll"), be32(0xffffffff), be16(1)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parseInvalid(t, buildFile(testTrack{id: 1, samples: [][]byte{{0}}, stbl: [][]byte{tt.sgpd}}))
+		})
+	}
+}
+
+func TestTimeToSampleTotalLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []uint32
+	}{
+		{"one huge entry", []uint32{0xffffffff, 1}},
+		{"total wraps uint32", []uint32{0x80000000, 1, 0x80000000, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := cat(be32(uint32(len(tt.entries)/2)), be32s(tt.entries...))
+			stts := &TimeToSampleBox{Box: topBox(t, buildFullBox("stts", 0, 0, payload))}
+			if err := stts.parse(); err == nil {
+				t.Fatalf("parsed %d samples without error", stts.SampleCount())
+			}
+		})
+	}
+}
+
+func TestSampleTableChildErrors(t *testing.T) {
+	for _, name := range []string{"stsd", "stts", "ctts", "stss", "padb", "sdtp", "cslg", "stdp"} {
+		t.Run(name, func(t *testing.T) {
+			short := buildBox(name, []byte{0, 0})
+			err := parseInvalid(t, buildFile(testTrack{id: 1, samples: [][]byte{{0}}, stbl: [][]byte{short}}))
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("error %q does not name the %s box", err, name)
+			}
+		})
+	}
+}
+
+func TestCompositionToDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint8
+		payload []byte
+		want    CompositionToDecodeBox
+	}{
+		{"version 0", 0, be32s(2, 0xfffffffe, 3, 0, 0x80000000), CompositionToDecodeBox{
+			CompositionToDTSShift:        2,
+			LeastDecodeToDisplayDelta:    -2,
+			GreatestDecodeToDisplayDelta: 3,
+			CompositionEndTime:           -1 << 31,
+		}},
+		{"version 1", 1, cat(be64(2), be64(0xfffffffffffffffe), be64(3), be64(0), be64(1<<33)), CompositionToDecodeBox{
+			CompositionToDTSShift:        2,
+			LeastDecodeToDisplayDelta:    -2,
+			GreatestDecodeToDisplayDelta: 3,
+			CompositionEndTime:           1 << 33,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cslg := &CompositionToDecodeBox{Box: topBox(t, buildFullBox("cslg", tt.version, 0, tt.payload))}
+			if err := cslg.parse(); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Box, tt.want.Version = cslg.Box, tt.version
+			if *cslg != tt.want {
+				t.Errorf("got %+v, want %+v", *cslg, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeToSample(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []uint32
+		count    uint32
+		duration uint64
+	}{
+		{"empty", nil, 0, 0},
+		{"one run", []uint32{10, 1001}, 10, 10010},
+		{"two runs", []uint32{3, 40, 2, 20}, 5, 160},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := cat(be32(uint32(len(tt.entries)/2)), be32s(tt.entries...))
+			stts := &TimeToSampleBox{Box: topBox(t, buildFullBox("stts", 0, 0, payload))}
+			if err := stts.parse(); err != nil {
+				t.Fatal(err)
+			}
+			if got := stts.SampleCount(); got != tt.count {
+				t.Errorf("SampleCount() = %d, want %d", got, tt.count)
+			}
+			if got := stts.Duration(); got != tt.duration {
+				t.Errorf("Duration() = %d, want %d", got, tt.duration)
+			}
+		})
+	}
+}
+
+func TestFrameRate(t *testing.T) {
+	tests := []struct {
+		timescale, delta uint32
+		want             float64
+	}{
+		{1000, 40, 25},
+		{30000, 1001, 30000.0 / 1001},
+		{90000, 3000, 30},
+	}
+	for _, tt := range tests {
+		samples := make([][]byte, 5)
+		m := parseFile(t, buildFile(testTrack{id: 1, timescale: tt.timescale, delta: tt.delta, samples: samples}))
+		if got := m.Moov.Traks[0].FrameRate(); got != tt.want {
+			t.Errorf("timescale %d, delta %d: FrameRate() = %v, want %v", tt.timescale, tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestSyncSamples(t *testing.T) {
+	tests := []struct {
+		name string
+		sync []uint32 // nil for a track without stss.
+		want []bool   // IsSyncSample of samples 1 to 5.
+	}{
+		{"no stss", nil, []bool{true, true, true, true, true}},
+		{"empty stss", []uint32{}, []bool{false, false, false, false, false}},
+		{"keyframes", []uint32{1, 4}, []bool{true, false, false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := parseFile(t, buildFile(testTrack{id: 1, samples: make([][]byte, 5), sync: tt.sync}))
+			stbl := m.Moov.Traks[0].Mdia.Minf.Stbl
+			if (stbl.Stss != nil) != (tt.sync != nil) {
+				t.Fatalf("stss parsed: %v, want %v", stbl.Stss != nil, tt.sync != nil)
+			}
+			for i, want := range tt.want {
+				if got := stbl.IsSyncSample(uint32(i + 1)); got != want {
+					t.Errorf("IsSyncSample(%d) = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactSampleSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		fieldSize uint8
+		count     uint32
+		entries   []byte
+		want      []uint32
+		wantErr   bool
+	}{
+		{"4-bit odd count", 4, 3, []byte{0x12, 0xf0}, []uint32{1, 2, 15}, false},
+		{"4-bit even count", 4, 4, []byte{0xab, 0x0c}, []uint32{10, 11, 0, 12}, false},
+		{"8-bit", 8, 3, []byte{1, 200, 255}, []uint32{1, 200, 255}, false},
+		{"16-bit", 16, 2, []byte{0x01, 0x00, 0xff, 0xfe}, []uint32{256, 65534}, false},
+		{"invalid field size", 5, 1, []byte{0}, nil, true},
+		{"truncated", 16, 2, []byte{0, 1, 0}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := cat([]byte{0, 0, 0, tt.fieldSize}, be32(tt.count), tt.entries)
+			stz2 := &CompactSampleSizeBox{Box: topBox(t, buildFullBox("stz2", 0, 0, payload))}
+			err := stz2.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			stbl := &SampleTableBox{Stz2: stz2}
+			if got := stbl.SampleCount(); got != tt.count {
+				t.Errorf("SampleCount() = %d, want %d", got, tt.count)
+			}
+			for i, want := range tt.want {
+				if got := stbl.SizeOf(uint32(i + 1)); got != want {
+					t.Errorf("SizeOf(%d) = %d, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBitRate(t *testing.T) {
+	btrt := func(avg uint32) []byte { return buildBox("btrt", be32s(4096, 2*avg, avg)) }
+	tests := []struct {
+		name    string
+		handler string
+		entry   []byte
+		want    uint64
+	}{
+		{"video btrt", "vide", buildVisualEntry("avc1", 320, 240, buildBox("avcC", testAVCC), btrt(500000)), 500000},
+		{"audio btrt", "soun", buildAudioEntry("mp4a", btrt(128000)), 128000},
+		{"without btrt", "vide", nil, 20000},
+		{"zero average", "soun", buildAudioEntry("mp4a", btrt(0)), 20000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := make([][]byte, 10)
+			for i := range samples {
+				samples[i] = make([]byte, 100)
+			}
+			trak := parseFile(t, buildFile(testTrack{id: 1, handler: tt.handler, entry: tt.entry, samples: samples})).Moov.Traks[0]
+			if got, err := trak.Bitrate(); err != nil || got != tt.want {
+				t.Errorf("Bitrate() = %d, %v; want %d", got, err, tt.want)
+			}
+			if box := trak.Mdia.Minf.Stbl.bitRateBox(); box != nil && box.BufferSizeDB != 4096 {
+				t.Errorf("buffer size %d, want 4096", box.BufferSizeDB)
+			}
+		})
+	}
+}
+
+func TestSampleDependencies(t *testing.T) {
+	// An I picture others depend on, a P picture and a disposable B picture.
+	sdtp := buildFullBox("sdtp", 0, 0, []byte{0x24, 0x94, 0xd9})
+	stbl := parseFile(t, buildFile(testTrack{id: 1, samples: make([][]byte, 3), stbl: [][]byte{sdtp}})).Moov.Traks[0].Mdia.Minf.Stbl
+	want := []SampleDependency{
+		{IsLeading: 0, DependsOn: 2, IsDependedOn: 1, HasRedundancy: 0},
+		{IsLeading: 2, DependsOn: 1, IsDependedOn: 1, HasRedundancy: 0},
+		{IsLeading: 3, DependsOn: 1, IsDependedOn: 2, HasRedundancy: 1},
+	}
+	for i, want := range want {
+		if got, ok := stbl.SampleDependency(uint32(i + 1)); !ok || got != want {
+			t.Errorf("SampleDependency(%d) = %+v, %v; want %+v", i+1, got, ok, want)
+		}
+	}
+	for _, number := range []uint32{0, 4} {
+		if _, ok := stbl.SampleDependency(number); ok {
+			t.Errorf("SampleDependency(%d) found", number)
+		}
+	}
+}
+
+func TestSubSamples(t *testing.T) {
+	// Samples 1 and 3 have sub-samples, the entry deltas giving the distance to
+	// the previous described sample. Version 1 sizes take 32 bits.
+	be16v := func(v uint32) []byte { return be16(uint16(v)) }
+	tests := []struct {
+		version uint8
+		size    func(uint32) []byte
+		last    uint32
+	}{
+		{0, be16v, 7000},
+		{1, be32, 70000},
+	}
+	for _, tt := range tests {
+		payload := cat(be32s(2, 1), be16(2), tt.size(4), []byte{1, 0}, be32(7), tt.size(1000), []byte{0, 1}, be32(0),
+			be32(2), be16(1), tt.size(tt.last), []byte{2, 0}, be32(0))
+		subs := buildFullBox("subs", tt.version, 0, payload)
+		stbl := parseFile(t, buildFile(testTrack{id: 1, samples: make([][]byte, 3), stbl: [][]byte{subs}})).Moov.Traks[0].Mdia.Minf.Stbl
+		want := map[uint32][]SubSample{
+			1: {{Size: 4, Priority: 1, CodecSpecificParameters: 7}, {Size: 1000, Discardable: true}},
+			2: nil,
+			3: {{Size: tt.last, Priority: 2}},
+			4: nil,
+		}
+		for number, want := range want {
+			if got := stbl.SubSamples(number); !reflect.DeepEqual(got, want) {
+				t.Errorf("version %d: SubSamples(%d) = %+v, want %+v", tt.version, number, got, want)
+			}
+		}
+	}
+}
+
+func TestSampleGroupDescription(t *testing.T) {
+	// Sample 1 belongs to no group, samples 2 and 3 to the second entry and
+	// sample 4 to the first one.
+	sbgp := buildFullBox("sbgp", 0, 0, cat([]byte("roll"), be32s(3, 1, 0, 2, 2, 1, 1)))
+	rolls := cat(be16(0xffff), be16(2))
+	type roll struct {
+		distance int16
+		ok       bool
+	}
+	tests := []struct {
+		name string
+		sgpd []byte
+		want []roll
+	}{
+		{"version 0", buildFullBox("sgpd", 0, 0, cat([]byte("roll"), be32(2), rolls)),
+			[]roll{{0, false}, {2, true}, {2, true}, {-1, true}}},
+		{"version 1 default length", buildFullBox("sgpd", 1, 0, cat([]byte("roll"), be32s(2, 2), rolls)),
+			[]roll{{0, false}, {2, true}, {2, true}, {-1, true}}},
+		{"version 1 explicit lengths", buildFullBox("sgpd", 1, 0,
+			cat([]byte("roll"), be32s(0, 2, 2), be16(0xffff), be32(2), be16(2))),
+			[]roll{{0, false}, {2, true}, {2, true}, {-1, true}}},
+		// Sample 1 falls back to the default description of version 2.
+		{"version 2 default length", buildFullBox("sgpd", 2, 0, cat([]byte("roll"), be32s(2, 1, 2), rolls)),
+			[]roll{{-1, true}, {2, true}, {2, true}, {-1, true}}},
+		{"version 2 explicit lengths", buildFullBox("sgpd", 2, 0,
+			cat([]byte("roll"), be32s(0, 0, 2, 2), be16(0xffff), be32(2), be16(2))),
+			[]roll{{0, false}, {2, true}, {2, true}, {-1, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := testTrack{id: 1, samples: [][]byte{{1}, {2}, {3}, {4}}, stbl: [][]byte{sbgp, tt.sgpd}}
+			stbl, err := parseFile(t, buildFile(track)).Moov.Traks[0].sampleTable()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, w := range tt.want {
+				entry, ok := stbl.SampleGroupDescription("roll", uint32(i+1))
+				distance, _ := RollDistance(entry)
+				if ok != w.ok || distance != w.distance {
+					t.Errorf("sample %d: roll distance %d, %v; want %d, %v", i+1, distance, ok, w.distance, w.ok)
+				}
+			}
+			if _, ok := stbl.SampleGroupDescription("prol", 2); ok {
+				t.Error("sample 2 has a prol group")
+			}
+		})
+	}
+}
+
+func TestUnknownSampleGroupEntries(t *testing.T) {
+	sgpd := buildFullBox("sgpd", 0, 0, cat([]byte("abcd"), be32(2), make([]byte, 6)))
+	track := testTrack{id: 1, samples: [][]byte{{1}}, stbl: [][]byte{sgpd}}
+	stbl, err := parseFile(t, buildFile(track)).Moov.Traks[0].sampleTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stbl.Sgpd) != 1 || stbl.Sgpd[0].ParseErr != nil {
+		t.Fatalf("sgpd boxes %v", stbl.Sgpd)
+	}
+	if got := stbl.Sgpd[0]; got.EntryCount != 2 || got.Entries != nil {
+		t.Errorf("entry count %d, entries %v; want 2 entries left unparsed", got.EntryCount, got.Entries)
+	}
+}
+
+func TestCompositionOffsets(t *testing.T) {
+	// Two samples shown 80 ticks late, then one shown 40 ticks early.
+	entries := be32s(2, 80, 1, 0xffffffd8)
+	for _, version := range []uint8{0, 1} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			ctts := buildFullBox("ctts", version, 0, cat(be32(2), entries))
+			track := testTrack{id: 1, samples: [][]byte{{1}, {2}, {3}}, stbl: [][]byte{ctts}}
+			stbl, err := parseFile(t, buildFile(track)).Moov.Traks[0].sampleTable()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stbl.Ctts == nil || stbl.Ctts.ParseErr != nil {
+				t.Fatalf("ctts %v not parsed", stbl.Ctts)
+			}
+			for number, want := range []int32{0, 80, 80, -40, 0} {
+				if got := stbl.CompositionOffset(uint32(number)); got != want {
+					t.Errorf("CompositionOffset(%d) = %d, want %d", number, got, want)
+				}
+			}
+		})
+	}
+}
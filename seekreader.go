@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// NewSeekReader parses size bytes of rs, for sources that can seek but do not
+// implement io.ReaderAt, and returns an &Mp4Reader{}. A negative size is taken
+// from the end of rs.
+//
+// Reads are served by seeking and reading under a mutex, so concurrent reads
+// of the returned reader are serialized, and rs must not be used by anything
+// else while the reader is in use.
+func NewSeekReader(rs io.ReadSeeker, size int64, opts ...Option) (*Mp4Reader, error) {
+	if size < 0 {
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end
+	}
+	return NewReader(&seekReaderAt{rs: rs}, size, opts...)
+}
+
+// seekReaderAt implements io.ReaderAt on top of an io.ReadSeeker.
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// ReadAt, unlike Read, fills p unless it fails.
+	n, err := io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// NewStreamReader reads r to the end and parses the bytes read, for streams
+// that can neither seek nor read at an offset, such as pipes or decompressors.
+// The whole stream is held in memory for the lifetime of the reader, so the
+// cost is the full, decompressed, size of the file.
+func NewStreamReader(r io.Reader, opts ...Option) (*Mp4Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(bytes.NewReader(data), int64(len(data)), opts...)
+}
+
+// OpenGzip opens a gzip-compressed file, e.g. movie.mp4.gz, and parses its
+// decompressed contents. Like NewStreamReader, it decompresses the whole file
+// into memory; the file itself is closed before OpenGzip returns.
+func OpenGzip(path string, opts ...Option) (*Mp4Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return NewStreamReader(zr, opts...)
+}
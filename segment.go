@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// SegmentIndexReference is a single reference of a segment index.
+type SegmentIndexReference struct {
+	ReferenceType      uint8  // 1 if the reference points to another sidx, 0 for media.
+	ReferencedSize     uint32 // Size in bytes of the referenced material.
+	SubsegmentDuration uint32 // Duration in the sidx timescale.
+	StartsWithSAP      bool
+	SAPType            uint8
+	SAPDeltaTime       uint32
+}
+
+// SegmentIndexBox - This box provides a compact index of one media stream within the media segment to which it applies
+// Box Type: ‘sidx’
+// Container: File
+// Mandatory: No
+// Quantity: Zero or more
+type SegmentIndexBox struct {
+	*Box
+	Version                  uint8
+	Flags                    [3]byte
+	ReferenceID              uint32
+	Timescale                uint32
+	EarliestPresentationTime uint64
+	FirstOffset              uint64
+	References               []SegmentIndexReference
+}
+
+func (b *SegmentIndexBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 12 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.ReferenceID = binary.BigEndian.Uint32(data[4:8])
+	b.Timescale = binary.BigEndian.Uint32(data[8:12])
+
+	offset := 12
+	if b.Version == 0 {
+		if len(data) < offset+12 {
+			return b.errorf("box is too short")
+		}
+		b.EarliestPresentationTime = uint64(binary.BigEndian.Uint32(data[12:16]))
+		b.FirstOffset = uint64(binary.BigEndian.Uint32(data[16:20]))
+		offset += 8
+	} else {
+		if len(data) < offset+20 {
+			return b.errorf("box is too short")
+		}
+		b.EarliestPresentationTime = binary.BigEndian.Uint64(data[12:20])
+		b.FirstOffset = binary.BigEndian.Uint64(data[20:28])
+		offset += 16
+	}
+	// reserved uint16
+	count := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+	offset += 4
+	if len(data)-offset < 12*count {
+		return b.errorf("%d references do not fit in the box", count)
+	}
+
+	b.References = make([]SegmentIndexReference, count)
+	for i := range b.References {
+		entry := data[offset+12*i:]
+		ref := &b.References[i]
+		size := binary.BigEndian.Uint32(entry[0:4])
+		ref.ReferenceType = uint8(size >> 31)
+		ref.ReferencedSize = size & 0x7fffffff
+		ref.SubsegmentDuration = binary.BigEndian.Uint32(entry[4:8])
+		sap := binary.BigEndian.Uint32(entry[8:12])
+		ref.StartsWithSAP = sap>>31 == 1
+		ref.SAPType = uint8(sap>>28) & 0x07
+		ref.SAPDeltaTime = sap & 0x0fffffff
+	}
+	return nil
+}
+
+// Segment is the byte range and time span of a referenced subsegment.
+type Segment struct {
+	Offset    int64  // File offset of the first byte.
+	Size      uint32 // Size in bytes.
+	StartTime uint64 // Earliest presentation time in the sidx timescale.
+	Duration  uint32 // Duration in the sidx timescale.
+}
+
+// Segments returns the byte ranges of the references. Offsets are anchored at
+// the first byte following the sidx box, plus first_offset.
+func (b *SegmentIndexBox) Segments() []Segment {
+	segments := make([]Segment, len(b.References))
+	offset := b.Start + b.Size + int64(b.FirstOffset)
+	start := b.EarliestPresentationTime
+	for i, ref := range b.References {
+		segments[i] = Segment{Offset: offset, Size: ref.ReferencedSize, StartTime: start, Duration: ref.SubsegmentDuration}
+		offset += int64(ref.ReferencedSize)
+		start += uint64(ref.SubsegmentDuration)
+	}
+	return segments
+}
+
+// ProducerReferenceTimeBox - This box supplies relative wall-clock times at which movie fragments, or files containing movie fragments, were produced
+// Box Type: ‘prft’
+// Container: File
+// Mandatory: No
+// Quantity: Zero or more
+type ProducerReferenceTimeBox struct {
+	*Box
+	Version          uint8
+	Flags            [3]byte
+	ReferenceTrackID uint32
+	NTPTimestamp     uint64 // UTC time in NTP format: seconds since 1900 in the upper 32 bits, the fraction in the lower.
+	MediaTime        uint64 // In the media timescale of the reference track.
+}
+
+func (b *ProducerReferenceTimeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 20 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	b.ReferenceTrackID = binary.BigEndian.Uint32(data[4:8])
+	b.NTPTimestamp = binary.BigEndian.Uint64(data[8:16])
+	if b.Version == 0 {
+		b.MediaTime = uint64(binary.BigEndian.Uint32(data[16:20]))
+	} else {
+		if len(data) < 24 {
+			return b.errorf("box is too short")
+		}
+		b.MediaTime = binary.BigEndian.Uint64(data[16:24])
+	}
+	return nil
+}
+
+// ntpEpochOffset is the number of seconds from the NTP epoch, 1900-01-01, to
+// the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// Time returns the wall-clock time of NTPTimestamp.
+func (b *ProducerReferenceTimeBox) Time() time.Time {
+	secs := int64(b.NTPTimestamp>>32) - ntpEpochOffset
+	nanos := int64((b.NTPTimestamp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nanos).UTC()
+}
+
+// EventMessageBox - This box carries an in-band event, e.g. an SCTE-35 splice of a DASH stream
+// Box Type: ‘emsg’
+// Container: File
+// Mandatory: No
+// Quantity: Zero or more
+type EventMessageBox struct {
+	*Box
+	Version               uint8
+	Flags                 [3]byte
+	SchemeIDURI           string
+	Value                 string
+	Timescale             uint32
+	PresentationTimeDelta uint32 // Version 0: relative to the earliest presentation time of the segment.
+	PresentationTime      uint64 // Version 1: on the media timeline.
+	EventDuration         uint32 // 0xffffffff for an unknown duration.
+	ID                    uint32
+	MessageData           []byte
+}
+
+func (b *EventMessageBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.Version = data[0]
+	for i := 0; i < 3; i++ {
+		b.Flags[i] = data[i+1]
+	}
+	rest := data[4:]
+	cstring := func() (string, bool) {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return "", false
+		}
+		s := string(rest[:i])
+		rest = rest[i+1:]
+		return s, true
+	}
+	var ok1, ok2 bool
+
+	switch b.Version {
+	case 0:
+		// The strings come first in version 0.
+		b.SchemeIDURI, ok1 = cstring()
+		b.Value, ok2 = cstring()
+		if !ok1 || !ok2 || len(rest) < 16 {
+			return b.errorf("box is too short")
+		}
+		b.Timescale = binary.BigEndian.Uint32(rest[0:4])
+		b.PresentationTimeDelta = binary.BigEndian.Uint32(rest[4:8])
+		b.EventDuration = binary.BigEndian.Uint32(rest[8:12])
+		b.ID = binary.BigEndian.Uint32(rest[12:16])
+		rest = rest[16:]
+	case 1:
+		if len(rest) < 20 {
+			return b.errorf("box is too short")
+		}
+		b.Timescale = binary.BigEndian.Uint32(rest[0:4])
+		b.PresentationTime = binary.BigEndian.Uint64(rest[4:12])
+		b.EventDuration = binary.BigEndian.Uint32(rest[12:16])
+		b.ID = binary.BigEndian.Uint32(rest[16:20])
+		rest = rest[20:]
+		b.SchemeIDURI, ok1 = cstring()
+		b.Value, ok2 = cstring()
+		if !ok1 || !ok2 {
+			return b.errorf("box is too short")
+		}
+	default:
+		return b.errorf("unsupported version %d", b.Version)
+	}
+	b.MessageData = rest
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProducerReferenceTime(t *testing.T) {
+	// 2020-01-01 00:00:00.5 UTC in NTP format.
+	ntp := uint64(ntpEpochOffset+1577836800)<<32 | 0x80000000
+	wall := time.Date(2020, 1, 1, 0, 0, 0, 500000000, time.UTC)
+	tests := []struct {
+		name    string
+		version uint8
+		media   []byte
+		want    uint64
+	}{
+		{"version 0", 0, be32(90000), 90000},
+		{"version 1", 1, be64(1 << 40), 1 << 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prft := buildFullBox("prft", tt.version, 0, cat(be32(1), be64(ntp), tt.media))
+			m := parseFile(t, cat(prft, buildSegment(1, 1, 0, []byte("sample"))))
+			if len(m.Prfts) != 1 {
+				t.Fatalf("got %d prft boxes, want 1", len(m.Prfts))
+			}
+			got := m.Prfts[0]
+			if got.ReferenceTrackID != 1 || got.MediaTime != tt.want || !got.Time().Equal(wall) {
+				t.Errorf("prft track %d, media time %d at %v; want 1, %d at %v", got.ReferenceTrackID, got.MediaTime, got.Time(), tt.want, wall)
+			}
+		})
+	}
+}
+
+func TestEventMessage(t *testing.T) {
+	scheme := []byte("urn:scte:scte35:2013:bin\x00")
+	tests := []struct {
+		name    string
+		version uint8
+		payload []byte
+		want    EventMessageBox
+		wantErr bool
+	}{
+		{"version 0", 0, cat(scheme, []byte("1\x00"), be32s(90000, 1800, 0xffffffff, 7), []byte{0xfc, 0x30}),
+			EventMessageBox{SchemeIDURI: "urn:scte:scte35:2013:bin", Value: "1", Timescale: 90000,
+				PresentationTimeDelta: 1800, EventDuration: 0xffffffff, ID: 7, MessageData: []byte{0xfc, 0x30}}, false},
+		{"version 1", 1, cat(be32(1000), be64(1<<33), be32s(500, 8), scheme, []byte("\x00"), []byte("data")),
+			EventMessageBox{Version: 1, SchemeIDURI: "urn:scte:scte35:2013:bin", Timescale: 1000,
+				PresentationTime: 1 << 33, EventDuration: 500, ID: 8, MessageData: []byte("data")}, false},
+		{"unterminated value", 0, cat(scheme, []byte("1")), EventMessageBox{}, true},
+		{"version 1 too short", 1, be32s(1000, 0), EventMessageBox{}, true},
+		{"version 2", 2, nil, EventMessageBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildFullBox("emsg", tt.version, 0, tt.payload)
+			if tt.wantErr {
+				parseInvalid(t, data)
+				return
+			}
+			emsgs := parseFile(t, data).Emsgs
+			if len(emsgs) != 1 {
+				t.Fatalf("got %d emsg boxes, want 1", len(emsgs))
+			}
+			emsg := emsgs[0]
+			tt.want.Box = emsg.Box
+			if !reflect.DeepEqual(*emsg, tt.want) {
+				t.Errorf("got %+v, want %+v", *emsg, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Sample describes the location of a single sample in the file.
+type Sample struct {
+	Number           uint32 // 1-based sample number in decoding order.
+	Offset           int64  // File offset of the first byte of the sample.
+	Size             uint32
+	IsSync           bool
+	DescriptionIndex uint32 // 1-based index of the sample entry in stsd.
+}
+
+// HandlerType returns the handler type of the track (vide, soun, hint, ...),
+// or an empty string if the track has no hdlr box.
+func (b *TrackBox) HandlerType() string {
+	if b.Mdia == nil || b.Mdia.Hdlr == nil {
+		return ""
+	}
+	return b.Mdia.Hdlr.TypeName
+}
+
+// TrackByID returns the track whose tkhd has the given track ID.
+func (m *Mp4Reader) TrackByID(id uint32) (*TrackBox, error) {
+	if m.Moov == nil {
+		return nil, fmt.Errorf("no moov box found")
+	}
+	for _, trak := range m.Moov.Traks {
+		if trak.Tkhd != nil && trak.Tkhd.TrackID == id {
+			return trak, nil
+		}
+	}
+	return nil, fmt.Errorf("track %d not found", id)
+}
+
+// TrackIDs returns the IDs of the tracks in file order. Tracks without a tkhd
+// box are left out.
+func (m *Mp4Reader) TrackIDs() []uint32 {
+	if m.Moov == nil {
+		return nil
+	}
+	ids := make([]uint32, 0, len(m.Moov.Traks))
+	for _, trak := range m.Moov.Traks {
+		if trak.Tkhd != nil {
+			ids = append(ids, trak.Tkhd.TrackID)
+		}
+	}
+	return ids
+}
+
+// sampleTable returns the sample table of the track or an error if the track
+// has none.
+func (b *TrackBox) sampleTable() (*SampleTableBox, error) {
+	if b.Mdia == nil || b.Mdia.Minf == nil || b.Mdia.Minf.Stbl == nil {
+		return nil, fmt.Errorf("track has no sample table")
+	}
+	return b.Mdia.Minf.Stbl, nil
+}
+
+// decodeTimes expands the stts table into the decoding time of every sample.
+func decodeTimes(stts *TimeToSampleBox) []uint64 {
+	times := make([]uint64, 0, stts.SampleCount())
+	var dts uint64
+	for _, entry := range stts.Entries {
+		for i := uint32(0); i < entry.SampleCount; i++ {
+			times = append(times, dts)
+			dts += uint64(entry.SampleDelta)
+		}
+	}
+	return times
+}
+
+// PresentationOrder returns the 1-based sample numbers of the track sorted by
+// presentation time, that is the order in which decoded frames are displayed.
+// Without a ctts box the presentation order equals the decoding order.
+func (b *TrackBox) PresentationOrder() ([]uint32, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return nil, err
+	}
+	if stbl.Stts == nil {
+		return nil, fmt.Errorf("track has no stts box")
+	}
+
+	dts := decodeTimes(stbl.Stts)
+	pts := make([]int64, len(dts))
+	for i, t := range dts {
+		pts[i] = int64(t)
+	}
+	if stbl.Ctts != nil {
+		i := 0
+		for _, entry := range stbl.Ctts.Entries {
+			for n := uint32(0); n < entry.SampleCount && i < len(pts); n++ {
+				pts[i] += int64(entry.SampleOffset)
+				i++
+			}
+		}
+	}
+
+	order := make([]uint32, len(pts))
+	for i := range order {
+		order[i] = uint32(i + 1)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return pts[order[i]-1] < pts[order[j]-1]
+	})
+	return order, nil
+}
+
+// SampleTime holds the timestamps of a sample in the media timescale.
+type SampleTime struct {
+	DTS int64 // Decoding time.
+	PTS int64 // Presentation time, the decoding time plus the composition offset.
+}
+
+// SampleTimes returns the decoding and presentation times of every sample of
+// the track, from the stts and ctts tables with the signed composition offsets
+// of version 1 ctts boxes. Both are shifted by the edit list so that the first
+// presented media time, the media_time of the first edit, lands at the start
+// of the presentation, after any leading empty edit. Further edits are not
+// applied; for a typical file with B-frames this gives PTS 0 for the first
+// displayed frame and a negative DTS for the frames decoded before it.
+func (b *TrackBox) SampleTimes() ([]SampleTime, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return nil, err
+	}
+	if stbl.Stts == nil {
+		return nil, fmt.Errorf("track has no stts box")
+	}
+	shift, err := b.editShift()
+	if err != nil {
+		return nil, err
+	}
+
+	dts := decodeTimes(stbl.Stts)
+	times := make([]SampleTime, len(dts))
+	for i, t := range dts {
+		times[i].DTS = int64(t) + shift
+		times[i].PTS = times[i].DTS
+	}
+	if stbl.Ctts != nil {
+		i := 0
+		for _, entry := range stbl.Ctts.Entries {
+			for n := uint32(0); n < entry.SampleCount && i < len(times); n++ {
+				times[i].PTS += int64(entry.SampleOffset)
+				i++
+			}
+		}
+	}
+	return times, nil
+}
+
+// editShift returns the offset in the media timescale that the edit list adds
+// to media times: the duration of the leading empty edits minus the media time
+// of the first edit that presents media.
+func (b *TrackBox) editShift() (int64, error) {
+	if b.Edts == nil || b.Edts.Elst == nil {
+		return 0, nil
+	}
+	if b.Mdia == nil || b.Mdia.Mdhd == nil || b.Mdia.Mdhd.Timescale == 0 {
+		return 0, fmt.Errorf("track has no media timescale")
+	}
+	var empty uint64 // In the movie timescale.
+	for _, edit := range b.Edts.Elst.Entries {
+		if edit.MediaTime == -1 {
+			empty += edit.SegmentDuration
+			continue
+		}
+		shift := -edit.MediaTime
+		if empty > 0 {
+			moov := b.Reader.Moov
+			if moov == nil || moov.Mvhd == nil || moov.Mvhd.Timescale == 0 {
+				return 0, fmt.Errorf("movie has no timescale")
+			}
+			shift += int64(empty * uint64(b.Mdia.Mdhd.Timescale) / uint64(moov.Mvhd.Timescale))
+		}
+		return shift, nil
+	}
+	return 0, nil
+}
+
+// Chunk describes a chunk of the track, a run of consecutive samples stored
+// contiguously in the file.
+type Chunk struct {
+	Number           uint32 // 1-based chunk number, the index into stco or co64.
+	Offset           int64  // File offset of the first byte of the chunk.
+	FirstSample      uint32 // Number of the first sample of the chunk.
+	SampleCount      uint32
+	Size             uint64 // Total size of the samples.
+	DescriptionIndex uint32 // 1-based index of the sample entry in stsd.
+}
+
+// Chunks returns every chunk of the track, computed from the stsc, stsz and
+// stco tables. Chunks extending past the end of the file are reported as an
+// error.
+func (b *TrackBox) Chunks() ([]Chunk, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return nil, err
+	}
+	if stbl.Stsc == nil || (stbl.Stsz == nil && stbl.Stz2 == nil) {
+		return nil, fmt.Errorf("track is missing the stsc or stsz box")
+	}
+	offsets, err := stbl.ChunkOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	sampleToChunks := stbl.Stsc.SampleToChunks
+	sampleCount := stbl.SampleCount()
+	chunks := make([]Chunk, 0, len(offsets))
+	number := uint32(1)
+
+	// Each stsc entry is a (first_chunk, samples_per_chunk, sample_description_index)
+	// triple which applies up to the first chunk of the next entry.
+	selfContained := b.IsSelfContained()
+	for k := 0; k+2 < len(sampleToChunks); k += 3 {
+		firstChunk, samplesPerChunk, descIndex := sampleToChunks[k], sampleToChunks[k+1], sampleToChunks[k+2]
+		// Chunks of external media data are not bound by the size of this file.
+		inFile := selfContained || !b.isExternal(descIndex)
+		lastChunk := uint32(len(offsets))
+		if k+3 < len(sampleToChunks) {
+			lastChunk = sampleToChunks[k+3] - 1
+		}
+		if firstChunk == 0 || lastChunk > uint32(len(offsets)) {
+			return nil, fmt.Errorf("stsc entry %d refers to chunks outside of stco", k/3+1)
+		}
+
+		for chunk := firstChunk; chunk <= lastChunk; chunk++ {
+			if uint64(number)+uint64(samplesPerChunk) > uint64(sampleCount)+1 {
+				return nil, fmt.Errorf("stsc describes more samples than stsz")
+			}
+			c := Chunk{
+				Number:           chunk,
+				Offset:           offsets[chunk-1],
+				FirstSample:      number,
+				SampleCount:      samplesPerChunk,
+				DescriptionIndex: descIndex,
+			}
+			for i := uint32(0); i < samplesPerChunk; i++ {
+				c.Size += uint64(stbl.SizeOf(number))
+				number++
+			}
+			if size := b.Reader.Size; inFile && size > 0 && uint64(c.Offset)+c.Size > uint64(size) {
+				return nil, fmt.Errorf("chunk %d offset %d exceeds file size %d", chunk, c.Offset, size)
+			}
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks, nil
+}
+
+// Samples returns the location of every sample of the track, computed from the
+// stsc, stsz and stco tables like Chunks.
+func (b *TrackBox) Samples() ([]Sample, error) {
+	chunks, err := b.Chunks()
+	if err != nil {
+		return nil, err
+	}
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, 0, stbl.SampleCount())
+	for _, chunk := range chunks {
+		offset := chunk.Offset
+		for number := chunk.FirstSample; number < chunk.FirstSample+chunk.SampleCount; number++ {
+			size := stbl.SizeOf(number)
+			samples = append(samples, Sample{
+				Number:           number,
+				Offset:           offset,
+				Size:             size,
+				IsSync:           stbl.IsSyncSample(number),
+				DescriptionIndex: chunk.DescriptionIndex,
+			})
+			offset += int64(size)
+		}
+	}
+	return samples, nil
+}
+
+// ReadSample reads the bytes of the 1-based sample number. The sample is looked
+// up in the stsc, stsz and stco tables without expanding them, so reading every
+// sample of a track this way takes linear time.
+func (b *TrackBox) ReadSample(sampleNumber uint32) ([]byte, error) {
+	sample, err := b.sample(sampleNumber)
+	if err != nil {
+		return nil, err
+	}
+	return b.readSample(sample)
+}
+
+// sample locates the 1-based sample number like Samples does for every sample:
+// the stsc entries are walked to the chunk holding it, whose preceding samples
+// give its offset.
+func (b *TrackBox) sample(number uint32) (Sample, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return Sample{}, err
+	}
+	if stbl.Stsc == nil || (stbl.Stsz == nil && stbl.Stz2 == nil) {
+		return Sample{}, fmt.Errorf("track is missing the stsc or stsz box")
+	}
+	if count := stbl.SampleCount(); number == 0 || number > count {
+		return Sample{}, fmt.Errorf("sample %d out of range [1, %d]", number, count)
+	}
+	offsets, err := stbl.ChunkOffsets()
+	if err != nil {
+		return Sample{}, err
+	}
+
+	sampleToChunks := stbl.Stsc.SampleToChunks
+	first := uint64(1) // First sample of the chunks of the current stsc entry.
+	for k := 0; k+2 < len(sampleToChunks); k += 3 {
+		firstChunk, samplesPerChunk, descIndex := sampleToChunks[k], sampleToChunks[k+1], sampleToChunks[k+2]
+		lastChunk := uint32(len(offsets))
+		if k+3 < len(sampleToChunks) {
+			lastChunk = sampleToChunks[k+3] - 1
+		}
+		if firstChunk == 0 || lastChunk > uint32(len(offsets)) {
+			return Sample{}, fmt.Errorf("stsc entry %d refers to chunks outside of stco", k/3+1)
+		}
+		if lastChunk < firstChunk {
+			continue
+		}
+		n := uint64(lastChunk-firstChunk+1) * uint64(samplesPerChunk)
+		if uint64(number) >= first+n {
+			first += n
+			continue
+		}
+
+		chunk := firstChunk + uint32((uint64(number)-first)/uint64(samplesPerChunk))
+		s := Sample{
+			Number:           number,
+			Offset:           offsets[chunk-1],
+			Size:             stbl.SizeOf(number),
+			IsSync:           stbl.IsSyncSample(number),
+			DescriptionIndex: descIndex,
+		}
+		for i := uint32(first) + (chunk-firstChunk)*samplesPerChunk; i < number; i++ {
+			s.Offset += int64(stbl.SizeOf(i))
+		}
+		inFile := b.IsSelfContained() || !b.isExternal(descIndex)
+		if size := b.Reader.Size; inFile && size > 0 && uint64(s.Offset)+uint64(s.Size) > uint64(size) {
+			return Sample{}, fmt.Errorf("chunk %d offset %d exceeds file size %d", chunk, offsets[chunk-1], size)
+		}
+		return s, nil
+	}
+	return Sample{}, fmt.Errorf("sample %d is not described by stsc", number)
+}
+
+// ReadSamples reads the bytes of count samples from the 1-based sample number
+// start on, fewer if the track ends first. The samples are returned as stored,
+// e.g. with length-prefixed NAL units for H.264, not converted to Annex-B.
+func (b *TrackBox) ReadSamples(start, count uint32) ([][]byte, error) {
+	samples, err := b.Samples()
+	if err != nil {
+		return nil, err
+	}
+	if start == 0 || start > uint32(len(samples)) {
+		return nil, fmt.Errorf("sample %d out of range [1, %d]", start, len(samples))
+	}
+	samples = samples[start-1:]
+	if count < uint32(len(samples)) {
+		samples = samples[:count]
+	}
+	data := make([][]byte, 0, len(samples))
+	for _, sample := range samples {
+		buf, err := b.readSample(sample)
+		if err != nil {
+			return data, err
+		}
+		data = append(data, buf)
+	}
+	return data, nil
+}
+
+func (b *TrackBox) readSample(sample Sample) ([]byte, error) {
+	r, err := b.sampleData(sample)
+	if err != nil {
+		return nil, fmt.Errorf("sample %d: %v", sample.Number, err)
+	}
+	buf := make([]byte, sample.Size)
+	if _, err := r.ReadAt(buf, sample.Offset); err != nil {
+		return nil, fmt.Errorf("reading sample %d: %v", sample.Number, err)
+	}
+	return buf, nil
+}
+
+// forEachSample reads the samples of the track in decoding order and passes each
+// one with its bytes to fn, stopping at the first error.
+func (b *TrackBox) forEachSample(fn func(sample Sample, data []byte) error) error {
+	samples, err := b.Samples()
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		data, err := b.readSample(sample)
+		if err != nil {
+			return err
+		}
+		if err := fn(sample, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bitrate returns the average bitrate of the track in bits per second. The
+// average of the btrt box of the sample entry is used when present, otherwise
+// the total size of the samples over the media duration. It is not the peak
+// bitrate.
+func (b *TrackBox) Bitrate() (uint64, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return 0, err
+	}
+	if btrt := stbl.bitRateBox(); btrt != nil && btrt.AvgBitrate != 0 {
+		return uint64(btrt.AvgBitrate), nil
+	}
+	if b.Mdia.Mdhd == nil || b.Mdia.Mdhd.Timescale == 0 {
+		return 0, fmt.Errorf("track has no media timescale")
+	}
+	ticks := b.Mdia.Mdhd.Duration
+	if ticks == 0 && stbl.Stts != nil {
+		ticks = stbl.Stts.Duration()
+	}
+	if ticks == 0 {
+		return 0, fmt.Errorf("track has no duration")
+	}
+
+	total, err := b.TotalSampleBytes()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(float64(total) * 8 * float64(b.Mdia.Mdhd.Timescale) / float64(ticks)), nil
+}
+
+// TotalSampleBytes returns the sum of the sample sizes of the track, that is
+// the number of media data bytes it occupies.
+func (b *TrackBox) TotalSampleBytes() (uint64, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return 0, err
+	}
+	if stbl.Stsz == nil && stbl.Stz2 == nil {
+		return 0, fmt.Errorf("track has no sample size table")
+	}
+	if stbl.Stsz != nil && stbl.Stsz.SampleSize != 0 {
+		return uint64(stbl.Stsz.SampleSize) * uint64(stbl.Stsz.SampleCount), nil
+	}
+	var total uint64
+	for n := uint32(1); n <= stbl.SampleCount(); n++ {
+		total += uint64(stbl.SizeOf(n))
+	}
+	return total, nil
+}
+
+// TrackSizes returns the number of media data bytes of every track keyed by
+// track ID. Tracks without a tkhd box or sample size table are left out.
+func (m *Mp4Reader) TrackSizes() map[uint32]uint64 {
+	sizes := make(map[uint32]uint64)
+	if m.Moov == nil {
+		return sizes
+	}
+	for _, trak := range m.Moov.Traks {
+		if trak.Tkhd == nil {
+			continue
+		}
+		if total, err := trak.TotalSampleBytes(); err == nil {
+			sizes[trak.Tkhd.TrackID] = total
+		}
+	}
+	return sizes
+}
+
+// SampleAtTime returns the 1-based number of the sample whose decoding interval,
+// as given by the stts table, contains the time d from the start of the media.
+func (b *TrackBox) SampleAtTime(d time.Duration) (uint32, error) {
+	stbl, err := b.sampleTable()
+	if err != nil {
+		return 0, err
+	}
+	if stbl.Stts == nil || b.Mdia.Mdhd == nil || b.Mdia.Mdhd.Timescale == 0 {
+		return 0, fmt.Errorf("track has no stts box or media timescale")
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("time %v is negative", d)
+	}
+	ts := uint64(b.Mdia.Mdhd.Timescale)
+	ticks := uint64(d/time.Second)*ts + uint64(d%time.Second)*ts/uint64(time.Second)
+
+	number := uint32(1)
+	var dts uint64
+	for _, entry := range stbl.Stts.Entries {
+		span := uint64(entry.SampleCount) * uint64(entry.SampleDelta)
+		if ticks < dts+span {
+			return number + uint32((ticks-dts)/uint64(entry.SampleDelta)), nil
+		}
+		dts += span
+		number += entry.SampleCount
+	}
+	return 0, fmt.Errorf("time %v is past the end of the track", d)
+}
+
+// SyncSampleAtOrBefore returns the number of the last sync sample at or before
+// the sample at time d, the point a clip starting at d has to be cut at.
+func (b *TrackBox) SyncSampleAtOrBefore(d time.Duration) (uint32, error) {
+	number, err := b.SampleAtTime(d)
+	if err != nil {
+		return 0, err
+	}
+	stbl := b.Mdia.Minf.Stbl
+	for ; number > 0; number-- {
+		if stbl.IsSyncSample(number) {
+			return number, nil
+		}
+	}
+	return 0, fmt.Errorf("no sync sample at or before %v", d)
+}
@@ -0,0 +1,68 @@
+package main
+
+import "encoding/binary"
+
+// TrackReferenceBox - This box provides a reference from the containing track to another track in the presentation
+// Box Type: ‘tref’
+// Container: Track Box (‘trak’)
+// Mandatory: No
+// Quantity: Zero or one
+type TrackReferenceBox struct {
+	*Box
+	Types []*TrackReferenceTypeBox
+}
+
+func (b *TrackReferenceBox) parse() error {
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset(), b.PayloadSize())
+	if err != nil {
+		return b.wrapError(err)
+	}
+
+	// Every child is a reference type box named after the type, e.g. ‘chap’.
+	for _, box := range boxes {
+		ref := &TrackReferenceTypeBox{Box: box}
+		if err := parseBox(ref); err != nil {
+			return b.wrapError(err)
+		}
+		b.Types = append(b.Types, ref)
+	}
+	return nil
+}
+
+// TrackReferenceTypeBox - The track IDs referenced for one reference type, e.g. ‘hint’, ‘cdsc’ or ‘chap’
+// Box Type: the reference type
+// Container: Track Reference Box (‘tref’)
+// Mandatory: No
+// Quantity: Zero or more
+type TrackReferenceTypeBox struct {
+	*Box
+	TrackIDs []uint32
+}
+
+func (b *TrackReferenceTypeBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data)%4 != 0 {
+		return b.errorf("size %d is not a multiple of 4", len(data))
+	}
+	b.TrackIDs = make([]uint32, len(data)/4)
+	for i := range b.TrackIDs {
+		b.TrackIDs[i] = binary.BigEndian.Uint32(data[4*i : 4*i+4])
+	}
+	return nil
+}
+
+// References returns the IDs of the tracks referenced by the track with the
+// given reference type, e.g. "chap" for its chapter tracks, or nil if there are
+// none.
+func (b *TrackBox) References(refType string) []uint32 {
+	if b.Tref == nil {
+		return nil
+	}
+	var ids []uint32
+	for _, ref := range b.Tref.Types {
+		if ref.Name == refType {
+			ids = append(ids, ref.TrackIDs...)
+		}
+	}
+	return ids
+}
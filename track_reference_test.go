@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackReferences(t *testing.T) {
+	tref := buildContainer("tref", buildBox("chap", be32s(3)), buildBox("hint", be32s(2, 4)), buildBox("chap", be32s(5)))
+	m := parseFile(t, buildFile(testTrack{id: 1, trak: [][]byte{tref}}, testTrack{id: 2}))
+	tests := []struct {
+		track   int
+		refType string
+		want    []uint32
+	}{
+		{0, "chap", []uint32{3, 5}},
+		{0, "hint", []uint32{2, 4}},
+		{0, "cdsc", nil},
+		{1, "chap", nil},
+	}
+	for _, tt := range tests {
+		if got := m.Moov.Traks[tt.track].References(tt.refType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("track %d: References(%q) = %v, want %v", tt.track+1, tt.refType, got, tt.want)
+		}
+	}
+
+	bad := buildContainer("tref", buildBox("chap", []byte{0, 0, 3}))
+	parseInvalid(t, buildFile(testTrack{id: 1, trak: [][]byte{bad}}))
+}
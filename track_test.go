@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSamples(t *testing.T) {
+	samples := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), []byte("dddd"), []byte("eeeee")}
+	tests := []struct {
+		name     string
+		perChunk int
+		sync     []uint32
+	}{
+		{"one chunk", 0, nil},
+		{"two per chunk", 2, []uint32{1, 3}},
+		{"one per chunk", 1, []uint32{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := testTrack{id: 1, handler: "soun", samples: [][]byte{[]byte("audio")}}
+			data := buildFile(audio, testTrack{id: 2, samples: samples, perChunk: tt.perChunk, sync: tt.sync})
+			trak := parseFile(t, data).Moov.Traks[1]
+
+			got, err := trak.Samples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []Sample
+			offset := int64(bytes.Index(data, []byte("abbccc")))
+			for i, s := range samples {
+				number := uint32(i + 1)
+				sync := tt.sync == nil
+				for _, n := range tt.sync {
+					sync = sync || n == number
+				}
+				want = append(want, Sample{Number: number, Offset: offset, Size: uint32(len(s)), IsSync: sync, DescriptionIndex: 1})
+				offset += int64(len(s))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Samples() = %+v, want %+v", got, want)
+			}
+
+			for i, want := range samples {
+				if got, err := trak.ReadSample(uint32(i + 1)); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("ReadSample(%d) = %q, %v; want %q", i+1, got, err, want)
+				}
+			}
+			for _, number := range []uint32{0, uint32(len(samples) + 1)} {
+				if _, err := trak.ReadSample(number); err == nil {
+					t.Errorf("ReadSample(%d) succeeded", number)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkOffsetsPastEndOfFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		dref    [][]byte
+		offset  func(size uint32) uint32 // Offset of the single chunk in a file of the given size.
+		wantErr string
+	}{
+		{"in file", nil, func(size uint32) uint32 { return size - 4 }, ""},
+		{"past the end", nil, func(size uint32) uint32 { return size + 100 }, "chunk 1 offset %d exceeds file size %d"},
+		{"across the end", nil, func(size uint32) uint32 { return size - 3 }, "chunk 1 offset %d exceeds file size %d"},
+		{"external data", [][]byte{buildFullBox("url ", 0, 0, []byte("media.mdat\x00"))},
+			func(size uint32) uint32 { return size + 100 }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildFile(testTrack{id: 1, samples: [][]byte{[]byte("ab"), []byte("cd")}, dref: tt.dref})
+			trak := parseFile(t, data).Moov.Traks[0]
+			offset := tt.offset(uint32(len(data)))
+			stco := trak.Mdia.Minf.Stbl.Stco
+			binary.BigEndian.PutUint32(data[stco.Start+BoxHeaderSize+8:], offset)
+
+			_, err := parseFile(t, data).Moov.Traks[0].Samples()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Samples() error %v", err)
+				}
+				return
+			}
+			if want := fmt.Sprintf(tt.wantErr, offset, len(data)); err == nil || err.Error() != want {
+				t.Errorf("Samples() error %v, want %s", err, want)
+			}
+		})
+	}
+}
+
+func TestSampleAtTime(t *testing.T) {
+	data := buildFile(testTrack{id: 1, samples: make([][]byte, 10), sync: []uint32{1, 5, 9}})
+	trak := parseFile(t, data).Moov.Traks[0]
+	ms := time.Millisecond
+	tests := []struct {
+		time    time.Duration
+		sample  uint32
+		sync    uint32
+		wantErr bool
+	}{
+		{0, 1, 1, false},
+		{39 * ms, 1, 1, false},
+		{40 * ms, 2, 1, false},
+		{150 * ms, 4, 1, false},
+		{170 * ms, 5, 5, false},
+		{399 * ms, 10, 9, false},
+		{400 * ms, 0, 0, true},
+		{-ms, 0, 0, true},
+	}
+	for _, tt := range tests {
+		sample, err := trak.SampleAtTime(tt.time)
+		if (err != nil) != tt.wantErr || sample != tt.sample {
+			t.Errorf("SampleAtTime(%v) = %d, %v; want %d", tt.time, sample, err, tt.sample)
+		}
+		sync, err := trak.SyncSampleAtOrBefore(tt.time)
+		if (err != nil) != tt.wantErr || sync != tt.sync {
+			t.Errorf("SyncSampleAtOrBefore(%v) = %d, %v; want %d", tt.time, sync, err, tt.sync)
+		}
+	}
+}
+
+func TestTrackSizes(t *testing.T) {
+	video := testTrack{id: 1, samples: [][]byte{make([]byte, 1000), make([]byte, 200), make([]byte, 300)}}
+	audio := testTrack{id: 3, handler: "soun", samples: [][]byte{make([]byte, 10), make([]byte, 20)}, perChunk: 1}
+	empty := testTrack{id: 4, handler: "soun"}
+	m := parseFile(t, buildFile(video, audio, empty))
+
+	want := map[uint32]uint64{1: 1500, 3: 30, 4: 0}
+	if got := m.TrackSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TrackSizes() = %v, want %v", got, want)
+	}
+	for i, trak := range m.Moov.Traks {
+		got, err := trak.TotalSampleBytes()
+		if id := trak.Tkhd.TrackID; err != nil || got != want[id] {
+			t.Errorf("track %d: TotalSampleBytes() = %d, %v; want %d", i+1, got, err, want[id])
+		}
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	samples := [][]byte{[]byte("one"), []byte("two"), []byte("three"), []byte("four")}
+	trak := parseFile(t, buildFile(testTrack{id: 1, samples: samples, perChunk: 3})).Moov.Traks[0]
+	tests := []struct {
+		start, count uint32
+		want         [][]byte
+		wantErr      bool
+	}{
+		{1, 2, samples[:2], false},
+		{2, 3, samples[1:4], false},
+		{3, 10, samples[2:], false},
+		{4, 1, samples[3:], false},
+		{1, 0, [][]byte{}, false},
+		{0, 1, nil, true},
+		{5, 1, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := trak.ReadSamples(tt.start, tt.count)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReadSamples(%d, %d) = %q, %v; want %q", tt.start, tt.count, got, err, tt.want)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	samples := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), []byte("dddd"), []byte("eeeee")}
+	tests := []struct {
+		name   string
+		layout int
+		want   []Chunk // Offsets relative to the first sample.
+	}{
+		{"one chunk", 0, []Chunk{{Number: 1, FirstSample: 1, SampleCount: 5, Size: 15, DescriptionIndex: 1}}},
+		{"two per chunk", 2, []Chunk{
+			{Number: 1, Offset: 0, FirstSample: 1, SampleCount: 2, Size: 3, DescriptionIndex: 1},
+			{Number: 2, Offset: 3, FirstSample: 3, SampleCount: 2, Size: 7, DescriptionIndex: 1},
+			{Number: 3, Offset: 10, FirstSample: 5, SampleCount: 1, Size: 5, DescriptionIndex: 1},
+		}},
+		{"three per chunk", 3, []Chunk{
+			{Number: 1, Offset: 0, FirstSample: 1, SampleCount: 3, Size: 6, DescriptionIndex: 1},
+			{Number: 2, Offset: 6, FirstSample: 4, SampleCount: 2, Size: 9, DescriptionIndex: 1},
+		}},
+		{"one per chunk", 1, []Chunk{
+			{Number: 1, Offset: 0, FirstSample: 1, SampleCount: 1, Size: 1, DescriptionIndex: 1},
+			{Number: 2, Offset: 1, FirstSample: 2, SampleCount: 1, Size: 2, DescriptionIndex: 1},
+			{Number: 3, Offset: 3, FirstSample: 3, SampleCount: 1, Size: 3, DescriptionIndex: 1},
+			{Number: 4, Offset: 6, FirstSample: 4, SampleCount: 1, Size: 4, DescriptionIndex: 1},
+			{Number: 5, Offset: 10, FirstSample: 5, SampleCount: 1, Size: 5, DescriptionIndex: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio := testTrack{id: 1, handler: "soun", samples: [][]byte{[]byte("audio")}}
+			data := buildFile(audio, testTrack{id: 2, samples: samples, perChunk: tt.layout})
+			trak := parseFile(t, data).Moov.Traks[1]
+			base := int64(bytes.Index(data, []byte("abbccc")))
+
+			got, err := trak.Chunks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append([]Chunk(nil), tt.want...)
+			for i := range want {
+				want[i].Offset += base
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Chunks() = %+v, want %+v", got, want)
+			}
+
+			// Every chunk starts at its stco entry and holds its samples back to back.
+			stbl, err := trak.sampleTable()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(stbl.Stco.ChunksOffset) {
+				t.Fatalf("%d chunks, %d stco entries", len(got), len(stbl.Stco.ChunksOffset))
+			}
+			for i, c := range got {
+				if c.Offset != int64(stbl.Stco.ChunksOffset[i]) {
+					t.Errorf("chunk %d at offset %d, stco has %d", c.Number, c.Offset, stbl.Stco.ChunksOffset[i])
+				}
+				content := bytes.Join(samples[c.FirstSample-1:c.FirstSample-1+c.SampleCount], nil)
+				if chunk := data[c.Offset : c.Offset+int64(c.Size)]; !bytes.Equal(chunk, content) {
+					t.Errorf("chunk %d holds %q, want %q", c.Number, chunk, content)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleLookup(t *testing.T) {
+	var samples [][]byte
+	for i := 1; i <= 7; i++ {
+		samples = append(samples, bytes.Repeat([]byte{byte(i)}, i))
+	}
+	for _, perChunk := range []int{0, 1, 2, 3, 7} {
+		t.Run(fmt.Sprintf("%d per chunk", perChunk), func(t *testing.T) {
+			data := buildFile(testTrack{id: 1, samples: samples, perChunk: perChunk, sync: []uint32{1, 4}})
+			trak := parseFile(t, data).Moov.Traks[0]
+			all, err := trak.Samples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range all {
+				if got, err := trak.sample(want.Number); err != nil || got != want {
+					t.Errorf("sample(%d) = %+v, %v; want %+v", want.Number, got, err, want)
+				}
+			}
+			for _, number := range []uint32{0, uint32(len(samples) + 1)} {
+				if _, err := trak.sample(number); err == nil {
+					t.Errorf("sample(%d) succeeded", number)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// boxRule constrains the number of children of a container box. Names lists
+// alternative box types that are counted together, e.g. stsz and stz2.
+type boxRule struct {
+	names    []string
+	min, max int // max < 0 means no upper bound.
+}
+
+// boxRules holds the mandatory and quantity constraints of ISO/IEC 14496-12 for
+// the children of each container box. The key "" stands for the file itself.
+var boxRules = map[string][]boxRule{
+	"": {
+		{[]string{"ftyp"}, 1, 1},
+		{[]string{"moov"}, 1, 1},
+	},
+	"moov": {
+		{[]string{"mvhd"}, 1, 1},
+		{[]string{"trak"}, 1, -1},
+	},
+	"trak": {
+		{[]string{"tkhd"}, 1, 1},
+		{[]string{"tref"}, 0, 1},
+		{[]string{"edts"}, 0, 1},
+		{[]string{"mdia"}, 1, 1},
+	},
+	"edts": {
+		{[]string{"elst"}, 0, 1},
+	},
+	"mdia": {
+		{[]string{"mdhd"}, 1, 1},
+		{[]string{"hdlr"}, 1, 1},
+		{[]string{"minf"}, 1, 1},
+	},
+	"minf": {
+		{[]string{"dinf"}, 1, 1},
+		{[]string{"stbl"}, 1, 1},
+	},
+	"dinf": {
+		{[]string{"dref"}, 1, 1},
+	},
+	"stbl": {
+		{[]string{"stsd"}, 1, 1},
+		{[]string{"stts"}, 1, 1},
+		{[]string{"ctts"}, 0, 1},
+		{[]string{"stss"}, 0, 1},
+		{[]string{"stsc"}, 1, 1},
+		{[]string{"stsz", "stz2"}, 1, 1},
+		{[]string{"stco", "co64"}, 1, 1},
+		{[]string{"padb"}, 0, 1},
+		{[]string{"sdtp"}, 0, 1},
+		{[]string{"cslg"}, 0, 1},
+		{[]string{"stdp"}, 0, 1},
+	},
+	"meta": {
+		{[]string{"pitm"}, 0, 1},
+		{[]string{"iloc"}, 0, 1},
+		{[]string{"iinf"}, 0, 1},
+		{[]string{"idat"}, 0, 1},
+	},
+	"mvex": {
+		{[]string{"mehd"}, 0, 1},
+		{[]string{"trex"}, 1, -1},
+	},
+	"sinf": {
+		{[]string{"frma"}, 1, 1},
+		{[]string{"schm"}, 0, 1},
+		{[]string{"schi"}, 0, 1},
+	},
+	"moof": {
+		{[]string{"mfhd"}, 1, 1},
+	},
+	"traf": {
+		{[]string{"tfhd"}, 1, 1},
+		{[]string{"tfdt"}, 0, 1},
+	},
+}
+
+// checkChildren applies the rules of the container to its children.
+func checkChildren(container string, start int64, children []*Box) (errs []error) {
+	counts := make(map[string]int)
+	for _, child := range children {
+		counts[child.Name]++
+	}
+	where := "file"
+	if container != "" {
+		where = fmt.Sprintf("%s at offset %d", container, start)
+	}
+
+	for _, rule := range boxRules[container] {
+		n := 0
+		for _, name := range rule.names {
+			n += counts[name]
+		}
+		name := strings.Join(rule.names, " or ")
+		switch {
+		case n < rule.min && rule.min == rule.max:
+			errs = append(errs, fmt.Errorf("%s: expected exactly one %s, found none", where, name))
+		case n < rule.min:
+			errs = append(errs, fmt.Errorf("%s: expected at least %d %s, found %d", where, rule.min, name, n))
+		case rule.max >= 0 && n > rule.max:
+			errs = append(errs, fmt.Errorf("%s: expected at most %d %s, found %d", where, rule.max, name, n))
+		}
+	}
+	return errs
+}
+
+// Validate checks the file against the structural constraints of the format:
+// the mandatory boxes and their quantities in every known container, and the
+// agreement of the sample tables of each track, including chunks that point
+// past the end of the file. All problems found are
+// returned; an empty result means the file is well-formed as far as checked.
+func (m *Mp4Reader) Validate() []error {
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return []error{err}
+	}
+	errs := checkChildren("", 0, boxes)
+
+	var walk func(boxes []*Box, depth int)
+	walk = func(boxes []*Box, depth int) {
+		for _, box := range boxes {
+			if err := m.checkDepth(box, depth); err != nil {
+				errs = append(errs, err)
+				return
+			}
+			children, err := box.children()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s at offset %d: %v", box.Name, box.Start, err))
+				continue
+			}
+			errs = append(errs, checkChildren(box.Name, box.Start, children)...)
+			walk(children, depth+1)
+		}
+	}
+	walk(boxes, 0)
+
+	if m.Moov != nil {
+		for _, trak := range m.Moov.Traks {
+			errs = append(errs, validateSampleCounts(trak)...)
+			if _, err := trak.Samples(); err != nil {
+				errs = append(errs, fmt.Errorf("trak at offset %d: %v", trak.Start, err))
+			}
+		}
+	}
+	return errs
+}
+
+// validateSampleCounts checks that the sample tables of the track describe the
+// same number of samples.
+func validateSampleCounts(trak *TrackBox) (errs []error) {
+	stbl, err := trak.sampleTable()
+	if err != nil {
+		return nil
+	}
+	count := stbl.SampleCount()
+	if stbl.Stts != nil && stbl.Stts.SampleCount() != count {
+		errs = append(errs, fmt.Errorf("stbl at offset %d: stts describes %d samples, the sample size table %d",
+			stbl.Start, stbl.Stts.SampleCount(), count))
+	}
+	if stbl.Ctts != nil {
+		n := uint32(0)
+		for _, entry := range stbl.Ctts.Entries {
+			n += entry.SampleCount
+		}
+		if n != count {
+			errs = append(errs, fmt.Errorf("stbl at offset %d: ctts describes %d samples, the sample size table %d",
+				stbl.Start, n, count))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// VisualSampleEntry - The sample entry of video tracks, e.g. ‘avc1’
+// Box Type: ‘avc1’, ‘avc3’, ‘hvc1’, ‘hev1’, ‘encv’, ‘mp4v’, ‘av01’, ‘vp09’, ...
+// Container: Sample Description Box (‘stsd’)
+// Mandatory: Yes
+// Quantity: One or more
+type VisualSampleEntry struct {
+	*Box
+	DataReferenceIndex uint16
+	Width              uint16 // Coded width in pixels.
+	Height             uint16
+	HorizResolution    Fixed32 // Pixels per inch, 72 by default.
+	VertResolution     Fixed32
+	FrameCount         uint16 // Frames per sample, 1.
+	CompressorName     string // Informative name of the encoder, often empty.
+	Depth              uint16 // 0x0018 for colour images without alpha.
+	Avcc               *AVCConfigurationBox
+	Hvcc               *HEVCConfigurationBox
+	Btrt               *BitRateBox
+	Colr               *ColourInformationBox
+	Pasp               *PixelAspectRatioBox
+	Clap               *CleanApertureBox
+	Sinf               *ProtectionSchemeInfoBox // Protection of ‘encv’ entries.
+}
+
+func (b *VisualSampleEntry) parse() error {
+	if b.PayloadSize() < 78 {
+		return b.errorf("visual sample entry is too short")
+	}
+	data := b.Reader.ReadBytesAt(78, b.PayloadOffset())
+	if len(data) < 78 {
+		return b.errorf("reading visual sample entry failed")
+	}
+	// reserved [6]uint8 [0:6]
+	b.DataReferenceIndex = binary.BigEndian.Uint16(data[6:8])
+	// pre_defined uint16, reserved uint16 and pre_defined [3]uint32 [8:24]
+	b.Width = binary.BigEndian.Uint16(data[24:26])
+	b.Height = binary.BigEndian.Uint16(data[26:28])
+	b.HorizResolution = fixed32(data[28:32])
+	b.VertResolution = fixed32(data[32:36])
+	// reserved uint32 [36:40]
+	b.FrameCount = binary.BigEndian.Uint16(data[40:42])
+	b.CompressorName = compressorName(data[42:74])
+	b.Depth = binary.BigEndian.Uint16(data[74:76])
+
+	boxes, err := readBoxes(b.Reader, b.PayloadOffset()+78, b.PayloadSize()-78)
+	if err != nil {
+		return b.wrapError(err)
+	}
+	for _, box := range boxes {
+		switch box.Name {
+		case "avcC":
+			b.Avcc = &AVCConfigurationBox{Box: box}
+			parseBox(b.Avcc)
+		case "hvcC":
+			b.Hvcc = &HEVCConfigurationBox{Box: box}
+			parseBox(b.Hvcc)
+		case "btrt":
+			b.Btrt = &BitRateBox{Box: box}
+			parseBox(b.Btrt)
+		case "colr":
+			b.Colr = &ColourInformationBox{Box: box}
+			parseBox(b.Colr)
+		case "pasp":
+			b.Pasp = &PixelAspectRatioBox{Box: box}
+			parseBox(b.Pasp)
+		case "clap":
+			b.Clap = &CleanApertureBox{Box: box}
+			parseBox(b.Clap)
+		case "sinf":
+			if b.Sinf == nil {
+				b.Sinf = &ProtectionSchemeInfoBox{Box: box}
+				if err := parseBox(b.Sinf); err != nil {
+					return b.wrapError(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// compressorName decodes the 32-byte compressorname field, a Pascal string:
+// a length byte followed by the name, padded with zeros or spaces. Some writers
+// leave out the length byte and store a null-terminated string instead, which
+// shows as a length beyond the 31 bytes the field can hold.
+func compressorName(field []byte) string {
+	var name []byte
+	if n := int(field[0]); n < len(field) {
+		name = field[1 : 1+n]
+	} else {
+		name = field
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+	}
+	return string(bytes.TrimRight(name, "\x00 "))
+}
+
+// IsGrayscale reports whether the depth of the sample entry is one of the
+// QuickTime grayscale depths: 34, 36 and 40 for 2-, 4- and 8-bit gray.
+func (b *VisualSampleEntry) IsGrayscale() bool {
+	switch b.Depth {
+	case 34, 36, 40:
+		return true
+	}
+	return false
+}
+
+// CodedSize returns the width and height in pixels of the coded pictures of a
+// video track, as given by its sample entry. They can differ from the tkhd
+// dimensions, which are the presentation size. ok is false if the track has no
+// video sample entry.
+func (b *TrackBox) CodedSize() (width, height uint16, ok bool) {
+	entry := b.VisualSampleEntry()
+	if entry == nil {
+		return 0, 0, false
+	}
+	return entry.Width, entry.Height, true
+}
+
+// AVCConfigurationBox - This box contains the AVCDecoderConfigurationRecord (ISO/IEC 14496-15)
+// Box Type: ‘avcC’
+// Container: AVC Sample Entry (‘avc1’, ‘avc3’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type AVCConfigurationBox struct {
+	*Box
+	ConfigurationVersion uint8
+	Profile              uint8
+	ProfileCompatibility uint8
+	Level                uint8
+	LengthSize           uint8 // Size in bytes of the NAL unit length prefix.
+	SPS                  [][]byte
+	PPS                  [][]byte
+}
+
+func (b *AVCConfigurationBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 6 {
+		return b.errorf("box is too short")
+	}
+	b.ConfigurationVersion = data[0]
+	b.Profile = data[1]
+	b.ProfileCompatibility = data[2]
+	b.Level = data[3]
+	b.LengthSize = data[4]&0x03 + 1
+
+	var err error
+	offset := 6
+	if b.SPS, offset, err = readParameterSets(data, offset, int(data[5]&0x1f)); err != nil {
+		return b.wrapError(err)
+	}
+	if offset >= len(data) {
+		return b.errorf("missing picture parameter sets")
+	}
+	b.PPS, _, err = readParameterSets(data, offset+1, int(data[offset]))
+	return b.wrapError(err)
+}
+
+// readParameterSets reads count parameter sets, each prefixed with a 16-bit
+// length, starting at offset.
+func readParameterSets(data []byte, offset int, count int) ([][]byte, int, error) {
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if offset+2 > len(data) {
+			return sets, offset, fmt.Errorf("truncated parameter set")
+		}
+		size := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		offset += 2
+		if offset+size > len(data) {
+			return sets, offset, fmt.Errorf("truncated parameter set")
+		}
+		sets = append(sets, data[offset:offset+size])
+		offset += size
+	}
+	return sets, offset, nil
+}
+
+// ColourInformationBox - This box specifies the colour space of the decoded pictures
+// Box Type: ‘colr’
+// Container: Visual Sample Entry
+// Mandatory: No
+// Quantity: Zero or one
+type ColourInformationBox struct {
+	*Box
+	ColourType string // nclx, or nclc in QuickTime files, for coefficients; rICC or prof for ICC profiles.
+
+	// Code points of ISO/IEC 23091-2, e.g. 1 for BT.709 and 9 for BT.2020 primaries.
+	ColourPrimaries         uint16
+	TransferCharacteristics uint16
+	MatrixCoefficients      uint16
+	FullRange               bool // Only carried by nclx.
+
+	ICCProfile []byte
+}
+
+func (b *ColourInformationBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 4 {
+		return b.errorf("box is too short")
+	}
+	b.ColourType = string(data[0:4])
+	switch b.ColourType {
+	case "nclx", "nclc":
+		if len(data) < 10 {
+			return b.errorf("%s payload is too short", b.ColourType)
+		}
+		b.ColourPrimaries = binary.BigEndian.Uint16(data[4:6])
+		b.TransferCharacteristics = binary.BigEndian.Uint16(data[6:8])
+		b.MatrixCoefficients = binary.BigEndian.Uint16(data[8:10])
+		if b.ColourType == "nclx" && len(data) > 10 {
+			b.FullRange = data[10]&0x80 != 0
+		}
+	case "rICC", "prof":
+		b.ICCProfile = data[4:]
+	}
+	return nil
+}
+
+// PixelAspectRatioBox - This box specifies the aspect ratio of the pixels, hSpacing:vSpacing
+// Box Type: ‘pasp’
+// Container: Visual Sample Entry
+// Mandatory: No
+// Quantity: Zero or one
+type PixelAspectRatioBox struct {
+	*Box
+	HSpacing uint32
+	VSpacing uint32
+}
+
+func (b *PixelAspectRatioBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 8 {
+		return b.errorf("box is too short")
+	}
+	b.HSpacing = binary.BigEndian.Uint32(data[0:4])
+	b.VSpacing = binary.BigEndian.Uint32(data[4:8])
+	return nil
+}
+
+// CleanApertureBox - This box specifies the clean aperture, the part of the pictures meant to be displayed
+// Box Type: ‘clap’
+// Container: Visual Sample Entry
+// Mandatory: No
+// Quantity: Zero or one
+//
+// Each value is a fraction of numerator N and denominator D in pixels. The
+// offsets place the center of the clean aperture relative to the center of the
+// coded picture.
+type CleanApertureBox struct {
+	*Box
+	WidthN, WidthD   uint32
+	HeightN, HeightD uint32
+	HorizOffN        int32
+	HorizOffD        uint32
+	VertOffN         int32
+	VertOffD         uint32
+}
+
+func (b *CleanApertureBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 32 {
+		return b.errorf("box is too short")
+	}
+	b.WidthN = binary.BigEndian.Uint32(data[0:4])
+	b.WidthD = binary.BigEndian.Uint32(data[4:8])
+	b.HeightN = binary.BigEndian.Uint32(data[8:12])
+	b.HeightD = binary.BigEndian.Uint32(data[12:16])
+	b.HorizOffN = int32(binary.BigEndian.Uint32(data[16:20]))
+	b.HorizOffD = binary.BigEndian.Uint32(data[20:24])
+	b.VertOffN = int32(binary.BigEndian.Uint32(data[24:28]))
+	b.VertOffD = binary.BigEndian.Uint32(data[28:32])
+	return nil
+}
+
+// CleanAperture returns the part of the coded picture to display, in pixels,
+// from the clap box, rounded to whole pixels and clipped to the picture. It is
+// the whole picture when there is no clap box or its fractions are invalid.
+func (b *VisualSampleEntry) CleanAperture() image.Rectangle {
+	full := image.Rect(0, 0, int(b.Width), int(b.Height))
+	c := b.Clap
+	if c == nil || c.WidthD == 0 || c.HeightD == 0 || c.HorizOffD == 0 || c.VertOffD == 0 {
+		return full
+	}
+	width := float64(c.WidthN) / float64(c.WidthD)
+	height := float64(c.HeightN) / float64(c.HeightD)
+	left := (float64(b.Width)-width)/2 + float64(c.HorizOffN)/float64(c.HorizOffD)
+	top := (float64(b.Height)-height)/2 + float64(c.VertOffN)/float64(c.VertOffD)
+	r := image.Rect(int(math.Round(left)), int(math.Round(top)),
+		int(math.Round(left+width)), int(math.Round(top+height)))
+	return r.Intersect(full)
+}
+
+// DisplayWidth returns the width the pictures of the video track are displayed
+// at: the coded width stretched by the pixel aspect ratio of the pasp box.
+func (b *TrackBox) DisplayWidth() uint32 {
+	entry := b.VisualSampleEntry()
+	if entry == nil {
+		return 0
+	}
+	width := uint32(entry.Width)
+	if entry.Pasp != nil && entry.Pasp.HSpacing != 0 && entry.Pasp.VSpacing != 0 {
+		width = uint32(uint64(width) * uint64(entry.Pasp.HSpacing) / uint64(entry.Pasp.VSpacing))
+	}
+	return width
+}
+
+// DisplayHeight returns the height the pictures of the video track are displayed
+// at. DisplayWidth carries the pixel aspect ratio, so this is the coded height.
+func (b *TrackBox) DisplayHeight() uint32 {
+	entry := b.VisualSampleEntry()
+	if entry == nil {
+		return 0
+	}
+	return uint32(entry.Height)
+}
+
+// HEVC NAL unit types of the parameter sets.
+const (
+	hevcNALVPS = 32
+	hevcNALSPS = 33
+	hevcNALPPS = 34
+)
+
+// HEVCConfigurationBox - This box contains the HEVCDecoderConfigurationRecord (ISO/IEC 14496-15)
+// Box Type: ‘hvcC’
+// Container: HEVC Sample Entry (‘hvc1’, ‘hev1’)
+// Mandatory: Yes
+// Quantity: Exactly one
+type HEVCConfigurationBox struct {
+	*Box
+	ConfigurationVersion uint8
+	ProfileSpace         uint8
+	TierFlag             bool
+	ProfileIDC           uint8
+	LevelIDC             uint8
+	ChromaFormat         uint8
+	BitDepthLuma         uint8
+	BitDepthChroma       uint8
+	LengthSize           uint8 // Size in bytes of the NAL unit length prefix.
+	VPS                  [][]byte
+	SPS                  [][]byte
+	PPS                  [][]byte
+}
+
+func (b *HEVCConfigurationBox) parse() error {
+	data := b.ReadBoxData()
+	if len(data) < 23 {
+		return b.errorf("box is too short")
+	}
+	b.ConfigurationVersion = data[0]
+	b.ProfileSpace = data[1] >> 6
+	b.TierFlag = data[1]&0x20 != 0
+	b.ProfileIDC = data[1] & 0x1f
+	// general_profile_compatibility_flags [2:6], general_constraint_indicator_flags [6:12]
+	b.LevelIDC = data[12]
+	// min_spatial_segmentation_idc [13:15], parallelismType [15]
+	b.ChromaFormat = data[16] & 0x03
+	b.BitDepthLuma = data[17]&0x07 + 8
+	b.BitDepthChroma = data[18]&0x07 + 8
+	// avgFrameRate [19:21]
+	b.LengthSize = data[21]&0x03 + 1
+
+	offset := 23
+	for i := 0; i < int(data[22]); i++ {
+		if offset+3 > len(data) {
+			return b.errorf("truncated NAL unit array")
+		}
+		nalType := data[offset] & 0x3f
+		count := int(binary.BigEndian.Uint16(data[offset+1 : offset+3]))
+		sets, next, err := readParameterSets(data, offset+3, count)
+		if err != nil {
+			return b.wrapError(err)
+		}
+		offset = next
+		switch nalType {
+		case hevcNALVPS:
+			b.VPS = append(b.VPS, sets...)
+		case hevcNALSPS:
+			b.SPS = append(b.SPS, sets...)
+		case hevcNALPPS:
+			b.PPS = append(b.PPS, sets...)
+		}
+	}
+	return nil
+}
+
+// decoderConfig returns the NAL unit length size and the parameter sets to
+// repeat before sync samples, taken from avcC or hvcC.
+func (b *VisualSampleEntry) decoderConfig() (lengthSize int, sets [][]byte, err error) {
+	switch {
+	case b.Avcc != nil:
+		sets = append(sets, b.Avcc.SPS...)
+		sets = append(sets, b.Avcc.PPS...)
+		return int(b.Avcc.LengthSize), sets, nil
+	case b.Hvcc != nil:
+		sets = append(sets, b.Hvcc.VPS...)
+		sets = append(sets, b.Hvcc.SPS...)
+		sets = append(sets, b.Hvcc.PPS...)
+		return int(b.Hvcc.LengthSize), sets, nil
+	}
+	return 0, nil, fmt.Errorf("%s: sample entry has no avcC or hvcC box", b.Name)
+}
+
+// VisualSampleEntry returns the first video sample entry of the track, or nil if
+// the track has none.
+func (b *TrackBox) VisualSampleEntry() *VisualSampleEntry {
+	stbl, err := b.sampleTable()
+	if err != nil || stbl.Stsd == nil {
+		return nil
+	}
+	return stbl.Stsd.Visual
+}
+
+// annexBStartCode precedes every NAL unit of an Annex-B byte stream.
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// splitNALUnits splits a sample made of length-prefixed NAL units.
+func splitNALUnits(data []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize < 1 || lengthSize > 4 {
+		return nil, fmt.Errorf("invalid NAL unit length size %d", lengthSize)
+	}
+	var nals [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nals, fmt.Errorf("truncated NAL unit length")
+		}
+		size := 0
+		for _, c := range data[:lengthSize] {
+			size = size<<8 | int(c)
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return nals, fmt.Errorf("NAL unit of %d bytes exceeds the sample", size)
+		}
+		nals = append(nals, data[:size])
+		data = data[size:]
+	}
+	return nals, nil
+}
+
+// writeAnnexB writes the NAL units to w, each preceded by a start code.
+func writeAnnexB(w io.Writer, nals [][]byte) error {
+	for _, nal := range nals {
+		if _, err := w.Write(annexBStartCode); err != nil {
+			return err
+		}
+		if _, err := w.Write(nal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractAnnexB writes the samples of an H.264 or HEVC track to w as an Annex-B
+// byte stream, repeating the parameter sets from avcC (SPS, PPS) or hvcC (VPS,
+// SPS, PPS) before every sync sample. Samples are written one at a time as they
+// are read, so the stream is never held in memory; w is best buffered.
+func ExtractAnnexB(track *TrackBox, w io.Writer) error {
+	entry := track.VisualSampleEntry()
+	if entry == nil {
+		return fmt.Errorf("track has no video sample entry")
+	}
+	lengthSize, sets, err := entry.decoderConfig()
+	if err != nil {
+		return err
+	}
+
+	return track.forEachSample(func(sample Sample, data []byte) error {
+		nals, err := splitNALUnits(data, lengthSize)
+		if err != nil {
+			return fmt.Errorf("sample %d: %v", sample.Number, err)
+		}
+		if sample.IsSync {
+			if err := writeAnnexB(w, sets); err != nil {
+				return err
+			}
+		}
+		return writeAnnexB(w, nals)
+	})
+}
+
+// ExtractFrames writes every sample of an H.264 or HEVC track to its own
+// Annex-B file in dir, named by sample number: frame_00001.h264 or .h265. Sync
+// samples start with the parameter sets, as in ExtractAnnexB. Each file is
+// closed before the next one is written.
+func ExtractFrames(track *TrackBox, dir string) error {
+	entry := track.VisualSampleEntry()
+	if entry == nil {
+		return fmt.Errorf("track has no video sample entry")
+	}
+	lengthSize, sets, err := entry.decoderConfig()
+	if err != nil {
+		return err
+	}
+	ext := ".h264"
+	if entry.Hvcc != nil {
+		ext = ".h265"
+	}
+
+	var buf bytes.Buffer
+	return track.forEachSample(func(sample Sample, data []byte) error {
+		nals, err := splitNALUnits(data, lengthSize)
+		if err != nil {
+			return fmt.Errorf("sample %d: %v", sample.Number, err)
+		}
+		buf.Reset()
+		if sample.IsSync {
+			writeAnnexB(&buf, sets)
+		}
+		writeAnnexB(&buf, nals)
+		name := filepath.Join(dir, fmt.Sprintf("frame_%05d%s", sample.Number, ext))
+		return os.WriteFile(name, buf.Bytes(), 0644)
+	})
+}
+
+// ExtractKeyframe returns the nth sync sample of a video track, counted from 1,
+// as an Annex-B access unit preceded by the parameter sets of the sample entry.
+// Tracks without an stss box consist of sync samples only.
+func ExtractKeyframe(track *TrackBox, n int) ([]byte, error) {
+	entry := track.VisualSampleEntry()
+	if entry == nil {
+		return nil, fmt.Errorf("track has no video sample entry")
+	}
+	lengthSize, sets, err := entry.decoderConfig()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("keyframe %d out of range, keyframes are counted from 1", n)
+	}
+
+	samples, err := track.Samples()
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, sample := range samples {
+		if !sample.IsSync {
+			continue
+		}
+		if count++; count < n {
+			continue
+		}
+		data, err := track.readSample(sample)
+		if err != nil {
+			return nil, err
+		}
+		nals, err := splitNALUnits(data, lengthSize)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %v", sample.Number, err)
+		}
+		var buf bytes.Buffer
+		writeAnnexB(&buf, sets)
+		writeAnnexB(&buf, nals)
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("keyframe %d out of range, the track has %d", n, count)
+}
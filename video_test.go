@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// videoEntry parses a file with one video track whose avc1 sample entry has
+// the given extra children and returns that entry.
+func videoEntry(t *testing.T, width, height uint16, children ...[]byte) *VisualSampleEntry {
+	t.Helper()
+	entry := buildVisualEntry("avc1", width, height, append([][]byte{buildBox("avcC", testAVCC)}, children...)...)
+	m := parseFile(t, buildFile(testTrack{id: 1, width: width, height: height, entry: entry}))
+	visual := m.Moov.Traks[0].VisualSampleEntry()
+	if visual == nil {
+		t.Fatal("no visual sample entry")
+	}
+	return visual
+}
+
+func TestColourInformation(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    ColourInformationBox
+		wantErr bool
+	}{
+		{"nclx full range", cat([]byte("nclx"), be16(1), be16(1), be16(1), []byte{0x80}),
+			ColourInformationBox{ColourType: "nclx", ColourPrimaries: 1, TransferCharacteristics: 1, MatrixCoefficients: 1, FullRange: true}, false},
+		{"nclx limited range", cat([]byte("nclx"), be16(9), be16(16), be16(9), []byte{0}),
+			ColourInformationBox{ColourType: "nclx", ColourPrimaries: 9, TransferCharacteristics: 16, MatrixCoefficients: 9}, false},
+		{"nclc", cat([]byte("nclc"), be16(1), be16(1), be16(6)),
+			ColourInformationBox{ColourType: "nclc", ColourPrimaries: 1, TransferCharacteristics: 1, MatrixCoefficients: 6}, false},
+		{"ICC profile", cat([]byte("prof"), []byte("profile")),
+			ColourInformationBox{ColourType: "prof", ICCProfile: []byte("profile")}, false},
+		{"truncated nclx", cat([]byte("nclx"), be16(1)), ColourInformationBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			colr := videoEntry(t, 320, 240, buildBox("colr", tt.payload)).Colr
+			if colr == nil {
+				t.Fatal("no colr box")
+			}
+			if (colr.ParseErr != nil) != tt.wantErr {
+				t.Fatalf("parse error %v, want error %v", colr.ParseErr, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Box = colr.Box
+			if !reflect.DeepEqual(*colr, tt.want) {
+				t.Errorf("got %+v, want %+v", *colr, tt.want)
+			}
+		})
+	}
+}
+
+func TestPixelAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		children      [][]byte
+		width, height uint32
+	}{
+		{"no pasp", nil, 720, 576},
+		{"square pixels", [][]byte{buildBox("pasp", be32s(1, 1))}, 720, 576},
+		{"16:15", [][]byte{buildBox("pasp", be32s(16, 15))}, 768, 576},
+		{"anamorphic 64:45", [][]byte{buildBox("pasp", be32s(64, 45))}, 1024, 576},
+		{"zero spacing", [][]byte{buildBox("pasp", be32s(0, 1))}, 720, 576},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := buildVisualEntry("avc1", 720, 576, append([][]byte{buildBox("avcC", testAVCC)}, tt.children...)...)
+			trak := parseFile(t, buildFile(testTrack{id: 1, width: 720, height: 576, entry: entry})).Moov.Traks[0]
+			if w, h := trak.DisplayWidth(), trak.DisplayHeight(); w != tt.width || h != tt.height {
+				t.Errorf("display size %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestCleanAperture(t *testing.T) {
+	tests := []struct {
+		name string
+		clap []uint32 // widthN, widthD, heightN, heightD, horizOffN, horizOffD, vertOffN, vertOffD
+		want image.Rectangle
+	}{
+		{"no clap", nil, image.Rect(0, 0, 1920, 1088)},
+		{"centred crop", []uint32{1920, 1, 1080, 1, 0, 1, 0, 1}, image.Rect(0, 4, 1920, 1084)},
+		{"offset crop", []uint32{1280, 1, 720, 1, 0xffffff9c, 1, 20, 2}, image.Rect(220, 194, 1500, 914)},
+		{"fractional size", []uint32{3838, 2, 1080, 1, 0, 1, 0, 1}, image.Rect(1, 4, 1920, 1084)},
+		{"clipped", []uint32{4000, 1, 1088, 1, 0, 1, 0, 1}, image.Rect(0, 0, 1920, 1088)},
+		{"zero denominator", []uint32{1280, 0, 720, 1, 0, 1, 0, 1}, image.Rect(0, 0, 1920, 1088)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var children [][]byte
+			if tt.clap != nil {
+				children = append(children, buildBox("clap", be32s(tt.clap...)))
+			}
+			entry := videoEntry(t, 1920, 1088, children...)
+			if got := entry.CleanAperture(); got != tt.want {
+				t.Errorf("CleanAperture() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisualSampleEntryFields(t *testing.T) {
+	entry := func(depth uint16, name []byte) []byte {
+		field := make([]byte, 32)
+		copy(field, name)
+		return buildBox("avc1", cat(make([]byte, 6), be16(1), make([]byte, 16), be16(1280), be16(720),
+			be32s(0x00480000, 0x00480000, 0), be16(1), field, be16(depth), be16(0xffff), buildBox("avcC", testAVCC)))
+	}
+	tests := []struct {
+		name    string
+		entry   []byte
+		depth   uint16
+		encoder string
+	}{
+		{"colour", entry(0x18, cat([]byte{6}, []byte("x264 c"))), 0x18, "x264 c"},
+		{"with alpha", entry(0x20, cat([]byte{4}, []byte("Lavc"))), 0x20, "Lavc"},
+		{"no name", entry(0x18, nil), 0x18, ""},
+		{"longest name", entry(0x18, cat([]byte{31}, bytes.Repeat([]byte{'a'}, 31))), 0x18, strings.Repeat("a", 31)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trak := parseFile(t, buildFile(testTrack{id: 1, width: 640, height: 360, entry: tt.entry})).Moov.Traks[0]
+			visual := trak.VisualSampleEntry()
+			if visual.Depth != tt.depth || visual.CompressorName != tt.encoder {
+				t.Errorf("depth %#x, compressor %q; want %#x, %q", visual.Depth, visual.CompressorName, tt.depth, tt.encoder)
+			}
+			if visual.HorizResolution.Float64() != 72 || visual.VertResolution.Float64() != 72 || visual.FrameCount != 1 {
+				t.Errorf("resolution %vx%v, %d frames per sample; want 72x72 and 1",
+					visual.HorizResolution, visual.VertResolution, visual.FrameCount)
+			}
+			// The coded size of the entry differs from the tkhd presentation size.
+			if w, h, ok := trak.CodedSize(); !ok || w != 1280 || h != 720 {
+				t.Errorf("CodedSize() = %dx%d, %v; want 1280x720", w, h, ok)
+			}
+		})
+	}
+}
+
+func TestCompressorName(t *testing.T) {
+	field := func(name []byte) []byte {
+		f := make([]byte, 32)
+		copy(f, name)
+		return f
+	}
+	tests := []struct {
+		name  string
+		field []byte
+		want  string
+	}{
+		{"Pascal string", field(cat([]byte{6}, []byte("x264 c"))), "x264 c"},
+		{"padded with spaces", field(cat([]byte{8}, []byte("Lavc    "))), "Lavc"},
+		{"padded with zeros", field(cat([]byte{8}, []byte("Lavc"))), "Lavc"},
+		{"empty", field(nil), ""},
+		{"longest name", field(cat([]byte{31}, bytes.Repeat([]byte{'a'}, 31))), strings.Repeat("a", 31)},
+		{"null-terminated", field([]byte("AVC Coding\x00")), "AVC Coding"},
+		{"length byte beyond the field", field(cat([]byte{32}, []byte("abc"))), " abc"},
+		{"unterminated", bytes.Repeat([]byte{'z'}, 32), strings.Repeat("z", 32)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compressorName(tt.field); got != tt.want {
+				t.Errorf("compressorName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsGrayscale(t *testing.T) {
+	for depth, want := range map[uint16]bool{0x18: false, 0x20: false, 34: true, 36: true, 40: true, 33: false} {
+		if got := (&VisualSampleEntry{Depth: depth}).IsGrayscale(); got != want {
+			t.Errorf("IsGrayscale() at depth %d = %v, want %v", depth, got, want)
+		}
+	}
+}
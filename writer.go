@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// payloadMarshaler is implemented by parsed boxes that serialize their payload
+// from their fields, so that edits to those fields are written back.
+type payloadMarshaler interface {
+	marshalPayload() ([]byte, error)
+}
+
+func (b *FtypBox) marshalPayload() ([]byte, error) {
+	if len(b.MajorBrand) != 4 {
+		return nil, fmt.Errorf("%s: major brand %q is not a four-char code", b.Name, b.MajorBrand)
+	}
+	buf := make([]byte, 8, 8+4*len(b.CompatibleBrands))
+	copy(buf[0:4], b.MajorBrand)
+	binary.BigEndian.PutUint32(buf[4:8], b.MinorVersion)
+	for _, brand := range b.CompatibleBrands {
+		if len(brand) != 4 {
+			return nil, fmt.Errorf("%s: compatible brand %q is not a four-char code", b.Name, brand)
+		}
+		buf = append(buf, brand...)
+	}
+	return buf, nil
+}
+
+func (b *ChunkOffsetBox) marshalPayload() ([]byte, error) {
+	buf := make([]byte, 8+4*len(b.ChunksOffset))
+	buf[0] = b.Version
+	copy(buf[1:4], b.Flags[:])
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(b.ChunksOffset)))
+	for i, offset := range b.ChunksOffset {
+		binary.BigEndian.PutUint32(buf[8+4*i:], offset)
+	}
+	return buf, nil
+}
+
+func (b *ChunkLargeOffsetBox) marshalPayload() ([]byte, error) {
+	buf := make([]byte, 8+8*len(b.ChunksOffset))
+	buf[0] = b.Version
+	copy(buf[1:4], b.Flags[:])
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(b.ChunksOffset)))
+	for i, offset := range b.ChunksOffset {
+		binary.BigEndian.PutUint64(buf[8+8*i:], offset)
+	}
+	return buf, nil
+}
+
+// MoovBytes returns the moov box as stored in the file, header included. Edits
+// made to the parsed boxes are not applied; use Box.Marshal for that.
+func (m *Mp4Reader) MoovBytes() ([]byte, error) {
+	if m.Moov == nil {
+		return nil, fmt.Errorf("no moov box found")
+	}
+	buf := make([]byte, m.Moov.Size)
+	if _, err := m.Reader.ReadAt(buf, m.Moov.Start); err != nil {
+		return nil, m.Moov.wrapError(err)
+	}
+	return buf, nil
+}
+
+// RemoveBox excludes the box from the output of WriteTo and Box.Marshal.
+func (m *Mp4Reader) RemoveBox(box *Box) {
+	if m.removed == nil {
+		m.removed = make(map[int64]bool)
+	}
+	m.removed[box.Start] = true
+}
+
+// boxWriter serializes boxes of a file, taking the fields of the parsed boxes
+// and the removed boxes into account.
+type boxWriter struct {
+	m     *Mp4Reader
+	index map[int64]interface{}
+}
+
+func newBoxWriter(m *Mp4Reader) *boxWriter {
+	index := make(map[int64]interface{})
+	indexBoxes(reflect.ValueOf(m.Ftyp), index)
+	indexBoxes(reflect.ValueOf(m.Styp), index)
+	indexBoxes(reflect.ValueOf(m.Moov), index)
+	return &boxWriter{m: m, index: index}
+}
+
+// payload returns the serialized payload of a parsed box or a container whose
+// children have to be rebuilt, or ok false if the box can be copied verbatim.
+func (bw *boxWriter) payload(b *Box) (payload []byte, ok bool, err error) {
+	if typed, found := bw.index[b.Start].(payloadMarshaler); found {
+		payload, err = typed.marshalPayload()
+		return payload, true, err
+	}
+	skip, container := containerHeaderSize(b)
+	if !container {
+		return nil, false, nil
+	}
+	children, err := b.children()
+	if err != nil {
+		return nil, true, err
+	}
+	var buf bytes.Buffer
+	if skip > 0 {
+		buf.Write(b.Reader.ReadBytesAt(skip, b.PayloadOffset()))
+	}
+	count := uint32(0)
+	for _, child := range children {
+		if bw.m.removed[child.Start] {
+			continue
+		}
+		if _, err := bw.write(&buf, child); err != nil {
+			return nil, true, err
+		}
+		count++
+	}
+	// Keep what follows the last child, such as the zero terminator of
+	// QuickTime atom lists.
+	end := b.PayloadOffset() + skip
+	if len(children) > 0 {
+		last := children[len(children)-1]
+		end = last.Start + last.Size
+	}
+	if tail := b.Start + b.Size - end; tail > 0 {
+		buf.Write(b.Reader.ReadBytesAt(tail, end))
+	}
+	// Sample entry lists end their header with the entry count.
+	if skip == 8 && (b.Name == "stsd" || b.Name == "dref") {
+		binary.BigEndian.PutUint32(buf.Bytes()[4:8], count)
+	}
+	return buf.Bytes(), true, nil
+}
+
+func (bw *boxWriter) write(w io.Writer, b *Box) (int64, error) {
+	payload, rebuilt, err := bw.payload(b)
+	if err != nil {
+		return 0, err
+	}
+	if !rebuilt {
+		// Unknown boxes, mdat included, are copied from the input as they are.
+		return io.Copy(w, io.NewSectionReader(b.Reader.Reader, b.Start, b.Size))
+	}
+
+	size := BoxHeaderSize + int64(len(payload))
+	if size > math.MaxUint32 {
+		return 0, fmt.Errorf("box %q of %d bytes is too large", b.Name, size)
+	}
+	header := make([]byte, BoxHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(size))
+	copy(header[4:8], b.Name)
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	p, err := w.Write(payload)
+	return int64(n + p), err
+}
+
+// Marshal writes the box to w. Container boxes are rebuilt from their children
+// with recomputed sizes, parsed boxes that support it are serialized from their
+// fields and every other box is copied verbatim from the input.
+func (b *Box) Marshal(w io.Writer) (int64, error) {
+	return newBoxWriter(b.Reader).write(w, b)
+}
+
+// WriteTo writes the file to w, leaving out the boxes removed with RemoveBox
+// and applying the edits made to the fields of parsed boxes. Chunk offsets are
+// copied as they are, so edits that change the size of the boxes preceding the
+// media data invalidate them.
+func (m *Mp4Reader) WriteTo(w io.Writer) (n int64, err error) {
+	boxes, err := readBoxes(m, 0, m.Size)
+	if err != nil {
+		return 0, err
+	}
+	bw := newBoxWriter(m)
+	for _, box := range boxes {
+		if m.removed[box.Start] {
+			continue
+		}
+		written, err := bw.write(w, box)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}